@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/bdougie/vision/internal/analyzer"
+	"github.com/bdougie/vision/internal/config"
+	"github.com/bdougie/vision/internal/extractor"
+	"github.com/bdougie/vision/internal/storage"
+)
+
+// timestampList collects positions in a video from comma separated or repeated flags
+type timestampList []int64
+
+func (l *timestampList) String() string {
+	formatted := make([]string, len(*l))
+	for i, ms := range *l {
+		formatted[i] = formatTimestamp(ms)
+	}
+	return strings.Join(formatted, ",")
+}
+
+func (l *timestampList) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		ms, err := parseTimestamp(strings.TrimSpace(part))
+		if err != nil {
+			return err
+		}
+		*l = append(*l, ms)
+	}
+	return nil
+}
+
+// runAnalyze handles "analyze <video>"
+func runAnalyze(args []string) {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	videoPathFlag := fs.String("video", "", "Path to the video file")
+	retryFailed := fs.Bool("retry-failed", false, "Only analyze frames that failed or were skipped in an earlier run")
+	resume := fs.Bool("resume", true, "Skip frames that already have a stored analysis")
+	force := fs.Bool("force", false, "Extract frames again and reanalyze all of them")
+	queue := fs.Bool("queue", false, "Queue the frames for worker processes instead of analyzing them (PostgreSQL)")
+	var start, end timestampFlag
+	var at timestampList
+	fs.Var(&start, "start", "Only extract frames from this position on (seconds or h:mm:ss)")
+	fs.Var(&end, "end", "Only extract frames before this position (seconds or h:mm:ss)")
+	fs.Var(&at, "at", "Grab single frames at these positions instead of sampling the video, comma separated or repeated")
+	common := registerCommonFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: visionanalyzer analyze [flags] <video>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	videoPath := *videoPathFlag
+	if videoPath == "" && fs.NArg() == 1 {
+		videoPath = fs.Arg(0)
+	}
+	if videoPath == "" || fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if len(at) > 0 && (start.ms > 0 || end.ms > 0) {
+		log.Fatalf("--at cannot be combined with --start or --end")
+	}
+
+	cfg := common.load(fs)
+	if *queue && cfg.Storage.Backend != config.BackendPostgres {
+		log.Fatalf("Queueing frames requires the PostgreSQL backend (storage.backend: postgres or DB_ENABLED=true)")
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	logger := newLogger()
+
+	// Probe the video, its fingerprint identifies it in storage
+	video, err := extractor.ProbeVideo(ctx, videoPath)
+	if err != nil {
+		log.Fatalf("Failed to probe video: %v", err)
+	}
+
+	// Initialize the appropriate storage
+	var store storage.Storage
+	if cfg.Storage.Backend == config.BackendPostgres {
+		// The embedding model decides the size of the stored vectors
+		embedder := newEmbedder(ctx, cfg)
+
+		// Bring the database schema up to date
+		if err := storage.Migrate(ctx, cfg.Postgres(), embedder.Dimension()); err != nil {
+			log.Fatalf("Failed to initialize database schema: %v", err)
+		}
+
+		pgStorage, err := storage.NewPostgresStorage(ctx, cfg.Postgres(), *video, embedder)
+		if err != nil {
+			log.Fatalf("Failed to create PostgreSQL storage: %v", err)
+		}
+		defer pgStorage.Close()
+		store = pgStorage
+	} else if cfg.Storage.Backend == config.BackendSQLite {
+		sqliteStorage, err := storage.NewSQLiteStorage(ctx, cfg.Storage.SQLite.Path, *video, newEmbedder(ctx, cfg))
+		if err != nil {
+			log.Fatalf("Failed to create SQLite storage: %v", err)
+		}
+		defer sqliteStorage.Close()
+		store = sqliteStorage
+	} else {
+		store = storage.NewFileStorage(cfg.Storage.OutputDir, video.Key(), optionalEmbedder(ctx, cfg))
+	}
+
+	// Initialize the vision provider, queued frames are analyzed by workers
+	var visionProvider analyzer.VisionProvider
+	if !*queue {
+		visionProvider, err = analyzer.NewVisionProvider(ctx, cfg.VisionProvider(), &logger)
+		if err != nil {
+			log.Fatalf("Failed to initialize vision provider: %v", err)
+		}
+	}
+
+	// Process video
+	fmt.Printf("Starting video analysis...\n")
+	processorOpts := cfg.ProcessorOptions()
+	processorOpts.RetryFailed = *retryFailed
+	processorOpts.Resume = *resume
+	processorOpts.Force = *force
+	processorOpts.Enqueue = *queue
+	processorOpts.Extract.StartMs = start.ms
+	processorOpts.Extract.EndMs = end.ms
+	processorOpts.Timestamps = at
+	processorOpts.Logger = logger
+	processor, err := analyzer.NewProcessor(visionProvider, store, processorOpts)
+	if err != nil {
+		log.Fatalf("Failed to create processor: %v", err)
+	}
+	if err := processor.ProcessVideo(ctx, video, cfg.Storage.OutputDir); err != nil {
+		if ctx.Err() != nil {
+			log.Printf("%v", err)
+			fmt.Println("Finished frames were saved, run the same command again to analyze the rest")
+			os.Exit(130)
+		}
+		log.Printf("Error processing video: %v", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Video processing completed successfully!")
+}
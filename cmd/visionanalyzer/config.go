@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/bdougie/vision/internal/config"
+)
+
+// overrideFlags are command line flags that take precedence over the config file and environment
+type overrideFlags struct {
+	extraction     bool
+	output         *string
+	mode           *string
+	interval       *int
+	sceneThreshold *float64
+	minGap         *float64
+	maxGap         *float64
+	provider       *string
+	endpoint       *string
+	model          *string
+	workers        *int
+	structured     *bool
+	quality        *bool
+	qualityAction  *string
+	dedup          *bool
+	dedupDistance  *int
+}
+
+// registerOverrideFlags defines the override flags on fs, defaults are shown for reference only.
+// The extraction flags are left out unless extraction is set, commands that
+// do not extract frames may use their names for flags of their own.
+func registerOverrideFlags(fs *flag.FlagSet, extraction bool) *overrideFlags {
+	defaults := config.Default()
+	o := &overrideFlags{
+		extraction:    extraction,
+		output:        fs.String("output", defaults.Storage.OutputDir, "Output directory for frames"),
+		provider:      fs.String("provider", defaults.Provider.Type, "Vision provider: ollama or openai"),
+		endpoint:      fs.String("endpoint", "", "Base URL of the vision provider API"),
+		model:         fs.String("model", defaults.Provider.Model, "Vision model name"),
+		workers:       fs.Int("workers", defaults.Workers, "Number of frames analyzed concurrently"),
+		structured:    fs.Bool("structured", defaults.Structured.Enabled, "Ask the model for typed JSON frame analyses"),
+		quality:       fs.Bool("quality", defaults.Quality.Enabled, "Leave black, blurred and blank frames out of the analysis"),
+		qualityAction: fs.String("quality-action", defaults.Quality.Action, "What --quality does with a bad frame: drop or replace it with a good frame nearby"),
+		dedup:         fs.Bool("dedup", defaults.Dedup.Enabled, "Skip frames that look like the last frame analyzed"),
+		dedupDistance: fs.Int("dedup-distance", defaults.Dedup.MaxDistance, "Largest perceptual hash distance (0-64) of frames skipped by --dedup"),
+	}
+	if extraction {
+		o.mode = fs.String("mode", defaults.Extraction.Mode, "Frame extraction mode: interval or scene")
+		o.interval = fs.Int("interval", defaults.Extraction.Interval, "Seconds between frames in interval mode")
+		o.sceneThreshold = fs.Float64("scene-threshold", defaults.Extraction.SceneThreshold, "Scene change score (0-1) that triggers a frame in scene mode")
+		o.minGap = fs.Float64("min-gap", defaults.Extraction.MinGap, "Minimum seconds between frames in scene mode")
+		o.maxGap = fs.Float64("max-gap", defaults.Extraction.MaxGap, "Maximum seconds without a frame in scene mode (0 disables)")
+	}
+	return o
+}
+
+// apply copies the flags that were set explicitly into cfg
+func (o *overrideFlags) apply(fs *flag.FlagSet, cfg *config.Config) {
+	fs.Visit(func(f *flag.Flag) {
+		if o.extraction {
+			o.applyExtraction(f.Name, cfg)
+		}
+		switch f.Name {
+		case "output":
+			cfg.Storage.OutputDir = *o.output
+		case "provider":
+			cfg.Provider.Type = *o.provider
+		case "endpoint":
+			cfg.Provider.BaseURL = *o.endpoint
+		case "model":
+			cfg.Provider.Model = *o.model
+		case "workers":
+			cfg.Workers = *o.workers
+		case "structured":
+			cfg.Structured.Enabled = *o.structured
+		case "quality":
+			cfg.Quality.Enabled = *o.quality
+		case "quality-action":
+			cfg.Quality.Action = *o.qualityAction
+		case "dedup":
+			cfg.Dedup.Enabled = *o.dedup
+		case "dedup-distance":
+			cfg.Dedup.MaxDistance = *o.dedupDistance
+		}
+	})
+}
+
+// applyExtraction copies an extraction flag into cfg
+func (o *overrideFlags) applyExtraction(name string, cfg *config.Config) {
+	switch name {
+	case "mode":
+		cfg.Extraction.Mode = *o.mode
+	case "interval":
+		cfg.Extraction.Interval = *o.interval
+	case "scene-threshold":
+		cfg.Extraction.SceneThreshold = *o.sceneThreshold
+	case "min-gap":
+		cfg.Extraction.MinGap = *o.minGap
+	case "max-gap":
+		cfg.Extraction.MaxGap = *o.maxGap
+	}
+}
+
+// runConfig implements `visionanalyzer config print`
+func runConfig(args []string) {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Println("Usage: visionanalyzer config print [--config visionanalyzer.yaml] [overrides...]")
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("config print", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("VISION_CONFIG"), "Path to a YAML config file")
+	overrides := registerOverrideFlags(fs, true)
+	fs.Parse(args[1:])
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	overrides.apply(fs, cfg)
+
+	data, err := cfg.Dump()
+	if err != nil {
+		log.Fatalf("Failed to render configuration: %v", err)
+	}
+	fmt.Print(string(data))
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "\n%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -6,182 +6,219 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"log/slog"
 
 	"github.com/go-logr/logr"
 	"github.com/lmittmann/tint"
 
-	"github.com/bdougie/vision/internal/analyzer"
+	"github.com/bdougie/vision/internal/config"
+	"github.com/bdougie/vision/internal/embeddings"
+	"github.com/bdougie/vision/internal/extractor"
 	"github.com/bdougie/vision/internal/models"
 	"github.com/bdougie/vision/internal/storage"
 )
 
-func main() {
-    // Initialize flag package before using it
-    searchQuery := flag.String("search", "", "Search for frames matching this description (uses vector similarity)")
-    textSearch := flag.String("text-search", "", "Search for frames containing this text (exact match)")
-    searchLimit := flag.Int("limit", 5, "Maximum number of search results")
-    videoPathFlag := flag.String("video", "", "Path to the video file")
-    outputDirFlag := flag.String("output", "output_frames", "Output directory for frames")
-    flag.Parse()
-
-    ctx := context.Background()
-
-    // Configure logger
-    logger := logr.FromSlogHandler(
-        tint.NewHandler(os.Stderr, &tint.Options{
-            Level:      slog.LevelDebug,
-            TimeFormat: "15:04:05",
-        }),
-    )
+const usage = `Usage: visionanalyzer <command> [flags]
+
+Commands:
+  analyze <video>               Extract and analyze the frames of a video
+  search <query>                Search stored frames (--text for exact matches)
+  videos list                   List stored videos
+  frames show <video> [frame]   Show the stored frames of a video
+  export <video>                Write the stored analyses of a video as JSON or CSV
+  delete <video>                Remove a video and everything stored about it
+  reindex [video]               Embed stored analyses again with the current model
+  serve                         Run the HTTP API for analysis jobs and search
+  worker                        Analyze frames queued with "analyze --queue"
+  queue status                  Show the frames waiting for workers
+  config print                  Print the effective configuration
+  migrate status|up|down        Manage the PostgreSQL schema
+
+A <video> is a file path, a video key, a fingerprint prefix or a stored name.
+Run "visionanalyzer <command> -h" for the flags of a command.
+`
 
-    // Get video path from flags or command line arguments
-    videoPath := *videoPathFlag
-    outputDir := *outputDirFlag
-
-    // For backward compatibility, also support parsing from os.Args
-    if videoPath == "" {
-        for i := 1; i < len(os.Args); i++ {
-            switch os.Args[i] {
-            case "--video":
-                if i+1 < len(os.Args) {
-                    videoPath = os.Args[i+1]
-                    i++
-                }
-            case "--output":
-                if i+1 < len(os.Args) {
-                    outputDir = os.Args[i+1]
-                    i++
-                }
-            }
-        }
+func main() {
+    if len(os.Args) < 2 {
+        fmt.Print(usage)
+        os.Exit(1)
     }
 
-    // Ensure video path is provided
-    if videoPath == "" {
-        fmt.Println("Usage: visionanalyzer --video path/to/video.mp4 [--output output_directory]")
+    command, args := os.Args[1], os.Args[2:]
+    switch command {
+    case "analyze":
+        runAnalyze(args)
+    case "search":
+        runSearch(args)
+    case "videos":
+        runVideos(args)
+    case "frames":
+        runFrames(args)
+    case "export":
+        runExport(args)
+    case "delete":
+        runDelete(args)
+    case "reindex":
+        runReindex(args)
+    case "serve":
+        runServe(args)
+    case "worker":
+        runWorker(args)
+    case "queue":
+        runQueue(args)
+    case "config":
+        runConfig(args)
+    case "migrate":
+        runMigrate(args)
+    case "help", "-h", "--help":
+        fmt.Print(usage)
+    default:
+        // Flags without a command are the old single-command interface
+        if strings.HasPrefix(command, "-") {
+            runAnalyze(os.Args[1:])
+            return
+        }
+        fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n%s", command, usage)
         os.Exit(1)
     }
+}
 
-    // Check if PostgreSQL is enabled
-    dbEnabled := os.Getenv("DB_ENABLED") == "true"
-
-    // After parsing the video path, set the videoName
-    videoName := strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath))
-
-    // Initialize the appropriate storage
-    var store storage.Storage
-    if dbEnabled {
-        // Get PostgreSQL configuration from environment
-        pgConfig := storage.PostgresConfig{
-            Host:     getEnvOrDefault("DB_HOST", "localhost"),
-            Port:     getEnvOrDefault("DB_PORT", "5432"),
-            User:     getEnvOrDefault("DB_USER", "postgres"),
-            Password: getEnvOrDefault("DB_PASSWORD", "postgres"),
-            DBName:   getEnvOrDefault("DB_NAME", "vision_analysis"),
-        }
+// commonFlags are accepted by every command that reads the configuration
+type commonFlags struct {
+    configPath *string
+    overrides  *overrideFlags
+}
 
-        // Initialize database schema if needed
-        if err := storage.InitSchema(ctx, pgConfig); err != nil {
-            log.Fatalf("Failed to initialize database schema: %v", err)
-        }
+func registerCommonFlags(fs *flag.FlagSet) *commonFlags {
+    return newCommonFlags(fs, true)
+}
 
-        // Create PostgreSQL storage
-        pgStorage, err := storage.NewPostgresStorage(ctx, pgConfig, videoName)
-        if err != nil {
-            log.Fatalf("Failed to create PostgreSQL storage: %v", err)
+// registerQueryFlags is registerCommonFlags for commands that only read
+// results, without the frame extraction overrides
+func registerQueryFlags(fs *flag.FlagSet) *commonFlags {
+    return newCommonFlags(fs, false)
+}
+
+func newCommonFlags(fs *flag.FlagSet, extraction bool) *commonFlags {
+    return &commonFlags{
+        configPath: fs.String("config", os.Getenv("VISION_CONFIG"), "Path to a YAML config file (default: "+config.DefaultPath+" if present)"),
+        overrides:  registerOverrideFlags(fs, extraction),
+    }
+}
+
+// load reads the configuration: defaults, config file, environment, then flags
+func (c *commonFlags) load(fs *flag.FlagSet) *config.Config {
+    cfg, err := config.Load(*c.configPath)
+    if err != nil {
+        log.Fatalf("Failed to load configuration: %v", err)
+    }
+    c.overrides.apply(fs, cfg)
+    if err := cfg.Validate(); err != nil {
+        log.Fatalf("%v", err)
+    }
+    return cfg
+}
+
+// interruptContext returns a context cancelled by Ctrl-C or SIGTERM and a
+// function releasing it. A second Ctrl-C terminates the process without
+// waiting for the work in flight to be stored.
+func interruptContext() (context.Context, func()) {
+    ctx, cancel := context.WithCancel(context.Background())
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+    go func() {
+        select {
+        case <-signals:
+            signal.Stop(signals)
+            fmt.Fprintln(os.Stderr, "\nStopping, press Ctrl-C again to quit immediately")
+            cancel()
+        case <-ctx.Done():
         }
-        defer pgStorage.Close()
-        store = pgStorage
-    } else {
-        // Use file-based storage
-        store = storage.NewFileStorage(outputDir, videoName)
+    }()
+    return ctx, func() {
+        signal.Stop(signals)
+        cancel()
     }
+}
+
+// newLogger creates the colored logger used for provider and embedder messages
+func newLogger() logr.Logger {
+    return logr.FromSlogHandler(
+        tint.NewHandler(os.Stderr, &tint.Options{
+            Level:      slog.LevelDebug,
+            TimeFormat: "15:04:05",
+        }),
+    )
+}
 
-    // Initialize agent
-    visionAgent, err := analyzer.NewAgent(ctx, &logger)
+// newEmbedder connects to the configured embedding model
+func newEmbedder(ctx context.Context, cfg *config.Config) embeddings.Embedder {
+    logger := newLogger()
+    embedder, err := embeddings.NewEmbedder(ctx, cfg.Embedder(), &logger)
     if err != nil {
-        log.Fatalf("Failed to initialize vision agent: %v", err)
+        log.Fatalf("Failed to initialize embedder: %v", err)
     }
+    return embedder
+}
 
-    // Process video
-    fmt.Printf("Starting video analysis...\n")
-    processor := analyzer.NewProcessor(visionAgent, store)
-    err = processor.ProcessVideo(ctx, videoPath, outputDir)
+// optionalEmbedder connects to the configured embedding model if it is
+// available. File storage works without one, its results are then only found
+// by text search.
+func optionalEmbedder(ctx context.Context, cfg *config.Config) embeddings.Embedder {
+    logger := newLogger()
+    embedder, err := embeddings.NewEmbedder(ctx, cfg.Embedder(), &logger)
     if err != nil {
-        log.Printf("Error processing video: %v", err)
-        os.Exit(1)
+        fmt.Printf("Warning: %v, results are stored without embeddings and only found by text search\n", err)
+        return nil
     }
+    return embedder
+}
 
-    fmt.Println("Video processing completed successfully!")
-
-    // Handle search query if provided and DB is enabled
-    if (*searchQuery != "" || *textSearch != "") && dbEnabled {
-        // Get PostgreSQL configuration
-        pgConfig := storage.PostgresConfig{
-            Host:     getEnvOrDefault("DB_HOST", "localhost"),
-            Port:     getEnvOrDefault("DB_PORT", "5432"),
-            User:     getEnvOrDefault("DB_USER", "postgres"),
-            Password: getEnvOrDefault("DB_PASSWORD", "postgres"),
-            DBName:   getEnvOrDefault("DB_NAME", "vision_analysis"),
+// openCatalog opens the stored data of the configured backend and returns a
+// function releasing it. Every catalog also implements storage.Reindexer.
+func openCatalog(ctx context.Context, cfg *config.Config, embedder embeddings.Embedder) (storage.Catalog, func()) {
+    switch cfg.Storage.Backend {
+    case config.BackendPostgres:
+        pgStorage, err := storage.OpenPostgresStorage(ctx, cfg.Postgres(), embedder)
+        if err != nil {
+            log.Fatalf("Failed to open PostgreSQL storage: %v", err)
         }
-
-        // Create PostgreSQL storage for search (if not already created)
-        var pgStorage *storage.PostgresStorage
-        if s, ok := store.(*storage.PostgresStorage); ok {
-            pgStorage = s
-        } else {
-            var err error
-            pgStorage, err = storage.NewPostgresStorage(ctx, pgConfig, videoName)
-            if err != nil {
-                log.Fatalf("Failed to create PostgreSQL storage: %v", err)
-            }
-            defer pgStorage.Close()
+        return pgStorage, pgStorage.Close
+    case config.BackendSQLite:
+        sqliteStorage, err := storage.OpenSQLiteStorage(ctx, cfg.Storage.SQLite.Path, embedder)
+        if err != nil {
+            log.Fatalf("Failed to open SQLite storage: %v", err)
         }
+        return sqliteStorage, sqliteStorage.Close
+    default:
+        return storage.NewFileCatalog(cfg.Storage.OutputDir, embedder), func() {}
+    }
+}
 
-        var results []models.FrameSearchResult
-        var err error
-        
-        if *searchQuery != "" {
-            // Vector similarity search
-            fmt.Printf("Searching for frames matching: %s\n", *searchQuery)
-            results, err = pgStorage.SearchSimilarFrames(ctx, *searchQuery, *searchLimit)
-        } else {
-            // Text search
-            fmt.Printf("Searching for frames containing text: %s\n", *textSearch)
-            results, err = pgStorage.TextSearchFrames(ctx, *textSearch, *searchLimit)
-        }
-        
+// findVideo resolves a command line video reference. Existing files are
+// matched by content fingerprint, anything else by key, fingerprint or name.
+func findVideo(ctx context.Context, catalog storage.Catalog, ref string) *models.VideoMetadata {
+    lookup := ref
+    if info, err := os.Stat(ref); err == nil && !info.IsDir() {
+        fingerprint, err := extractor.Fingerprint(ref)
         if err != nil {
-            log.Printf("Search error: %v", err)
-            os.Exit(1)
+            log.Fatalf("Failed to fingerprint '%s': %v", ref, err)
         }
+        lookup = fingerprint
+    }
 
-        // Display results
-        fmt.Printf("Found %d matching frames:\n", len(results))
-        for i, result := range results {
-            similarity := result.Similarity * 100
-            var similarityText string
-            
-            if *textSearch != "" {
-                similarityText = "(text match)"
-            } else {
-                similarityText = fmt.Sprintf("(%.2f%% similarity)", similarity)
-            }
-            
-            fmt.Printf("%d. Frame %d %s\n", i+1, result.FrameNumber, similarityText)
-            fmt.Printf("   Description: %s\n\n", result.Description)
-        }
+    video, err := catalog.FindVideo(ctx, lookup)
+    if err != nil {
+        log.Fatalf("%v", err)
     }
+    return video
 }
 
-// Helper function to get environment variables with defaults
-func getEnvOrDefault(key, defaultValue string) string {
-    if value, exists := os.LookupEnv(key); exists {
-        return value
-    }
-    return defaultValue
+// formatTimestamp renders a frame timestamp as h:mm:ss.mmm
+func formatTimestamp(ms int64) string {
+    return fmt.Sprintf("%d:%02d:%02d.%03d", ms/3600000, (ms/60000)%60, (ms/1000)%60, ms%1000)
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/bdougie/vision/internal/config"
+	"github.com/bdougie/vision/internal/storage"
+)
+
+// runMigrate handles "migrate status|up|down"
+func runMigrate(args []string) {
+	if len(args) == 0 || (args[0] != "status" && args[0] != "up" && args[0] != "down") {
+		fmt.Println("Usage: visionanalyzer migrate status|up|down [--config visionanalyzer.yaml]")
+		fmt.Println("       visionanalyzer migrate up [--to version]")
+		fmt.Println("       visionanalyzer migrate down [--steps n]")
+		os.Exit(1)
+	}
+	command := args[0]
+
+	fs := flag.NewFlagSet("migrate "+command, flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("VISION_CONFIG"), "Path to a YAML config file")
+	target := fs.Int("to", 0, "Apply migrations up to this version (default: latest)")
+	steps := fs.Int("steps", 1, "Number of migrations to revert")
+	fs.Parse(args[1:])
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	migrator, err := storage.NewMigrator(ctx, cfg.Postgres())
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer migrator.Close(ctx)
+
+	switch command {
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+		for _, status := range statuses {
+			state := "pending"
+			switch {
+			case status.Unknown:
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05") + " (unknown to this build)"
+			case status.Modified:
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05") + " (changed since)"
+			case status.AppliedAt != nil:
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", status.Version, status.Name, state)
+		}
+	case "up":
+		applied, err := migrator.Up(ctx, *target)
+		for _, migration := range applied {
+			fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+	case "down":
+		if *steps <= 0 {
+			log.Fatalf("--steps must be positive, got %d", *steps)
+		}
+		reverted, err := migrator.Down(ctx, *steps)
+		for _, migration := range reverted {
+			fmt.Printf("Reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if len(reverted) == 0 {
+			fmt.Println("No applied migrations to revert")
+		}
+	}
+}
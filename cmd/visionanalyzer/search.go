@@ -0,0 +1,212 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bdougie/vision/internal/embeddings"
+	"github.com/bdougie/vision/internal/models"
+)
+
+// stringList is a flag that may be repeated
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// dateFlag is a time flag accepting a date or an RFC 3339 timestamp
+type dateFlag struct {
+	t *time.Time
+}
+
+func (d *dateFlag) String() string {
+	if d.t == nil {
+		return ""
+	}
+	return d.t.Format(time.RFC3339)
+}
+
+func (d *dateFlag) Set(value string) error {
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			d.t = &t
+			return nil
+		}
+	}
+	return fmt.Errorf("expected YYYY-MM-DD or an RFC 3339 timestamp")
+}
+
+// timestampFlag is a position in a video given as seconds or [h:]mm:ss[.mmm]
+type timestampFlag struct {
+	ms int64
+}
+
+func (t *timestampFlag) String() string {
+	if t.ms == 0 {
+		return ""
+	}
+	return formatTimestamp(t.ms)
+}
+
+func (t *timestampFlag) Set(value string) error {
+	ms, err := parseTimestamp(value)
+	if err != nil {
+		return err
+	}
+	t.ms = ms
+	return nil
+}
+
+// parseTimestamp reads seconds ("90", "90.5") or clock notation ("1:30",
+// "0:01:30.500") as milliseconds
+func parseTimestamp(value string) (int64, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("expected seconds or [h:]mm:ss[.mmm]")
+	}
+	var seconds float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 || (i < len(parts)-1 && n != float64(int64(n))) {
+			return 0, fmt.Errorf("expected seconds or [h:]mm:ss[.mmm]")
+		}
+		seconds = seconds*60 + n
+	}
+	return int64(seconds*1000 + 0.5), nil
+}
+
+// runSearch handles "search <query>" across stored videos
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	var videoRefs, tags stringList
+	var since, until dateFlag
+	fs.Var(&videoRefs, "video", "Only search this video (path, key, fingerprint prefix or name), may be repeated")
+	name := fs.String("name", "", "Only search videos whose name contains this text")
+	fs.Var(&tags, "tag", "Only return frames with this structured analysis tag, may be repeated")
+	fs.Var(&since, "since", "Only search videos stored on or after this date")
+	fs.Var(&until, "until", "Only search videos stored before this date")
+	var objects stringList
+	var from, to timestampFlag
+	fs.Var(&objects, "object", "Only return frames whose structured analysis lists this object, may be repeated")
+	fs.Var(&from, "from", "Only return frames at or after this position in their video (seconds or h:mm:ss)")
+	fs.Var(&to, "to", "Only return frames before this position in their video (seconds or h:mm:ss)")
+	minScore := fs.Float64("min-score", 0, "Drop results scoring lower (similarity, text score or fused score, depending on --mode)")
+	offset := fs.Int("offset", 0, "Skip this many results")
+	cursor := fs.String("cursor", "", "Continue from the cursor printed with the previous page")
+	mode := fs.String("mode", string(models.SearchSemantic), "How the query is matched: semantic, text or hybrid")
+	text := fs.Bool("text", false, "Shorthand for --mode text")
+	limit := fs.Int("limit", 5, "Maximum number of search results")
+	common := registerQueryFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: visionanalyzer search [flags] <query>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	query := strings.Join(fs.Args(), " ")
+	if query == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	cfg := common.load(fs)
+	ctx, stop := interruptContext()
+	defer stop()
+
+	req := models.SearchRequest{
+		Query:        query,
+		Mode:         models.SearchMode(*mode),
+		Limit:        *limit,
+		VideoName:    *name,
+		Tags:         tags,
+		Objects:      objects,
+		StoredAfter:  since.t,
+		StoredBefore: until.t,
+		FromMs:       from.ms,
+		ToMs:         to.ms,
+		MinScore:     *minScore,
+		Offset:       *offset,
+		Cursor:       *cursor,
+	}
+
+	if *text {
+		req.Mode = models.SearchText
+	}
+	switch req.Mode {
+	case models.SearchSemantic, models.SearchText, models.SearchHybrid:
+	default:
+		log.Fatalf("Unknown search mode '%s', use semantic, text or hybrid", req.Mode)
+	}
+
+	// Only text search works without the embedding model
+	var embedder embeddings.Embedder
+	if req.Mode != models.SearchText {
+		embedder = newEmbedder(ctx, cfg)
+	}
+	catalog, closeCatalog := openCatalog(ctx, cfg, embedder)
+	defer closeCatalog()
+
+	for _, ref := range videoRefs {
+		req.Videos = append(req.Videos, findVideo(ctx, catalog, ref).Fingerprint)
+	}
+
+	page, err := catalog.Search(ctx, req)
+	if err != nil {
+		log.Printf("Search error: %v", err)
+		os.Exit(1)
+	}
+
+	// Display results
+	first := *offset
+	switch {
+	case len(page.Results) == 0:
+		fmt.Printf("No frames on this page, %d matching frames for: %s\n", page.Total, query)
+	case req.Cursor != "":
+		// Positions are not known when continuing from a cursor
+		fmt.Printf("Showing %d more of %d matching frames for: %s\n", len(page.Results), page.Total, query)
+	default:
+		fmt.Printf("Showing %d-%d of %d matching frames for: %s\n", first+1, first+len(page.Results), page.Total, query)
+	}
+	for i, result := range page.Results {
+		var similarityText string
+		switch req.Mode {
+		case models.SearchText:
+			similarityText = fmt.Sprintf("(text score %.3f)", result.TextScore)
+		case models.SearchHybrid:
+			similarityText = fmt.Sprintf("(score %.4f: %s, %s)", result.Score,
+				rankText("semantic", result.SemanticRank, fmt.Sprintf("%.2f%% similarity", result.Similarity*100)),
+				rankText("text", result.TextRank, fmt.Sprintf("score %.3f", result.TextScore)))
+		default:
+			similarityText = fmt.Sprintf("(%.2f%% similarity)", result.Similarity*100)
+		}
+
+		position := formatTimestamp(result.TimestampMs)
+		if result.VideoDurationMs > 0 {
+			position += fmt.Sprintf(" of %s (%.0f%%)", formatTimestamp(result.VideoDurationMs),
+				float64(result.TimestampMs)/float64(result.VideoDurationMs)*100)
+		}
+
+		fmt.Printf("%d. %s frame %d at %s %s\n", first+i+1, result.VideoName, result.FrameNumber, position, similarityText)
+		fmt.Printf("   Description: %s\n\n", result.Description)
+	}
+	if page.NextCursor != "" {
+		fmt.Printf("More results: --cursor %s\n", page.NextCursor)
+	}
+}
+
+// rankText describes the position of a hybrid hit in one of the fused rankings
+func rankText(ranking string, rank int, score string) string {
+	if rank == 0 {
+		return "no " + ranking + " match"
+	}
+	return fmt.Sprintf("%s #%d, %s", ranking, rank, score)
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "0", want: 0},
+		{value: "90", want: 90000},
+		{value: "90.5", want: 90500},
+		{value: "0.0005", want: 1},
+		{value: "1:30", want: 90000},
+		{value: "01:30.25", want: 90250},
+		{value: "0:01:30.500", want: 90500},
+		{value: "2:00:00", want: 7200000},
+		// Only the last part may have a fraction or run past 59
+		{value: "90:00", want: 5400000},
+		{value: "1.5:00", wantErr: true},
+		{value: "1:2:3:4", wantErr: true},
+		{value: "-5", wantErr: true},
+		{value: "1:-5", wantErr: true},
+		{value: "", wantErr: true},
+		{value: "1:", wantErr: true},
+		{value: "ten", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTimestamp(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTimestamp(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("parseTimestamp(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestTimestampFlag(t *testing.T) {
+	var flag timestampFlag
+	if flag.String() != "" {
+		t.Errorf("unset flag prints %q", flag.String())
+	}
+	if err := flag.Set("1:02:03.004"); err != nil {
+		t.Fatal(err)
+	}
+	if flag.ms != 3723004 || flag.String() != "1:02:03.004" {
+		t.Errorf("flag holds %d and prints %q", flag.ms, flag.String())
+	}
+	if err := flag.Set("soon"); err == nil || flag.ms != 3723004 {
+		t.Errorf("invalid value accepted or changed the flag to %d", flag.ms)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/bdougie/vision/internal/analyzer"
+	"github.com/bdougie/vision/internal/config"
+	"github.com/bdougie/vision/internal/embeddings"
+	"github.com/bdougie/vision/internal/server"
+	"github.com/bdougie/vision/internal/storage"
+)
+
+// runServe handles "serve", the HTTP API
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", "", "Address to listen on (default from server.listen)")
+	jobs := fs.Int("jobs", 0, "Number of analysis jobs run at the same time (default from server.jobs)")
+	common := registerCommonFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: visionanalyzer serve [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	cfg := common.load(fs)
+	if *listen != "" {
+		cfg.Server.Listen = *listen
+	}
+	if *jobs > 0 {
+		cfg.Server.Jobs = *jobs
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	logger := newLogger()
+
+	visionProvider, err := analyzer.NewVisionProvider(ctx, cfg.VisionProvider(), &logger)
+	if err != nil {
+		log.Fatalf("Failed to initialize vision provider: %v", err)
+	}
+
+	opts := server.Options{
+		Config:   cfg,
+		Provider: visionProvider,
+		Logger:   logger,
+	}
+	var embedder embeddings.Embedder
+	switch cfg.Storage.Backend {
+	case config.BackendFile:
+		embedder = optionalEmbedder(ctx, cfg)
+	case config.BackendPostgres:
+		embedder = newEmbedder(ctx, cfg)
+		if err := storage.Migrate(ctx, cfg.Postgres(), embedder.Dimension()); err != nil {
+			log.Fatalf("Failed to initialize database schema: %v", err)
+		}
+	default:
+		embedder = newEmbedder(ctx, cfg)
+	}
+	catalog, closeCatalog := openCatalog(ctx, cfg, embedder)
+	defer closeCatalog()
+	opts.Embedder = embedder
+	opts.Catalog = catalog
+
+	if err := server.New(opts).ListenAndServe(ctx); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+}
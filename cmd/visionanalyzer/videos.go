@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bdougie/vision/internal/config"
+	"github.com/bdougie/vision/internal/models"
+	"github.com/bdougie/vision/internal/storage"
+)
+
+// runVideos handles "videos list"
+func runVideos(args []string) {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Println("Usage: visionanalyzer videos list [flags]")
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("videos list", flag.ExitOnError)
+	common := registerCommonFlags(fs)
+	fs.Parse(args[1:])
+
+	cfg := common.load(fs)
+	ctx, stop := interruptContext()
+	defer stop()
+	catalog, closeCatalog := openCatalog(ctx, cfg, nil)
+	defer closeCatalog()
+
+	videos, err := catalog.Videos(ctx)
+	if err != nil {
+		log.Fatalf("Failed to list videos: %v", err)
+	}
+	if len(videos) == 0 {
+		fmt.Println("No videos stored")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tNAME\tDURATION\tFRAMES\tANALYZED\tFAILED\tADDED")
+	for _, summary := range videos {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%s\n",
+			summary.Video.Key(), summary.Video.Name, formatTimestamp(summary.Video.DurationMs),
+			summary.Frames, summary.Analyzed, summary.Failed, summary.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	w.Flush()
+}
+
+// runFrames handles "frames show <video> [frame]"
+func runFrames(args []string) {
+	if len(args) == 0 || args[0] != "show" {
+		fmt.Println("Usage: visionanalyzer frames show [flags] <video> [frame number]")
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("frames show", flag.ExitOnError)
+	common := registerCommonFlags(fs)
+	fs.Parse(args[1:])
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fmt.Println("Usage: visionanalyzer frames show [flags] <video> [frame number]")
+		os.Exit(1)
+	}
+
+	cfg := common.load(fs)
+	ctx, stop := interruptContext()
+	defer stop()
+	catalog, closeCatalog := openCatalog(ctx, cfg, nil)
+	defer closeCatalog()
+
+	video := findVideo(ctx, catalog, fs.Arg(0))
+	frames, err := catalog.Frames(ctx, *video)
+	if err != nil {
+		log.Fatalf("Failed to read frames: %v", err)
+	}
+
+	// A single frame is shown in full
+	if fs.NArg() == 2 {
+		number, err := strconv.Atoi(fs.Arg(1))
+		if err != nil {
+			log.Fatalf("Invalid frame number '%s'", fs.Arg(1))
+		}
+		for _, frame := range frames {
+			if frame.FrameNumber != number {
+				continue
+			}
+			data, err := json.MarshalIndent(frame, "", "  ")
+			if err != nil {
+				log.Fatalf("Failed to render frame: %v", err)
+			}
+			fmt.Println(string(data))
+			return
+		}
+		log.Fatalf("Frame %d of %s is not stored", number, video.Name)
+	}
+
+	fmt.Printf("%s (%s), %d frames\n", video.Name, video.Key(), len(frames))
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FRAME\tTIME\tSTATUS\tDESCRIPTION")
+	for _, frame := range frames {
+		status := string(frame.Status)
+		if status == "" {
+			status = "-"
+		}
+		description := frame.Content
+		if frame.Structured != nil && frame.Structured.Summary != "" {
+			description = frame.Structured.Summary
+		}
+		if description == "" {
+			description = frame.Reason
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", frame.FrameNumber, formatTimestamp(frame.TimestampMs), status, truncate(description, 80))
+	}
+	w.Flush()
+}
+
+// truncate shortens text to one line of at most n runes
+func truncate(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+// exportDocument is the JSON written by "export"
+type exportDocument struct {
+	Video  models.VideoMetadata `json:"video"`
+	Frames []models.FrameRecord `json:"frames"`
+}
+
+// runExport handles "export <video>"
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "json", "Output format: json or csv")
+	outputPath := fs.String("o", "", "Write to this file instead of standard output")
+	common := registerCommonFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 || (*format != "json" && *format != "csv") {
+		fmt.Println("Usage: visionanalyzer export [--format json|csv] [-o file] <video>")
+		os.Exit(1)
+	}
+
+	cfg := common.load(fs)
+	ctx, stop := interruptContext()
+	defer stop()
+	catalog, closeCatalog := openCatalog(ctx, cfg, nil)
+	defer closeCatalog()
+
+	video := findVideo(ctx, catalog, fs.Arg(0))
+	frames, err := catalog.Frames(ctx, *video)
+	if err != nil {
+		log.Fatalf("Failed to read frames: %v", err)
+	}
+
+	var out io.Writer = os.Stdout
+	if *outputPath != "" {
+		file, err := os.Create(*outputPath)
+		if err != nil {
+			log.Fatalf("Failed to create '%s': %v", *outputPath, err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if *format == "csv" {
+		err = writeCSV(out, frames)
+	} else {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(exportDocument{Video: *video, Frames: frames})
+	}
+	if err != nil {
+		log.Fatalf("Failed to export: %v", err)
+	}
+	if *outputPath != "" {
+		fmt.Printf("Exported %d frames of %s to %s\n", len(frames), video.Name, *outputPath)
+	}
+}
+
+// writeCSV writes one row per frame, structured fields are flattened
+func writeCSV(out io.Writer, frames []models.FrameRecord) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"frame", "frame_number", "timestamp_ms", "timestamp", "status", "reason", "content", "tags", "duplicate_of"})
+	for _, frame := range frames {
+		var tags string
+		if frame.Structured != nil {
+			tags = strings.Join(frame.Structured.Tags, ";")
+		}
+		w.Write([]string{
+			frame.Frame,
+			strconv.Itoa(frame.FrameNumber),
+			strconv.FormatInt(frame.TimestampMs, 10),
+			formatTimestamp(frame.TimestampMs),
+			string(frame.Status),
+			frame.Reason,
+			frame.Content,
+			tags,
+			frame.DuplicateOf,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// runDelete handles "delete <video>"
+func runDelete(args []string) {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	yes := fs.Bool("yes", false, "Do not ask for confirmation")
+	common := registerCommonFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: visionanalyzer delete [--yes] <video>")
+		os.Exit(1)
+	}
+
+	cfg := common.load(fs)
+	ctx, stop := interruptContext()
+	defer stop()
+	catalog, closeCatalog := openCatalog(ctx, cfg, nil)
+	defer closeCatalog()
+
+	video := findVideo(ctx, catalog, fs.Arg(0))
+	if !*yes {
+		fmt.Printf("Delete %s (%s) and all of its frames and analyses? [y/N] ", video.Name, video.Key())
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+			fmt.Println("Nothing deleted")
+			return
+		}
+	}
+
+	if err := catalog.DeleteVideo(ctx, *video); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// Extracted frames live in the output directory for every backend
+	if video.Key() != "" {
+		if err := os.RemoveAll(filepath.Join(cfg.Storage.OutputDir, video.Key())); err != nil {
+			log.Printf("Warning: failed to remove extracted frames: %v", err)
+		}
+	}
+	fmt.Printf("Deleted %s\n", video.Name)
+}
+
+// runReindex handles "reindex [video]"
+func runReindex(args []string) {
+	fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+	common := registerCommonFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: visionanalyzer reindex [flags] [video]")
+		fmt.Fprintln(fs.Output(), "Without a video every analysis is embedded again, which also allows switching to a model of another dimension.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	cfg := common.load(fs)
+	ctx, stop := interruptContext()
+	defer stop()
+	embedder := newEmbedder(ctx, cfg)
+
+	// Rebuilding everything may change the vector size, a single video must fit the existing column
+	if cfg.Storage.Backend == config.BackendPostgres {
+		var err error
+		if fs.NArg() == 0 {
+			err = storage.MigrateDiscardingEmbeddings(ctx, cfg.Postgres(), embedder.Dimension())
+		} else {
+			err = storage.Migrate(ctx, cfg.Postgres(), embedder.Dimension())
+		}
+		if err != nil {
+			log.Fatalf("Failed to prepare database schema: %v", err)
+		}
+	}
+
+	catalog, closeCatalog := openCatalog(ctx, cfg, embedder)
+	defer closeCatalog()
+
+	var video *models.VideoMetadata
+	if fs.NArg() == 1 {
+		video = findVideo(ctx, catalog, fs.Arg(0))
+	}
+
+	count, err := catalog.(storage.Reindexer).Reindex(ctx, video)
+	if err != nil {
+		log.Fatalf("Reindexing failed after %d analyses: %v", count, err)
+	}
+	fmt.Printf("Reindexed %d analyses with %s\n", count, embedder.Model())
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/bdougie/vision/internal/analyzer"
+	"github.com/bdougie/vision/internal/config"
+	"github.com/bdougie/vision/internal/storage"
+)
+
+// runWorker handles "worker", analyzing frames from the shared queue
+func runWorker(args []string) {
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	name := fs.String("name", "", "Name of this worker in the queue (default: hostname-pid)")
+	exitWhenIdle := fs.Bool("exit-when-idle", false, "Stop once no frames are waiting")
+	common := registerCommonFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: visionanalyzer worker [flags]")
+		fmt.Fprintln(fs.Output(), "Analyzes frames queued with \"analyze --queue\", --workers sets how many at a time.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	cfg := common.load(fs)
+	if cfg.Storage.Backend != config.BackendPostgres {
+		log.Fatalf("Workers require the PostgreSQL backend (storage.backend: postgres or DB_ENABLED=true)")
+	}
+
+	// Frames being analyzed when the worker stops go back to the queue
+	ctx, stop := interruptContext()
+	defer stop()
+	logger := newLogger()
+
+	embedder := newEmbedder(ctx, cfg)
+	if err := storage.Migrate(ctx, cfg.Postgres(), embedder.Dimension()); err != nil {
+		log.Fatalf("Failed to initialize database schema: %v", err)
+	}
+	pgStorage, err := storage.OpenPostgresStorage(ctx, cfg.Postgres(), embedder)
+	if err != nil {
+		log.Fatalf("Failed to open PostgreSQL storage: %v", err)
+	}
+	defer pgStorage.Close()
+
+	visionProvider, err := analyzer.NewVisionProvider(ctx, cfg.VisionProvider(), &logger)
+	if err != nil {
+		log.Fatalf("Failed to initialize vision provider: %v", err)
+	}
+	processorOpts := cfg.ProcessorOptions()
+	processorOpts.Logger = logger
+	processor, err := analyzer.NewProcessor(visionProvider, pgStorage, processorOpts)
+	if err != nil {
+		log.Fatalf("Failed to create processor: %v", err)
+	}
+
+	workerOpts := cfg.WorkerOptions()
+	workerOpts.Name = *name
+	if workerOpts.Name == "" {
+		hostname, _ := os.Hostname()
+		workerOpts.Name = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	workerOpts.ExitWhenIdle = *exitWhenIdle
+
+	fmt.Printf("Worker %s analyzing %d frames at a time\n", workerOpts.Name, workerOpts.Concurrency)
+	if err := processor.RunWorker(ctx, pgStorage, workerOpts); err != nil {
+		log.Fatalf("Worker error: %v", err)
+	}
+	fmt.Println("Worker stopped")
+}
+
+// runQueue handles "queue status"
+func runQueue(args []string) {
+	if len(args) == 0 || args[0] != "status" {
+		fmt.Println("Usage: visionanalyzer queue status [flags]")
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("queue status", flag.ExitOnError)
+	common := registerCommonFlags(fs)
+	fs.Parse(args[1:])
+
+	cfg := common.load(fs)
+	if cfg.Storage.Backend != config.BackendPostgres {
+		log.Fatalf("The frame queue requires the PostgreSQL backend (storage.backend: postgres or DB_ENABLED=true)")
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	pgStorage, err := storage.OpenPostgresStorage(ctx, cfg.Postgres(), nil)
+	if err != nil {
+		log.Fatalf("Failed to open PostgreSQL storage: %v", err)
+	}
+	defer pgStorage.Close()
+
+	summaries, err := pgStorage.QueueSummary(ctx)
+	if err != nil {
+		log.Fatalf("Failed to read the frame queue: %v", err)
+	}
+	if len(summaries) == 0 {
+		fmt.Println("No frames queued")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tNAME\tPENDING\tRUNNING\tDONE\tFAILED")
+	for _, summary := range summaries {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\n", summary.Video.Key(), summary.Video.Name,
+			summary.Pending, summary.Running, summary.Done, summary.Failed)
+	}
+	w.Flush()
+}
@@ -2,214 +2,610 @@ package analyzer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"text/template"
+	"time"
 
-	"github.com/agent-api/core/agent"
+	"github.com/go-logr/logr"
+
+	"github.com/bdougie/vision/internal/events"
 	"github.com/bdougie/vision/internal/extractor"
+	"github.com/bdougie/vision/internal/imagehash"
 	"github.com/bdougie/vision/internal/models"
 	"github.com/bdougie/vision/internal/storage"
 )
 
-const maxWorkers = 4 // Adjust based on your CPU cores
+const defaultWorkers = 4 // Adjust based on your CPU cores
+
+// DefaultFramePrompt is the per-frame prompt used when none is configured
+const DefaultFramePrompt = "What is happening in this image? Be specific and detailed. List item and describe items shown in the video."
+
+// Options controls how a Processor extracts and analyzes frames
+type Options struct {
+	Extract extractor.Options
+
+	// Timestamps grabs the frames at these positions in milliseconds instead
+	// of sampling the video with Extract
+	Timestamps []int64
+
+	// Quality leaves black, blurred and blank frames out of the analysis or
+	// replaces them with a better frame nearby
+	Quality QualityOptions
+
+	// Dedup leaves frames that look like an earlier one out of the analysis
+	Dedup DedupOptions
+
+	// Workers is the number of frames analyzed concurrently
+	Workers int
+
+	// FramePrompt is a text/template rendered with PromptData for every frame
+	FramePrompt string
+
+	// Structured asks the model for JSON matching models.FrameAnalysis
+	Structured bool
+
+	// StructuredRetries is how many times an unparseable structured answer
+	// is sent back to the model for repair
+	StructuredRetries int
+
+	// Retry controls timeouts, backoff and the circuit breaker for model calls
+	Retry RetryPolicy
+
+	// RetryFailed only analyzes frames recorded as failed or skipped by an earlier run
+	RetryFailed bool
+
+	// Resume skips frames that already have a stored analysis
+	Resume bool
+
+	// Force extracts frames again and analyzes all of them, ignoring stored results
+	Force bool
+
+	// Enqueue puts the frames on the shared work queue of the storage instead
+	// of analyzing them, for worker processes to pick up
+	Enqueue bool
+
+	// QueueAttempts is how often a queued frame is claimed before it fails
+	QueueAttempts int
+
+	// Events receives progress events of every run, nil disables them. Storage
+	// implementing storage.EventSource publishes to it as well.
+	Events events.Publisher
+
+	// Logger receives debug output such as the raw model responses at V(1),
+	// the zero Logger discards it
+	Logger logr.Logger
+}
+
+// PromptData is available to the per-frame prompt template
+type PromptData struct {
+	Video       string
+	Frame       string
+	FrameNum    int
+	Total       int
+	TimestampMs int64
+	Timestamp   time.Duration
+}
 
 type Processor struct {
-	agent   *agent.Agent
-	storage storage.Storage
-}
-
-func NewProcessor(agent *agent.Agent, storage storage.Storage) *Processor {
-	return &Processor{
-		agent:   agent,
-		storage: storage,
-	}
-}
-
-// ProcessVideo processes a video by extracting frames and analyzing them
-func (p *Processor) ProcessVideo(ctx context.Context, videoPath, outputDir string) error {
-	fmt.Printf("Processing video: '%s'\n", videoPath)
-
-	// Extract video name
-	videoName := strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath))
-	
-	// Initialize storage based on configuration
-	var store storage.Storage
-	
-	// Check if PostgreSQL is enabled
-	dbEnabled := os.Getenv("DB_ENABLED") == "true"
-	if dbEnabled {
-		// Get PostgreSQL configuration from environment
-		pgConfig := storage.PostgresConfig{
-			Host:     getEnvOrDefault("DB_HOST", "localhost"),
-			Port:     getEnvOrDefault("DB_PORT", "5432"),
-			User:     getEnvOrDefault("DB_USER", "postgres"),
-			Password: getEnvOrDefault("DB_PASSWORD", "postgres"),
-			DBName:   getEnvOrDefault("DB_NAME", "vision_analysis"),
-		}
-		
-		// Initialize database schema if needed
-		if err := storage.InitSchema(ctx, pgConfig); err != nil {
-			return fmt.Errorf("failed to initialize database schema: %v", err)
-		}
-		
-		// Create PostgreSQL storage
-		pgStorage, err := storage.NewPostgresStorage(ctx, pgConfig, videoName)
-		if err != nil {
-			return fmt.Errorf("failed to create PostgreSQL storage: %v", err)
-		}
-		defer pgStorage.Close()
-		
-		store = pgStorage
-	} else {
-		// Use file-based storage if PostgreSQL is not enabled
-		store = storage.NewFileStorage(outputDir, videoName)
+	provider    VisionProvider
+	storage     storage.Storage
+	opts        Options
+	framePrompt *template.Template
+	breaker     *circuitBreaker
+	videoName   string
+
+	// progress counts the frames of the current run
+	progress models.Progress
+
+	// replaced holds the frames swapped for a better one in the current run,
+	// they are analyzed again even when resuming
+	replaced map[string]bool
+}
+
+// frameOutcome is what a worker reports back for a single frame
+type frameOutcome struct {
+	work     models.WorkItem
+	result   *models.AnalysisResult
+	attempts int
+	err      error
+}
+
+func NewProcessor(provider VisionProvider, store storage.Storage, opts Options) (*Processor, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = defaultWorkers
+	}
+	if opts.FramePrompt == "" {
+		opts.FramePrompt = DefaultFramePrompt
+	}
+	if opts.Retry == (RetryPolicy{}) {
+		opts.Retry = DefaultRetryPolicy()
+	}
+	if opts.Dedup.Algorithm == "" {
+		opts.Dedup.Algorithm = imagehash.DHash
+	}
+	if opts.Quality.ReplaceWindow == 0 {
+		opts.Quality.ReplaceWindow = DefaultReplaceWindow
 	}
 
-	// Extract frames
-	frameDirPath := filepath.Join(outputDir, videoName)
+	framePrompt, err := template.New("frame_prompt").Parse(opts.FramePrompt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid frame prompt template: %w", err)
+	}
+
+	p := &Processor{
+		provider:    provider,
+		storage:     store,
+		opts:        opts,
+		framePrompt: framePrompt,
+		breaker:     newCircuitBreaker(opts.Retry.BreakerThreshold, opts.Retry.BreakerCooldown),
+	}
 
-	err := extractor.ExtractFrames(videoPath, outputDir, 15)
+	// Storage events go through publish so they name the video too
+	if source, ok := store.(storage.EventSource); ok && opts.Events != nil {
+		source.SetPublisher(events.PublisherFunc(p.publish))
+	}
+	return p, nil
+}
+
+// ProcessVideo processes a probed video by extracting frames and analyzing them.
+// Frames are written to a directory under outputDir keyed by the video's
+// content fingerprint. Cancelling ctx stops ffmpeg and model calls, frames
+// analyzed by then are stored and the returned error wraps ctx.Err().
+func (p *Processor) ProcessVideo(ctx context.Context, video *models.VideoMetadata, outputDir string) error {
+	started := time.Now()
+	p.progress = models.Progress{}
+	p.replaced = make(map[string]bool)
+	err := p.processVideo(ctx, video, outputDir)
+
+	progress := p.progress
+	finished := events.Event{
+		Type:       events.JobFinished,
+		Progress:   &progress,
+		DurationMs: time.Since(started).Milliseconds(),
+	}
 	if err != nil {
+		finished.Error = err.Error()
+		finished.Cancelled = ctx.Err() != nil
+	}
+	p.publish(finished)
+	return err
+}
+
+func (p *Processor) processVideo(ctx context.Context, video *models.VideoMetadata, outputDir string) error {
+	fmt.Printf("Processing video: '%s'\n", video.Path)
+	p.videoName = video.Name
+
+	// Reject inputs we cannot extract frames from
+	if err := extractor.ValidateVideo(video); err != nil {
+		return err
+	}
+	fmt.Printf("Video: %s, %dx%d, %.2f fps, %s\n", video.VideoCodec, video.Width, video.Height, video.FrameRate, time.Duration(video.DurationMs)*time.Millisecond)
+
+	if err := p.storage.SaveVideoMetadata(ctx, *video); err != nil {
 		return err
 	}
 
-	// Get frames
-	files, err := os.ReadDir(frameDirPath)
+	// Extract frames
+	frameDirPath := filepath.Join(outputDir, video.Key())
+
+	// Parts of a video must lie within it
+	for _, ms := range append([]int64{p.opts.Extract.StartMs}, p.opts.Timestamps...) {
+		if ms >= video.DurationMs {
+			return fmt.Errorf("%s is past the end of the video (%s)", time.Duration(ms)*time.Millisecond, time.Duration(video.DurationMs)*time.Millisecond)
+		}
+	}
+	partial := p.opts.Extract.Partial() || len(p.opts.Timestamps) > 0
+
+	// A new manifest means the frames were extracted again and numbered
+	// from the start, the stored analyses and statuses describe other frames
+	previous, _ := extractor.LoadManifest(frameDirPath)
+
+	// Without a manifest the extractor discards old frames and extracts
+	// again, parts of a video are added to the frames there are
+	if p.opts.Force && !partial {
+		err := os.Remove(filepath.Join(frameDirPath, extractor.ManifestFileName))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to reset frames in '%s': %v", frameDirPath, err)
+		}
+	}
+
+	p.publish(events.Event{Type: events.ExtractionStarted})
+	reported := false
+	onProgress := func(progress models.ExtractionProgress) {
+		reported = true
+		p.extractionProgress(progress)
+	}
+	var manifest *extractor.Manifest
+	var err error
+	if len(p.opts.Timestamps) > 0 {
+		manifest, err = extractor.ExtractFramesAt(ctx, video.Path, frameDirPath, p.opts.Timestamps, onProgress)
+	} else {
+		manifest, err = extractor.ExtractFrames(ctx, video.Path, frameDirPath, p.opts.Extract, onProgress)
+	}
+	if reported {
+		fmt.Println()
+	}
 	if err != nil {
-		return fmt.Errorf("failed to read frames directory '%s': %v", frameDirPath, err)
+		return err
 	}
+	p.publish(events.Event{Type: events.ExtractionFinished, Frames: len(manifest.Frames)})
 
-	if len(files) == 0 {
+	if previous == nil || !manifest.CreatedAt.Equal(previous.CreatedAt) {
+		if err := p.storage.ResetFrames(ctx); err != nil {
+			return fmt.Errorf("failed to reset stored frames: %w", err)
+		}
+	}
+
+	frames := manifest.Frames
+	if len(frames) == 0 {
+		if partial {
+			return fmt.Errorf("no frames found in the requested part of the video")
+		}
 		return fmt.Errorf("no frames found in directory '%s'", frameDirPath)
 	}
 
-	var frames []string
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(strings.ToLower(file.Name()), ".jpg") {
-			frames = append(frames, file.Name())
+	work := make([]models.WorkItem, len(frames))
+	for i, frame := range frames {
+		work[i] = models.WorkItem{
+			FramePath:   frame.File,
+			FrameNum:    i + 1,
+			Total:       len(frames),
+			TimestampMs: frame.TimestampMs,
+			Replaced:    frame.Replaced,
 		}
 	}
 
-	if len(frames) == 0 {
-		return fmt.Errorf("no JPEG frames found in directory '%s'", frameDirPath)
+	// Rejected frames and duplicates are recorded again on every run,
+	// whatever is analyzed. Bad frames go first so they are not kept as the
+	// frame later duplicates are compared with.
+	if p.opts.Quality.Enabled {
+		work, err = p.filterQuality(ctx, video, work, frameDirPath)
+		if err != nil {
+			return err
+		}
+	}
+	if p.opts.Dedup.Enabled {
+		work, err = p.skipDuplicates(ctx, work, frameDirPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case p.opts.RetryFailed:
+		work, err = p.failedWork(ctx, work)
+		if err != nil {
+			return err
+		}
+		if len(work) == 0 {
+			fmt.Println("No failed or skipped frames to retry")
+			return nil
+		}
+		fmt.Printf("Retrying %d failed or skipped frames\n", len(work))
+	case p.opts.Resume && !p.opts.Force:
+		total := len(work)
+		work, err = p.pendingWork(ctx, work)
+		if err != nil {
+			return err
+		}
+		if len(work) == 0 {
+			fmt.Printf("All %d frames already analyzed, use --force to analyze them again\n", total)
+			return nil
+		}
+		fmt.Printf("Found %d frames, %d already analyzed, %d to analyze\n", total, total-len(work), len(work))
+	default:
+		fmt.Printf("Found %d frames to analyze\n", len(work))
 	}
 
-	fmt.Printf("Found %d frames to analyze\n", len(frames))
-	sort.Strings(frames)
+	if p.opts.Enqueue {
+		return p.enqueue(ctx, work, frameDirPath)
+	}
 
 	// Process frames
-	return p.processFrames(ctx, frames, frameDirPath, store)
+	return p.processFrames(ctx, work, frameDirPath, p.storage)
+}
+
+// enqueue hands the frames to worker processes through the storage's work queue
+func (p *Processor) enqueue(ctx context.Context, work []models.WorkItem, frameDirPath string) error {
+	queue, ok := p.storage.(storage.FrameQueue)
+	if !ok {
+		return fmt.Errorf("the storage backend has no work queue")
+	}
+	queued, err := queue.Enqueue(ctx, frameDirPath, work, p.opts.QueueAttempts)
+	if err != nil {
+		return err
+	}
+	if queued < len(work) {
+		fmt.Printf("Queued %d frames, %d are already being analyzed by a worker\n", queued, len(work)-queued)
+	} else {
+		fmt.Printf("Queued %d frames, run \"visionanalyzer worker\" to analyze them\n", queued)
+	}
+	return nil
+}
+
+// pendingWork drops frames that already have a stored analysis, unless the
+// frame was replaced in this run
+func (p *Processor) pendingWork(ctx context.Context, work []models.WorkItem) ([]models.WorkItem, error) {
+	analyzed, err := p.storage.AnalyzedFrames(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []models.WorkItem
+	for _, item := range work {
+		if !analyzed[item.FramePath] || p.replaced[item.FramePath] {
+			pending = append(pending, item)
+		}
+	}
+	return pending, nil
+}
+
+// failedWork narrows work down to frames an earlier run did not analyze successfully
+func (p *Processor) failedWork(ctx context.Context, work []models.WorkItem) ([]models.WorkItem, error) {
+	statuses, err := p.storage.FrameStatuses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var failed []models.WorkItem
+	for _, item := range work {
+		status, ok := statuses[item.FramePath]
+		if ok && (status.Status == models.FrameFailed || status.Status == models.FrameSkipped) {
+			failed = append(failed, item)
+		}
+	}
+	return failed, nil
 }
 
-func (p *Processor) processFrames(ctx context.Context, frames []string, frameDirPath string, store storage.Storage) error {
-	workChan := make(chan models.WorkItem, len(frames))
-	resultsChan := make(chan models.AnalysisResult, len(frames))
-	errorsChan := make(chan error, len(frames))
+func (p *Processor) processFrames(ctx context.Context, work []models.WorkItem, frameDirPath string, store storage.Storage) error {
+	workChan := make(chan models.WorkItem, len(work))
+	outcomes := make(chan frameOutcome, len(work))
 
 	var wg sync.WaitGroup
 
 	remainingFrames := atomic.Int64{}
-	remainingFrames.Store(int64(len(frames)))
+	remainingFrames.Store(int64(len(work)))
 
 	// Start worker pool
-	for i := 0; i < maxWorkers; i++ {
+	for i := 0; i < p.opts.Workers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for work := range workChan {
-				framePath := filepath.Join(frameDirPath, work.FramePath)
-				analysis, err := p.analyzeImage(ctx, framePath)
-				if err != nil {
-					errorsChan <- fmt.Errorf("frame %d/%d failed: %v", work.FrameNum, work.Total, err)
+			for item := range workChan {
+				// Frames still queued when the run is cancelled are left for the next one
+				if ctx.Err() != nil {
 					continue
 				}
-
-				resultsChan <- models.AnalysisResult{
-					Frame:   work.FramePath,
-					Content: analysis,
-				}
+				framePath := filepath.Join(frameDirPath, item.FramePath)
+				result, attempts, err := p.analyzeImage(ctx, framePath, p.videoName, item)
+				outcomes <- frameOutcome{work: item, result: result, attempts: attempts, err: err}
 
 				remaining := remainingFrames.Add(-1)
-				fmt.Printf("\rRemaining frames to analyze: %d/%d", remaining, len(frames))
+				fmt.Printf("\rRemaining frames to analyze: %d/%d", remaining, len(work))
 			}
 		}()
 	}
 
 	// Send work to workers
+	p.progress.Total = len(work)
+	queued := p.progress
 	go func() {
-		for i, frame := range frames {
-			workChan <- models.WorkItem{
-				FramePath: frame,
-				FrameNum:  i + 1,
-				Total:     len(frames),
-			}
+		for _, item := range work {
+			// Published before the send, so it always precedes the events
+			// of the worker picking the frame up
+			p.publish(events.Event{
+				Type:        events.FrameQueued,
+				Frame:       item.FramePath,
+				FrameNumber: item.FrameNum,
+				TimestampMs: item.TimestampMs,
+				Progress:    &queued,
+			})
+			workChan <- item
 		}
 		close(workChan)
 	}()
 
-	// Collect results
+	// Close outcomes once all workers are done
 	go func() {
-		for result := range resultsChan {
-			store.AddResult(ctx, result)
-		}
+		wg.Wait()
+		close(outcomes)
 	}()
 
-	// Wait for all workers to finish
-	wg.Wait()
-	close(resultsChan)
-	close(errorsChan)
+	// Store results and record the final state of every frame. Frames
+	// finished before a cancellation are still stored, frames interrupted by
+	// it are not recorded so a resumed run analyzes them.
+	storeCtx := context.WithoutCancel(ctx)
+	counts := make(map[models.FrameState]int)
+	var problems []string
+	for outcome := range outcomes {
+		if outcome.err != nil && ctx.Err() != nil {
+			continue
+		}
+
+		status := models.FrameStatus{
+			Frame:       outcome.work.FramePath,
+			TimestampMs: outcome.work.TimestampMs,
+			Status:      models.FrameOK,
+			Attempts:    outcome.attempts,
+			Hash:        outcome.work.Hash,
+			Reason:      outcome.work.Replaced,
+		}
+
+		err := outcome.err
+		if err == nil {
+			if storeErr := store.AddResult(storeCtx, *outcome.result); storeErr != nil {
+				err = fmt.Errorf("failed to store result: %w", storeErr)
+			}
+		}
+		if err != nil {
+			status.Status = models.FrameFailed
+			if errors.Is(err, ErrCircuitOpen) {
+				status.Status = models.FrameSkipped
+			}
+			status.Reason = err.Error()
+			problems = append(problems, fmt.Sprintf("frame %d/%d %s: %v", outcome.work.FrameNum, outcome.work.Total, status.Status, err))
+		}
+		counts[status.Status]++
+
+		if err := store.SetFrameStatus(storeCtx, status); err != nil {
+			fmt.Printf("Warning: failed to record status of %s: %v\n", status.Frame, err)
+		}
+		p.frameDone(outcome.work, status)
+	}
+	fmt.Println()
 
 	// Flush any remaining results
 	if err := store.Flush(); err != nil {
 		return fmt.Errorf("failed to flush final results: %v", err)
 	}
 
-	// Check for any errors
-	var errorMessages []string
-	for err := range errorsChan {
-		errorMessages = append(errorMessages, err.Error())
+	fmt.Printf("Frames ok: %d, failed: %d, skipped: %d\n",
+		counts[models.FrameOK], counts[models.FrameFailed], counts[models.FrameSkipped])
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("analysis cancelled with %d of %d frames done: %w", p.progress.Done, len(work), ctx.Err())
 	}
-	if len(errorMessages) > 0 {
-		return fmt.Errorf("encountered errors during processing: %v", strings.Join(errorMessages, "; "))
+
+	// Check for any errors
+	if len(problems) > 0 {
+		return fmt.Errorf("encountered errors during processing: %v", strings.Join(problems, "; "))
 	}
 
 	return nil
 }
 
-func (p *Processor) analyzeImage(ctx context.Context, imagePath string) (string, error) {
-	// Create vision prompt with image data
-	response, err := p.agent.Run(
-		ctx,
-		agent.WithInput("What is happening in this image? Be specific and detailed. List item and describe items shown in the video."),
-		agent.WithImagePath(imagePath),
-	)
+// publish sends an event about the current video, if anyone listens
+func (p *Processor) publish(event events.Event) {
+	if p.opts.Events == nil {
+		return
+	}
+	if event.Video == "" {
+		event.Video = p.videoName
+	}
+	p.opts.Events.Publish(event)
+}
+
+// extractionProgress reports how far ffmpeg has read into the video
+func (p *Processor) extractionProgress(progress models.ExtractionProgress) {
+	position := time.Duration(progress.OutTimeMs) * time.Millisecond
+	if progress.DurationMs > 0 {
+		fmt.Printf("\rExtracting frames: %5.1f%% (%s of %s, %.1fx, %d frames)", progress.Percent,
+			position.Round(time.Second), (time.Duration(progress.DurationMs) * time.Millisecond).Round(time.Second),
+			progress.Speed, progress.Frames)
+	} else {
+		fmt.Printf("\rExtracting frames: %s read (%.1fx, %d frames)", position.Round(time.Second), progress.Speed, progress.Frames)
+	}
+	p.publish(events.Event{Type: events.ExtractionProgress, Extraction: &progress})
+}
+
+// frameDone counts the final state of a frame and reports it
+func (p *Processor) frameDone(work models.WorkItem, status models.FrameStatus) {
+	p.progress.Done++
+	event := events.Event{
+		Type:        events.FrameAnalyzed,
+		Frame:       work.FramePath,
+		FrameNumber: work.FrameNum,
+		TimestampMs: work.TimestampMs,
+		Status:      status.Status,
+		Attempts:    status.Attempts,
+		Error:       status.Reason,
+	}
+	switch status.Status {
+	case models.FrameOK:
+		p.progress.OK++
+	case models.FrameFailed:
+		p.progress.Failed++
+		event.Type = events.FrameFailed
+	case models.FrameSkipped:
+		p.progress.Skipped++
+		event.Type = events.FrameFailed
+	}
+	progress := p.progress
+	event.Progress = &progress
+	p.publish(event)
+}
+
+func (p *Processor) analyzeImage(ctx context.Context, imagePath, videoName string, work models.WorkItem) (*models.AnalysisResult, int, error) {
+	var prompt strings.Builder
+	err := p.framePrompt.Execute(&prompt, PromptData{
+		Video:       videoName,
+		Frame:       work.FramePath,
+		FrameNum:    work.FrameNum,
+		Total:       work.Total,
+		TimestampMs: work.TimestampMs,
+		Timestamp:   time.Duration(work.TimestampMs) * time.Millisecond,
+	})
 	if err != nil {
-		return "", err
+		return nil, 0, fmt.Errorf("failed to render frame prompt: %w", err)
 	}
 
-	// Extract the actual response content
-	if len(response.Messages) == 0 {
-		return "", fmt.Errorf("no response messages received from model")
+	result := &models.AnalysisResult{
+		Frame:       work.FramePath,
+		TimestampMs: work.TimestampMs,
 	}
 
-	// Get the model's response (not the prompt)
-	content := response.Messages[len(response.Messages)-1].Content
+	if !p.opts.Structured {
+		text, attempts, err := p.describe(ctx, DescribeRequest{ImagePath: imagePath, Prompt: prompt.String()})
+		if err != nil {
+			return nil, attempts, err
+		}
+		result.Content = text
+		return result, attempts, nil
+	}
+
+	// Structured mode: parse the JSON answer, sending it back for repair on failure
+	req := DescribeRequest{
+		ImagePath: imagePath,
+		Prompt:    prompt.String() + "\n" + structuredInstructions,
+		JSON:      true,
+	}
+	totalAttempts := 0
+	for attempt := 0; ; attempt++ {
+		text, attempts, err := p.describe(ctx, req)
+		totalAttempts += attempts
+		if err != nil {
+			return nil, totalAttempts, err
+		}
 
-	// Debug log to see what we're getting
-	fmt.Printf("Raw response content: %s\n", content)
+		analysis, parseErr := parseFrameAnalysis(text)
+		if parseErr == nil {
+			result.Structured = analysis
+			result.Content = frameAnalysisText(analysis)
+			return result, totalAttempts, nil
+		}
+		if attempt >= p.opts.StructuredRetries {
+			return nil, totalAttempts, fmt.Errorf("structured response still invalid after %d attempts: %v", attempt+1, parseErr)
+		}
 
-	return content, nil
+		fmt.Printf("Structured response for %s invalid (%v), asking model to repair it\n", work.FramePath, parseErr)
+		req.Prompt = prompt.String() + "\n" + structuredInstructions + "\n\n" + fmt.Sprintf(repairInstructions, parseErr, text)
+	}
 }
 
-// Helper function to get environment variables with defaults
-func getEnvOrDefault(key, defaultValue string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+// describe sends a request to the provider, retrying transient failures, and
+// returns the answer text and the number of calls made
+func (p *Processor) describe(ctx context.Context, req DescribeRequest) (string, int, error) {
+	description, attempts, err := callWithRetry(ctx, p.opts.Retry, p.breaker, func(ctx context.Context) (*Description, error) {
+		return p.provider.DescribeImage(ctx, req)
+	})
+	if err != nil {
+		return "", attempts, err
 	}
-	return defaultValue
+
+	if description.Text == "" {
+		return "", attempts, fmt.Errorf("empty response received from model")
+	}
+
+	usage := description.Usage
+	p.opts.Logger.V(1).Info("Raw response content", "image", filepath.Base(req.ImagePath), "model", usage.Model,
+		"prompt_tokens", usage.PromptTokens, "completion_tokens", usage.CompletionTokens,
+		"duration", usage.Duration.Round(time.Millisecond), "content", description.Text)
+
+	return description.Text, attempts, nil
 }
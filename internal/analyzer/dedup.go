@@ -0,0 +1,88 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/bdougie/vision/internal/events"
+	"github.com/bdougie/vision/internal/imagehash"
+	"github.com/bdougie/vision/internal/models"
+)
+
+// DefaultDedupDistance is the Hamming distance up to which frame hashes count
+// as near-duplicates when none is configured
+const DefaultDedupDistance = 4
+
+// DedupOptions controls the skipping of near-duplicate frames
+type DedupOptions struct {
+	Enabled bool
+
+	// Algorithm is the perceptual hash compared between frames
+	Algorithm imagehash.Algorithm
+
+	// MaxDistance is the largest Hamming distance between the hashes of two
+	// frames for the later one to count as a duplicate, 0 only skips frames
+	// with identical hashes
+	MaxDistance int
+}
+
+// skipDuplicates hashes the frames in time order and leaves out every frame
+// whose hash is within MaxDistance of the last frame kept, recording it as a
+// duplicate of that frame. Comparing with the kept frame rather than the
+// previous one stops a slow pan from being skipped a little at a time.
+// Frames that cannot be hashed are kept.
+func (p *Processor) skipDuplicates(ctx context.Context, work []models.WorkItem, frameDirPath string) ([]models.WorkItem, error) {
+	var kept []models.WorkItem
+	var keptHash imagehash.Hash
+	keptFrame := ""
+	for _, item := range work {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("frame deduplication cancelled: %w", err)
+		}
+
+		hash, err := imagehash.File(filepath.Join(frameDirPath, item.FramePath), p.opts.Dedup.Algorithm)
+		if err != nil {
+			fmt.Printf("Warning: failed to hash %s, analyzing it anyway: %v\n", item.FramePath, err)
+			kept = append(kept, item)
+			continue
+		}
+		item.Hash = hash.String()
+
+		distance := hash.Distance(keptHash)
+		if keptFrame == "" || distance > p.opts.Dedup.MaxDistance {
+			kept = append(kept, item)
+			keptHash, keptFrame = hash, item.FramePath
+			continue
+		}
+
+		status := models.FrameStatus{
+			Frame:       item.FramePath,
+			TimestampMs: item.TimestampMs,
+			Status:      models.FrameDuplicate,
+			Reason:      fmt.Sprintf("%s distance %d to %s", p.opts.Dedup.Algorithm, distance, keptFrame),
+			Hash:        item.Hash,
+			DuplicateOf: keptFrame,
+		}
+		if err := p.storage.SetFrameStatus(ctx, status); err != nil {
+			return nil, err
+		}
+		p.progress.Duplicates++
+		progress := p.progress
+		p.publish(events.Event{
+			Type:        events.FrameDuplicate,
+			Frame:       item.FramePath,
+			FrameNumber: item.FrameNum,
+			TimestampMs: item.TimestampMs,
+			Status:      models.FrameDuplicate,
+			DuplicateOf: keptFrame,
+			Progress:    &progress,
+		})
+	}
+
+	if skipped := len(work) - len(kept); skipped > 0 {
+		fmt.Printf("Skipping %d of %d frames as near-duplicates (%s distance <= %d)\n",
+			skipped, len(work), p.opts.Dedup.Algorithm, p.opts.Dedup.MaxDistance)
+	}
+	return kept, nil
+}
@@ -0,0 +1,196 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/bdougie/vision/internal/events"
+	"github.com/bdougie/vision/internal/models"
+	"github.com/bdougie/vision/internal/storage"
+)
+
+// pattern draws a synthetic 160x90 frame, frames of different variants look
+// nothing alike and brightness is added to every pixel
+func pattern(variant int, brightness float64) *image.Gray {
+	const width, height = 160, 90
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/width, float64(y)/height
+			if variant%2 == 1 {
+				fx, fy = fy, fx
+			}
+			phase := float64(variant) * 0.37
+			value := 128 + brightness + 50*math.Sin(2*math.Pi*(fx*float64(1+variant)+phase)) +
+				40*math.Cos(2*math.Pi*(fy*2+fx*0.5-phase)) + 20*math.Sin(2*math.Pi*(fx*3.3-fy*1.7))
+			img.SetGray(x, y, color.Gray{Y: uint8(max(0, min(255, value)))})
+		}
+	}
+	return img
+}
+
+// writeFrame stores img as a JPEG under dir
+func writeFrame(t *testing.T, dir, name string, img image.Image) {
+	t.Helper()
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testFrame is a frame of a test video, a nil image is never written
+type testFrame struct {
+	img image.Image
+}
+
+// newTestProcessor returns a processor storing into a directory under t's
+// temporary directory and the directory frames are read from
+func newTestProcessor(t *testing.T, opts Options, publisher events.Publisher) (*Processor, storage.Storage, string) {
+	t.Helper()
+	dir := t.TempDir()
+	store := storage.NewFileStorage(filepath.Join(dir, "output"), "video", nil)
+	opts.Events = publisher
+	p, err := NewProcessor(nil, store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frameDir := filepath.Join(dir, "frames")
+	if err := os.MkdirAll(frameDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return p, store, frameDir
+}
+
+// writeWork writes the frames and returns their work items
+func writeWork(t *testing.T, frameDir string, frames []testFrame) []models.WorkItem {
+	t.Helper()
+	var work []models.WorkItem
+	for i, frame := range frames {
+		name := fmt.Sprintf("frame_%04d.jpg", i+1)
+		if frame.img != nil {
+			writeFrame(t, frameDir, name, frame.img)
+		}
+		work = append(work, models.WorkItem{FramePath: name, FrameNum: i + 1, TimestampMs: int64(i) * 1000})
+	}
+	return work
+}
+
+func TestSkipDuplicates(t *testing.T) {
+	a, b := pattern(1, 0), pattern(2, 0)
+	tests := []struct {
+		name        string
+		maxDistance int
+		frames      []testFrame
+		wantKept    []string
+		wantOf      map[string]string // duplicate frame to the frame it duplicates
+	}{
+		{
+			name:        "all different",
+			maxDistance: DefaultDedupDistance,
+			frames:      []testFrame{{a}, {b}, {pattern(3, 0)}},
+			wantKept:    []string{"frame_0001.jpg", "frame_0002.jpg", "frame_0003.jpg"},
+		},
+		{
+			name:        "repeated frames",
+			maxDistance: DefaultDedupDistance,
+			frames:      []testFrame{{a}, {a}, {pattern(1, 10)}, {b}, {b}},
+			wantKept:    []string{"frame_0001.jpg", "frame_0004.jpg"},
+			wantOf: map[string]string{
+				"frame_0002.jpg": "frame_0001.jpg",
+				"frame_0003.jpg": "frame_0001.jpg",
+				"frame_0005.jpg": "frame_0004.jpg",
+			},
+		},
+		{
+			// Compared with the last frame kept, not the first one like it
+			name:        "scene returns",
+			maxDistance: DefaultDedupDistance,
+			frames:      []testFrame{{a}, {b}, {a}},
+			wantKept:    []string{"frame_0001.jpg", "frame_0002.jpg", "frame_0003.jpg"},
+		},
+		{
+			name:        "unreadable frames are kept",
+			maxDistance: DefaultDedupDistance,
+			frames:      []testFrame{{a}, {nil}, {a}},
+			wantKept:    []string{"frame_0001.jpg", "frame_0002.jpg"},
+			wantOf:      map[string]string{"frame_0003.jpg": "frame_0001.jpg"},
+		},
+		{
+			name:        "64 skips every frame after the first",
+			maxDistance: 64,
+			frames:      []testFrame{{a}, {b}, {pattern(3, 0)}},
+			wantKept:    []string{"frame_0001.jpg"},
+			wantOf: map[string]string{
+				"frame_0002.jpg": "frame_0001.jpg",
+				"frame_0003.jpg": "frame_0001.jpg",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var published []events.Event
+			publisher := events.PublisherFunc(func(event events.Event) { published = append(published, event) })
+			opts := Options{Dedup: DedupOptions{Enabled: true, MaxDistance: tt.maxDistance}}
+			p, store, frameDir := newTestProcessor(t, opts, publisher)
+			work := writeWork(t, frameDir, tt.frames)
+
+			kept, err := p.skipDuplicates(context.Background(), work, frameDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keptNames []string
+			for _, item := range kept {
+				keptNames = append(keptNames, item.FramePath)
+			}
+			if !slices.Equal(keptNames, tt.wantKept) {
+				t.Errorf("kept %v, want %v", keptNames, tt.wantKept)
+			}
+
+			statuses, err := store.FrameStatuses(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(statuses) != len(tt.wantOf) {
+				t.Errorf("%d frame statuses recorded, want %d", len(statuses), len(tt.wantOf))
+			}
+			for frame, of := range tt.wantOf {
+				status := statuses[frame]
+				if status.Status != models.FrameDuplicate || status.DuplicateOf != of || status.Hash == "" {
+					t.Errorf("%s: status %+v, want a duplicate of %s", frame, status, of)
+				}
+			}
+			if len(published) != len(tt.wantOf) {
+				t.Errorf("%d events published, want %d", len(published), len(tt.wantOf))
+			}
+			for i, event := range published {
+				if event.Type != events.FrameDuplicate || event.Progress == nil || event.Progress.Duplicates != i+1 {
+					t.Errorf("event %d: %+v, want the %d. duplicate", i, event, i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestSkipDuplicatesCancelled(t *testing.T) {
+	p, _, frameDir := newTestProcessor(t, Options{Dedup: DedupOptions{Enabled: true}}, nil)
+	work := writeWork(t, frameDir, []testFrame{{pattern(1, 0)}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.skipDuplicates(ctx, work, frameDir); err == nil {
+		t.Error("expected an error for a cancelled context")
+	}
+}
@@ -0,0 +1,86 @@
+package analyzer
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/agent-api/ollama/client"
+)
+
+// ollamaProvider talks to the Ollama chat API
+type ollamaProvider struct {
+	config     ProviderConfig
+	client     *client.OllamaClient
+	httpClient *http.Client
+}
+
+func newOllamaProvider(config ProviderConfig) *ollamaProvider {
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	return &ollamaProvider{
+		config:     config,
+		client:     client.NewClient(client.WithBaseURL(config.BaseURL + "/api")),
+		httpClient: http.DefaultClient,
+	}
+}
+
+// ping lists the local models to check that Ollama is running
+func (p *ollamaProvider) ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.config.BaseURL+"/api/tags", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// DescribeImage implements VisionProvider
+func (p *ollamaProvider) DescribeImage(ctx context.Context, req DescribeRequest) (*Description, error) {
+	image, err := os.ReadFile(req.ImagePath)
+	if err != nil {
+		return nil, &permanentError{fmt.Errorf("failed to read image '%s': %w", req.ImagePath, err)}
+	}
+
+	chat := &client.ChatRequest{
+		Model: p.config.Model,
+		Messages: []*client.Message{
+			{Role: client.RoleSystem, Content: p.config.SystemPrompt},
+			{Role: client.RoleUser, Content: req.Prompt, Images: []string{base64.StdEncoding.EncodeToString(image)}},
+		},
+	}
+	if req.JSON {
+		format := "json"
+		chat.Format = &format
+	}
+
+	start := time.Now()
+	resp, err := p.client.Chat(ctx, chat)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, fmt.Errorf("no response received from model")
+	}
+
+	return &Description{
+		Text: resp.Message.Content,
+		Usage: Usage{
+			Model:            resp.Model,
+			PromptTokens:     resp.PromptEvalCount,
+			CompletionTokens: resp.EvalCount,
+			Duration:         time.Since(start),
+		},
+	}, nil
+}
@@ -0,0 +1,161 @@
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// openAIProvider talks to any OpenAI-compatible chat-completions endpoint
+type openAIProvider struct {
+	config     ProviderConfig
+	httpClient *http.Client
+}
+
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+type openAIChatRequest struct {
+	Model          string          `json:"model"`
+	Messages       []openAIMessage `json:"messages"`
+	ResponseFormat *openAIFormat   `json:"response_format,omitempty"`
+}
+
+type openAIFormat struct {
+	Type string `json:"type"`
+}
+
+type openAIChatResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+func newOpenAIProvider(config ProviderConfig) *openAIProvider {
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	return &openAIProvider{
+		config:     config,
+		httpClient: http.DefaultClient,
+	}
+}
+
+// newRequest builds a request against the API root with authentication set
+func (p *openAIProvider) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, p.config.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.APIKey)
+	}
+	return req, nil
+}
+
+// ping lists the served models to check that the server is up
+func (p *openAIProvider) ping(ctx context.Context) error {
+	req, err := p.newRequest(ctx, http.MethodGet, "/models", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// DescribeImage implements VisionProvider
+func (p *openAIProvider) DescribeImage(ctx context.Context, req DescribeRequest) (*Description, error) {
+	image, err := os.ReadFile(req.ImagePath)
+	if err != nil {
+		return nil, &permanentError{fmt.Errorf("failed to read image '%s': %w", req.ImagePath, err)}
+	}
+
+	chat := openAIChatRequest{
+		Model: p.config.Model,
+		Messages: []openAIMessage{
+			{Role: "system", Content: p.config.SystemPrompt},
+			{Role: "user", Content: []openAIContentPart{
+				{Type: "text", Text: req.Prompt},
+				{Type: "image_url", ImageURL: &openAIImageURL{
+					URL: "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(image),
+				}},
+			}},
+		},
+	}
+	if req.JSON {
+		chat.ResponseFormat = &openAIFormat{Type: "json_object"}
+	}
+
+	body, err := json.Marshal(chat)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := p.newRequest(ctx, http.MethodPost, "/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	start := time.Now()
+	resp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var answer openAIChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(answer.Choices) == 0 {
+		return nil, fmt.Errorf("no choices received from model")
+	}
+
+	return &Description{
+		Text: answer.Choices[0].Message.Content,
+		Usage: Usage{
+			Model:            answer.Model,
+			PromptTokens:     answer.Usage.PromptTokens,
+			CompletionTokens: answer.Usage.CompletionTokens,
+			Duration:         time.Since(start),
+		},
+	}, nil
+}
@@ -0,0 +1,101 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// DefaultSystemPrompt is sent with every image unless a provider is configured otherwise
+const DefaultSystemPrompt = "You are a visual analysis assistant specialized in detailed image descriptions. If there is a person in the image describe what they are doing in step by step format."
+
+// Provider types understood by NewVisionProvider
+const (
+	ProviderOllama = "ollama"
+	ProviderOpenAI = "openai"
+)
+
+// VisionProvider describes images with a vision language model
+type VisionProvider interface {
+	// DescribeImage sends an image together with a prompt to the model
+	DescribeImage(ctx context.Context, req DescribeRequest) (*Description, error)
+}
+
+// DescribeRequest is a single image prompt
+type DescribeRequest struct {
+	ImagePath string
+	Prompt    string
+
+	// JSON asks the backend to constrain the answer to a JSON object
+	JSON bool
+}
+
+// Description is the model's answer for a single image
+type Description struct {
+	Text  string
+	Usage Usage
+}
+
+// Usage reports what a single model call cost
+type Usage struct {
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Duration         time.Duration
+}
+
+// ProviderConfig selects and configures a vision backend
+type ProviderConfig struct {
+	// Type is ProviderOllama or ProviderOpenAI (any OpenAI-compatible
+	// chat-completions server such as llama.cpp, vLLM or LM Studio)
+	Type string
+
+	// BaseURL is the API root, e.g. http://localhost:11434 for Ollama or
+	// http://localhost:8080/v1 for an OpenAI-compatible server
+	BaseURL string
+
+	Model        string
+	APIKey       string
+	SystemPrompt string
+}
+
+// checkedProvider is a VisionProvider that can verify its backend is up
+type checkedProvider interface {
+	VisionProvider
+	ping(ctx context.Context) error
+}
+
+// NewVisionProvider creates the configured provider and checks that its backend is reachable
+func NewVisionProvider(ctx context.Context, config ProviderConfig, logger *logr.Logger) (VisionProvider, error) {
+	if config.Model == "" {
+		return nil, fmt.Errorf("no model configured for %s provider", config.Type)
+	}
+	if config.SystemPrompt == "" {
+		config.SystemPrompt = DefaultSystemPrompt
+	}
+
+	var provider checkedProvider
+	switch config.Type {
+	case ProviderOllama:
+		if config.BaseURL == "" {
+			config.BaseURL = "http://localhost:11434"
+		}
+		provider = newOllamaProvider(config)
+	case ProviderOpenAI:
+		if config.BaseURL == "" {
+			config.BaseURL = "http://localhost:8080/v1"
+		}
+		provider = newOpenAIProvider(config)
+	default:
+		return nil, fmt.Errorf("unknown vision provider '%s'", config.Type)
+	}
+
+	if err := provider.ping(ctx); err != nil {
+		return nil, fmt.Errorf("%s backend at %s is not reachable: %w", config.Type, config.BaseURL, err)
+	}
+
+	logger.Info("Using vision provider", "type", config.Type, "url", config.BaseURL, "model", config.Model)
+	return provider, nil
+}
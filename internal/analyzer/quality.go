@@ -0,0 +1,167 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bdougie/vision/internal/events"
+	"github.com/bdougie/vision/internal/extractor"
+	"github.com/bdougie/vision/internal/models"
+	"github.com/bdougie/vision/internal/quality"
+)
+
+// DefaultReplaceWindow is how far from a bad frame a replacement is looked
+// for when none is configured
+const DefaultReplaceWindow = time.Second
+
+// replaceSteps is how many positions are tried on each side of a bad frame
+const replaceSteps = 4
+
+// QualityOptions controls the filtering of black, blurred and blank frames
+// before analysis
+type QualityOptions struct {
+	Enabled    bool
+	Thresholds quality.Thresholds
+
+	// Replace grabs frames up to ReplaceWindow before and after a bad frame
+	// and analyzes the nearest good one instead, bad frames without one are
+	// dropped either way
+	Replace       bool
+	ReplaceWindow time.Duration
+}
+
+// filterQuality measures every frame and leaves out those failing the
+// thresholds, recording why. With Replace a failing frame is swapped for the
+// nearest good frame of the video instead, if there is one. Frames rejected
+// by an earlier run are not searched again unless Force is set. Frames that
+// cannot be measured are kept.
+func (p *Processor) filterQuality(ctx context.Context, video *models.VideoMetadata, work []models.WorkItem, frameDirPath string) ([]models.WorkItem, error) {
+	statuses, err := p.storage.FrameStatuses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []models.WorkItem
+	for _, item := range work {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("frame quality check cancelled: %w", err)
+		}
+
+		metrics, err := quality.File(filepath.Join(frameDirPath, item.FramePath))
+		if err != nil {
+			fmt.Printf("Warning: failed to measure %s, analyzing it anyway: %v\n", item.FramePath, err)
+			kept = append(kept, item)
+			continue
+		}
+		problem := p.opts.Quality.Thresholds.Check(metrics)
+		if problem == "" {
+			kept = append(kept, item)
+			continue
+		}
+
+		if p.opts.Quality.Replace && (p.opts.Force || statuses[item.FramePath].Status != models.FrameRejected) {
+			replacement, err := p.replaceFrame(ctx, video, item, frameDirPath, problem)
+			if err != nil {
+				return nil, err
+			}
+			if replacement != nil {
+				kept = append(kept, *replacement)
+				continue
+			}
+		}
+
+		status := models.FrameStatus{
+			Frame:       item.FramePath,
+			TimestampMs: item.TimestampMs,
+			Status:      models.FrameRejected,
+			Reason:      problem,
+		}
+		if err := p.storage.SetFrameStatus(ctx, status); err != nil {
+			return nil, err
+		}
+		p.progress.Rejected++
+		p.qualityEvent(events.FrameRejected, item, problem)
+	}
+
+	if p.progress.Rejected > 0 || p.progress.Replaced > 0 {
+		fmt.Printf("Quality check: %d of %d frames rejected, %d replaced by a frame nearby\n",
+			p.progress.Rejected, len(work), p.progress.Replaced)
+	}
+	return kept, nil
+}
+
+// replaceFrame grabs frames ever further from a bad one, later before
+// earlier, and puts the first that passes the thresholds in its place. The
+// search stays between the neighbouring frames so the order of the frames
+// holds. It returns nil when no frame within the window passes.
+func (p *Processor) replaceFrame(ctx context.Context, video *models.VideoMetadata, item models.WorkItem, frameDirPath, problem string) (*models.WorkItem, error) {
+	step := p.opts.Quality.ReplaceWindow.Milliseconds() / replaceSteps
+	if step <= 0 {
+		return nil, nil
+	}
+	manifest, err := extractor.LoadManifest(frameDirPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load frame manifest: %w", err)
+	}
+	before, after := manifest.Neighbours(item.FramePath)
+	between := func(ms int64) bool {
+		return ms > before && (after < 0 || ms < after) && ms < video.DurationMs && ms != item.TimestampMs
+	}
+
+	candidatePath := filepath.Join(frameDirPath, ".replacement.jpg")
+	defer os.Remove(candidatePath)
+	for i := int64(1); i <= replaceSteps; i++ {
+		for _, ms := range []int64{item.TimestampMs + i*step, item.TimestampMs - i*step} {
+			if !between(ms) {
+				continue
+			}
+			timestamp, err := extractor.GrabFrame(ctx, video.Path, candidatePath, ms)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, err
+				}
+				continue
+			}
+			if !between(timestamp) {
+				continue
+			}
+			metrics, err := quality.File(candidatePath)
+			if err != nil || p.opts.Quality.Thresholds.Check(metrics) != "" {
+				continue
+			}
+
+			reason := fmt.Sprintf("%s at %s, replaced by the frame at %s", problem,
+				time.Duration(item.TimestampMs)*time.Millisecond, time.Duration(timestamp)*time.Millisecond)
+			if err := extractor.ReplaceFrame(frameDirPath, item.FramePath, candidatePath, timestamp, reason); err != nil {
+				return nil, err
+			}
+			item.TimestampMs = timestamp
+			item.Replaced = reason
+			p.replaced[item.FramePath] = true
+			p.progress.Replaced++
+			p.qualityEvent(events.FrameReplaced, item, reason)
+			return &item, nil
+		}
+	}
+	return nil, nil
+}
+
+// qualityEvent reports a frame rejected or replaced by the quality check
+func (p *Processor) qualityEvent(eventType events.Type, item models.WorkItem, reason string) {
+	progress := p.progress
+	event := events.Event{
+		Type:        eventType,
+		Frame:       item.FramePath,
+		FrameNumber: item.FrameNum,
+		TimestampMs: item.TimestampMs,
+		Reason:      reason,
+		Progress:    &progress,
+	}
+	if eventType == events.FrameRejected {
+		event.Status = models.FrameRejected
+	}
+	p.publish(event)
+}
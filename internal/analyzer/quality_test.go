@@ -0,0 +1,157 @@
+package analyzer
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bdougie/vision/internal/events"
+	"github.com/bdougie/vision/internal/models"
+	"github.com/bdougie/vision/internal/quality"
+)
+
+// flat returns a 160x90 frame of a single brightness
+func flat(brightness uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 160, 90))
+	for i := range img.Pix {
+		img.Pix[i] = brightness
+	}
+	return img
+}
+
+// blocks returns a 160x90 frame of 8x8 squares of varied brightness, frames
+// of different variants differ
+func blocks(variant int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 160, 90))
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 160; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8((x/8*37 + y/8*91 + variant*53) % 256)})
+		}
+	}
+	return img
+}
+
+// smeared blurs a frame, every pixel becomes the mean of the square of
+// pixels within radius of it
+func smeared(src *image.Gray, radius int) *image.Gray {
+	bounds := src.Bounds()
+	img := image.NewGray(bounds)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			sum, n := 0, 0
+			for sy := max(0, y-radius); sy <= min(bounds.Dy()-1, y+radius); sy++ {
+				for sx := max(0, x-radius); sx <= min(bounds.Dx()-1, x+radius); sx++ {
+					sum += int(src.GrayAt(sx, sy).Y)
+					n++
+				}
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(sum / n)})
+		}
+	}
+	return img
+}
+
+func TestFilterQuality(t *testing.T) {
+	thresholds := quality.Thresholds{MinLuminance: 20, MaxLuminance: 240, MinEntropy: 0.5, MinSharpness: 100}
+	tests := []struct {
+		name         string
+		frames       []testFrame
+		replace      bool
+		earlier      map[string]models.FrameStatus // statuses left by an earlier run
+		wantKept     []string
+		wantRejected map[string]string // rejected frame to the start of its reason
+	}{
+		{
+			name:     "good frames",
+			frames:   []testFrame{{blocks(1)}, {blocks(2)}},
+			wantKept: []string{"frame_0001.jpg", "frame_0002.jpg"},
+		},
+		{
+			name:     "bad frames",
+			frames:   []testFrame{{flat(0)}, {blocks(1)}, {flat(255)}, {flat(128)}, {smeared(blocks(2), 6)}},
+			wantKept: []string{"frame_0002.jpg"},
+			wantRejected: map[string]string{
+				"frame_0001.jpg": "black frame",
+				"frame_0003.jpg": "washed out frame",
+				"frame_0004.jpg": "low information frame",
+				"frame_0005.jpg": "blurred frame",
+			},
+		},
+		{
+			name:     "unreadable frames are kept",
+			frames:   []testFrame{{nil}, {flat(0)}},
+			wantKept: []string{"frame_0001.jpg"},
+			wantRejected: map[string]string{
+				"frame_0002.jpg": "black frame",
+			},
+		},
+		{
+			// Frames an earlier run found no replacement for are not searched
+			// again, the search would fail here without a manifest
+			name:    "rejected earlier",
+			frames:  []testFrame{{flat(0)}},
+			replace: true,
+			earlier: map[string]models.FrameStatus{
+				"frame_0001.jpg": {Frame: "frame_0001.jpg", Status: models.FrameRejected, Reason: "black frame"},
+			},
+			wantRejected: map[string]string{
+				"frame_0001.jpg": "black frame",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var published []events.Event
+			publisher := events.PublisherFunc(func(event events.Event) { published = append(published, event) })
+			opts := Options{
+				Quality: QualityOptions{Enabled: true, Thresholds: thresholds, Replace: tt.replace},
+			}
+			p, store, frameDir := newTestProcessor(t, opts, publisher)
+			work := writeWork(t, frameDir, tt.frames)
+			for _, status := range tt.earlier {
+				if err := store.SetFrameStatus(context.Background(), status); err != nil {
+					t.Fatal(err)
+				}
+			}
+			video := &models.VideoMetadata{Path: "video.mp4", DurationMs: int64(len(work)) * 1000}
+
+			kept, err := p.filterQuality(context.Background(), video, work, frameDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keptNames []string
+			for _, item := range kept {
+				keptNames = append(keptNames, item.FramePath)
+			}
+			if !slices.Equal(keptNames, tt.wantKept) {
+				t.Errorf("kept %v, want %v", keptNames, tt.wantKept)
+			}
+
+			statuses, err := store.FrameStatuses(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for frame, reason := range tt.wantRejected {
+				status := statuses[frame]
+				if status.Status != models.FrameRejected || !strings.HasPrefix(status.Reason, reason) {
+					t.Errorf("%s: status %+v, want rejected as %q", frame, status, reason)
+				}
+			}
+			if p.progress.Rejected != len(tt.wantRejected) {
+				t.Errorf("%d frames counted as rejected, want %d", p.progress.Rejected, len(tt.wantRejected))
+			}
+			if len(published) != len(tt.wantRejected) {
+				t.Errorf("%d events published, want %d", len(published), len(tt.wantRejected))
+			}
+			for _, event := range published {
+				if event.Type != events.FrameRejected || !strings.HasPrefix(event.Reason, tt.wantRejected[event.Frame]) {
+					t.Errorf("unexpected event %+v", event)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the backend while the circuit breaker is open
+var ErrCircuitOpen = errors.New("vision backend unavailable, circuit breaker is open")
+
+// RetryPolicy controls timeouts, retries and the circuit breaker for model calls
+type RetryPolicy struct {
+	// MaxAttempts is the total number of tries per call, including the first
+	MaxAttempts int
+
+	// InitialBackoff is the upper bound of the first wait, doubling up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// CallTimeout bounds a single model call, zero means no limit
+	CallTimeout time.Duration
+
+	// BreakerThreshold consecutive failures open the circuit for BreakerCooldown
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// DefaultRetryPolicy returns the retry settings used when nothing is configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:      3,
+		InitialBackoff:   2 * time.Second,
+		MaxBackoff:       30 * time.Second,
+		CallTimeout:      5 * time.Minute,
+		BreakerThreshold: 5,
+		BreakerCooldown:  time.Minute,
+	}
+}
+
+// backoff returns a randomized wait before retry number attempt (starting at 1),
+// using full jitter so concurrent workers do not hit the backend in lockstep
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.InitialBackoff << (attempt - 1)
+	if ceiling <= 0 || ceiling > p.MaxBackoff {
+		ceiling = p.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// StatusError is an HTTP error response from a vision backend
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// permanentError marks failures that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// statusInMessage finds the HTTP status in errors from the Ollama client, which
+// only reports it as text
+var statusInMessage = regexp.MustCompile(`status (\d{3})`)
+
+// isRetryable reports whether a failed call may succeed when tried again
+func isRetryable(err error) bool {
+	var permanent *permanentError
+	if errors.As(err, &permanent) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	status := 0
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		status = statusErr.StatusCode
+	} else if match := statusInMessage.FindStringSubmatch(err.Error()); match != nil {
+		status, _ = strconv.Atoi(match[1])
+	}
+	switch {
+	case status == 0:
+		// Unknown failures such as dropped connections are worth another try
+		return true
+	case status == 408 || status == 429 || status >= 500:
+		return true
+	default:
+		return false
+	}
+}
+
+// circuitBreaker stops calls to a backend after repeated failures and lets a
+// single trial call through once the cooldown has passed
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a call may go ahead and whether it is the trial
+// call of a half-open breaker
+func (b *circuitBreaker) allow() (allowed, trial bool) {
+	if b.threshold <= 0 {
+		return true, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true, false
+	}
+	if time.Now().Before(b.openUntil) || b.trial {
+		return false, false
+	}
+	// Half-open: one caller probes the backend
+	b.trial = true
+	return true, true
+}
+
+// abandon gives up the trial of a call that ended without an outcome, so
+// the next caller probes the backend instead
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+}
+
+// record updates the breaker with the outcome of a call
+func (b *circuitBreaker) record(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	if err == nil {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// callWithRetry runs call with a per-attempt timeout, retrying retryable errors
+// with exponential backoff. It returns the number of attempts made.
+func callWithRetry[T any](ctx context.Context, policy RetryPolicy, breaker *circuitBreaker, call func(ctx context.Context) (T, error)) (T, int, error) {
+	var zero T
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			wait := policy.backoff(attempt - 1)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return zero, attempt - 1, ctx.Err()
+			}
+		}
+
+		allowed, trial := breaker.allow()
+		if !allowed {
+			return zero, attempt - 1, ErrCircuitOpen
+		}
+
+		callCtx, cancel := ctx, context.CancelFunc(func() {})
+		if policy.CallTimeout > 0 {
+			callCtx, cancel = context.WithTimeout(ctx, policy.CallTimeout)
+		}
+		value, err := call(callCtx)
+		cancel()
+
+		// A cancelled run is not the backend's fault
+		if ctx.Err() != nil {
+			if trial {
+				breaker.abandon()
+			}
+			return zero, attempt, ctx.Err()
+		}
+
+		if err != nil && !isRetryable(err) {
+			// The backend answered, so it is up even though this request failed
+			breaker.record(nil)
+			return zero, attempt, err
+		}
+		breaker.record(err)
+		if err == nil {
+			return value, attempt, nil
+		}
+
+		lastErr = err
+		fmt.Printf("Model call failed (attempt %d/%d): %v\n", attempt, maxAttempts, err)
+	}
+
+	return zero, maxAttempts, fmt.Errorf("giving up after %d attempts: %w", maxAttempts, lastErr)
+}
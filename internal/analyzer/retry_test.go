@@ -0,0 +1,234 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		ceiling time.Duration // waits fall in [0, ceiling)
+	}{
+		{"first retry", RetryPolicy{InitialBackoff: time.Second, MaxBackoff: time.Minute}, 1, time.Second},
+		{"doubles", RetryPolicy{InitialBackoff: time.Second, MaxBackoff: time.Minute}, 3, 4 * time.Second},
+		{"capped", RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}, 4, 5 * time.Second},
+		// The shift overflows and is capped instead of going negative
+		{"overflow", RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}, 64, 5 * time.Second},
+		{"no backoff", RetryPolicy{}, 2, 0},
+	}
+	for _, tt := range tests {
+		var longest time.Duration
+		for range 1000 {
+			wait := tt.policy.backoff(tt.attempt)
+			if wait < 0 || (tt.ceiling > 0 && wait >= tt.ceiling) || (tt.ceiling == 0 && wait != 0) {
+				t.Fatalf("%s: waited %v, want [0, %v)", tt.name, wait, tt.ceiling)
+			}
+			longest = max(longest, wait)
+		}
+		// Full jitter spreads the waits over the whole range
+		if longest < tt.ceiling/2 {
+			t.Errorf("%s: longest of 1000 waits %v, want it near %v", tt.name, longest, tt.ceiling)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unknown failure", errors.New("connection reset by peer"), true},
+		{"deadline", context.DeadlineExceeded, true},
+		{"wrapped deadline", fmt.Errorf("call: %w", context.DeadlineExceeded), true},
+		{"cancelled", context.Canceled, false},
+		{"network", &net.DNSError{Err: "no such host", Name: "ollama"}, true},
+		{"permanent", &permanentError{err: errors.New("unsupported image")}, false},
+		{"permanent wrapping a deadline", &permanentError{err: context.DeadlineExceeded}, false},
+		{"408", &StatusError{StatusCode: 408}, true},
+		{"429", &StatusError{StatusCode: 429}, true},
+		{"500", &StatusError{StatusCode: 500}, true},
+		{"503 wrapped", fmt.Errorf("analyze: %w", &StatusError{StatusCode: 503}), true},
+		{"400", &StatusError{StatusCode: 400}, false},
+		{"404", &StatusError{StatusCode: 404}, false},
+		// The Ollama client only reports the status as text
+		{"status in message", errors.New("model request failed: status 502"), true},
+		{"client status in message", errors.New("model not found (status 404)"), false},
+	}
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Errorf("%s: isRetryable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	failure := errors.New("backend down")
+
+	// step is one call on the breaker: allow reports what allow should return,
+	// the other actions change the breaker
+	type step struct {
+		action         string // allow, fail, succeed, abandon or cool (end the cooldown)
+		allowed, trial bool
+	}
+	tests := []struct {
+		name      string
+		threshold int
+		steps     []step
+	}{
+		{
+			name:      "closed below the threshold",
+			threshold: 3,
+			steps:     []step{{action: "fail"}, {action: "fail"}, {action: "allow", allowed: true}},
+		},
+		{
+			name:      "success resets the failures",
+			threshold: 2,
+			steps: []step{
+				{action: "fail"}, {action: "succeed"}, {action: "fail"},
+				{action: "allow", allowed: true},
+			},
+		},
+		{
+			name:      "opens at the threshold",
+			threshold: 2,
+			steps:     []step{{action: "fail"}, {action: "fail"}, {action: "allow"}, {action: "allow"}},
+		},
+		{
+			name:      "half-open lets one trial through",
+			threshold: 2,
+			steps: []step{
+				{action: "fail"}, {action: "fail"}, {action: "cool"},
+				{action: "allow", allowed: true, trial: true},
+				{action: "allow"},
+			},
+		},
+		{
+			name:      "successful trial closes",
+			threshold: 2,
+			steps: []step{
+				{action: "fail"}, {action: "fail"}, {action: "cool"},
+				{action: "allow", allowed: true, trial: true}, {action: "succeed"},
+				{action: "allow", allowed: true}, {action: "allow", allowed: true},
+			},
+		},
+		{
+			name:      "failed trial opens again",
+			threshold: 2,
+			steps: []step{
+				{action: "fail"}, {action: "fail"}, {action: "cool"},
+				{action: "allow", allowed: true, trial: true}, {action: "fail"},
+				{action: "allow"},
+			},
+		},
+		{
+			// An abandoned trial leaves the breaker half-open for the next caller
+			name:      "abandoned trial",
+			threshold: 2,
+			steps: []step{
+				{action: "fail"}, {action: "fail"}, {action: "cool"},
+				{action: "allow", allowed: true, trial: true}, {action: "abandon"},
+				{action: "allow", allowed: true, trial: true},
+				{action: "allow"},
+			},
+		},
+		{
+			name:      "disabled",
+			threshold: 0,
+			steps:     []step{{action: "fail"}, {action: "fail"}, {action: "allow", allowed: true}},
+		},
+	}
+	for _, tt := range tests {
+		b := newCircuitBreaker(tt.threshold, time.Hour)
+		for i, s := range tt.steps {
+			switch s.action {
+			case "allow":
+				if allowed, trial := b.allow(); allowed != s.allowed || trial != s.trial {
+					t.Errorf("%s: step %d: allow() = %v, %v, want %v, %v", tt.name, i, allowed, trial, s.allowed, s.trial)
+				}
+			case "fail":
+				b.record(failure)
+			case "succeed":
+				b.record(nil)
+			case "abandon":
+				b.abandon()
+			case "cool":
+				b.openUntil = time.Now().Add(-time.Second)
+			}
+		}
+	}
+}
+
+func TestCallWithRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	tests := []struct {
+		name         string
+		errs         []error // returned by successive calls, nil succeeds
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"first try", []error{nil}, 1, false},
+		{"retried", []error{&StatusError{StatusCode: 503}, context.DeadlineExceeded, nil}, 3, false},
+		{"gives up", []error{&StatusError{StatusCode: 503}, &StatusError{StatusCode: 503}, &StatusError{StatusCode: 503}}, 3, true},
+		{"permanent", []error{&StatusError{StatusCode: 400}}, 1, true},
+	}
+	for _, tt := range tests {
+		calls := 0
+		breaker := newCircuitBreaker(10, time.Hour)
+		value, attempts, err := callWithRetry(context.Background(), policy, breaker, func(ctx context.Context) (string, error) {
+			err := tt.errs[calls]
+			calls++
+			if err != nil {
+				return "", err
+			}
+			return "ok", nil
+		})
+		if attempts != tt.wantAttempts || calls != tt.wantAttempts || (err != nil) != tt.wantErr {
+			t.Errorf("%s: %d attempts, %d calls, error %v, want %d attempts", tt.name, attempts, calls, err, tt.wantAttempts)
+		}
+		if !tt.wantErr && value != "ok" {
+			t.Errorf("%s: value %q, want ok", tt.name, value)
+		}
+	}
+}
+
+func TestCallWithRetryOpenBreaker(t *testing.T) {
+	breaker := newCircuitBreaker(1, time.Hour)
+	breaker.record(errors.New("backend down"))
+
+	calls := 0
+	_, attempts, err := callWithRetry(context.Background(), DefaultRetryPolicy(), breaker, func(ctx context.Context) (int, error) {
+		calls++
+		return 0, nil
+	})
+	if !errors.Is(err, ErrCircuitOpen) || attempts != 0 || calls != 0 {
+		t.Errorf("error %v after %d attempts and %d calls, want ErrCircuitOpen without a call", err, attempts, calls)
+	}
+}
+
+func TestCallWithRetryCancelledTrial(t *testing.T) {
+	breaker := newCircuitBreaker(1, time.Hour)
+	breaker.record(errors.New("backend down"))
+	breaker.openUntil = time.Now().Add(-time.Second)
+
+	// The run is cancelled during the trial call, which says nothing about
+	// the backend: the trial is given up rather than kept forever
+	ctx, cancel := context.WithCancel(context.Background())
+	_, _, err := callWithRetry(ctx, DefaultRetryPolicy(), breaker, func(ctx context.Context) (int, error) {
+		cancel()
+		return 0, ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error %v, want context.Canceled", err)
+	}
+	if allowed, trial := breaker.allow(); !allowed || !trial {
+		t.Errorf("allow() = %v, %v after a cancelled trial, want the next trial", allowed, trial)
+	}
+}
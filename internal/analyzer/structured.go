@@ -0,0 +1,129 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bdougie/vision/internal/models"
+)
+
+// structuredInstructions is appended to the frame prompt in structured mode
+const structuredInstructions = `
+Respond with a single JSON object and nothing else, using exactly this schema:
+{
+  "summary": "one or two sentences describing the scene",
+  "objects": [{"name": "singular noun", "count": 1}],
+  "people": [{"description": "who the person appears to be", "action": "what they are doing"}],
+  "visible_text": ["text that can be read in the image"],
+  "setting": "where the scene takes place",
+  "tags": ["short lowercase keywords"]
+}
+Use empty arrays when nothing applies.`
+
+// repairInstructions asks the model to fix an answer that did not parse
+const repairInstructions = `Your previous answer could not be used: %v
+Previous answer:
+%s
+
+Look at the image again and respond with only the JSON object in the required schema.`
+
+// parseFrameAnalysis extracts and validates a FrameAnalysis from a model response.
+// Models often wrap JSON in markdown fences or add a sentence around it, so the
+// outermost object is cut out before decoding.
+func parseFrameAnalysis(text string) (*models.FrameAnalysis, error) {
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no JSON object found in response")
+	}
+
+	var analysis models.FrameAnalysis
+	decoder := json.NewDecoder(strings.NewReader(text[start : end+1]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&analysis); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	if err := normalizeFrameAnalysis(&analysis); err != nil {
+		return nil, err
+	}
+	return &analysis, nil
+}
+
+// normalizeFrameAnalysis validates the fields and cleans up common model quirks
+func normalizeFrameAnalysis(analysis *models.FrameAnalysis) error {
+	analysis.Summary = strings.TrimSpace(analysis.Summary)
+	if analysis.Summary == "" {
+		return fmt.Errorf("summary is empty")
+	}
+	analysis.Setting = strings.TrimSpace(analysis.Setting)
+
+	objects := analysis.Objects[:0]
+	for _, object := range analysis.Objects {
+		object.Name = strings.ToLower(strings.TrimSpace(object.Name))
+		if object.Name == "" {
+			continue
+		}
+		if object.Count < 0 {
+			return fmt.Errorf("object '%s' has negative count %d", object.Name, object.Count)
+		}
+		if object.Count == 0 {
+			object.Count = 1
+		}
+		objects = append(objects, object)
+	}
+	analysis.Objects = objects
+
+	seen := make(map[string]bool)
+	tags := analysis.Tags[:0]
+	for _, tag := range analysis.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	analysis.Tags = tags
+
+	// Keep arrays non-nil so they serialize as [] rather than null
+	if analysis.Objects == nil {
+		analysis.Objects = []models.DetectedObject{}
+	}
+	if analysis.People == nil {
+		analysis.People = []models.Person{}
+	}
+	if analysis.VisibleText == nil {
+		analysis.VisibleText = []string{}
+	}
+	if analysis.Tags == nil {
+		analysis.Tags = []string{}
+	}
+	return nil
+}
+
+// frameAnalysisText renders a structured analysis as prose for text search and embeddings
+func frameAnalysisText(analysis *models.FrameAnalysis) string {
+	var b strings.Builder
+	b.WriteString(analysis.Summary)
+	if analysis.Setting != "" {
+		fmt.Fprintf(&b, "\nSetting: %s", analysis.Setting)
+	}
+	if len(analysis.Objects) > 0 {
+		objects := make([]string, len(analysis.Objects))
+		for i, object := range analysis.Objects {
+			objects[i] = fmt.Sprintf("%s (%d)", object.Name, object.Count)
+		}
+		fmt.Fprintf(&b, "\nObjects: %s", strings.Join(objects, ", "))
+	}
+	for _, person := range analysis.People {
+		fmt.Fprintf(&b, "\nPerson: %s, %s", person.Description, person.Action)
+	}
+	if len(analysis.VisibleText) > 0 {
+		fmt.Fprintf(&b, "\nVisible text: %s", strings.Join(analysis.VisibleText, " | "))
+	}
+	if len(analysis.Tags) > 0 {
+		fmt.Fprintf(&b, "\nTags: %s", strings.Join(analysis.Tags, ", "))
+	}
+	return b.String()
+}
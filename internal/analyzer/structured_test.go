@@ -0,0 +1,153 @@
+package analyzer
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bdougie/vision/internal/models"
+)
+
+func TestParseFrameAnalysis(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    *models.FrameAnalysis
+		wantErr string // start of the error, empty when parsing succeeds
+	}{
+		{
+			name: "plain",
+			text: `{"summary": "A red car.", "objects": [{"name": "car", "count": 1}], "people": [],
+				"visible_text": ["STOP"], "setting": "street", "tags": ["car"]}`,
+			want: &models.FrameAnalysis{
+				Summary: "A red car.", Objects: []models.DetectedObject{{Name: "car", Count: 1}}, People: []models.Person{},
+				VisibleText: []string{"STOP"}, Setting: "street", Tags: []string{"car"},
+			},
+		},
+		{
+			// The object is cut out of markdown fences and prose around it
+			name: "fenced",
+			text: "Here is the analysis:\n```json\n{\"summary\": \"A dog.\"}\n```\nLet me know if you need more.",
+			want: &models.FrameAnalysis{
+				Summary: "A dog.", Objects: []models.DetectedObject{}, People: []models.Person{},
+				VisibleText: []string{}, Tags: []string{},
+			},
+		},
+		{
+			name: "normalized",
+			text: `{"summary": "  Two cats. ", "setting": " sofa ",
+				"objects": [{"name": " Cat ", "count": 2}, {"name": "Lamp"}, {"name": "  ", "count": 3}],
+				"tags": ["Cats", "cats", " ", "indoor"]}`,
+			want: &models.FrameAnalysis{
+				Summary: "Two cats.", Setting: "sofa",
+				Objects: []models.DetectedObject{{Name: "cat", Count: 2}, {Name: "lamp", Count: 1}},
+				People:  []models.Person{}, VisibleText: []string{}, Tags: []string{"cats", "indoor"},
+			},
+		},
+		{name: "no object", text: "I cannot see the image.", wantErr: "no JSON object"},
+		{name: "braces reversed", text: "} nothing {", wantErr: "no JSON object"},
+		{name: "truncated", text: `{"summary": "A car", "objects": [}`, wantErr: "invalid JSON"},
+		{name: "unknown field", text: `{"summary": "A car.", "mood": "calm"}`, wantErr: "invalid JSON"},
+		{name: "wrong type", text: `{"summary": "A car.", "tags": "car"}`, wantErr: "invalid JSON"},
+		{name: "empty summary", text: `{"summary": "  ", "tags": ["car"]}`, wantErr: "summary is empty"},
+		{name: "negative count", text: `{"summary": "Cars.", "objects": [{"name": "car", "count": -2}]}`, wantErr: "object 'car' has negative count"},
+	}
+	for _, tt := range tests {
+		got, err := parseFrameAnalysis(tt.text)
+		if tt.wantErr != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parsed %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFrameAnalysisText(t *testing.T) {
+	analysis := &models.FrameAnalysis{
+		Summary:     "A man crosses the street.",
+		Setting:     "city",
+		Objects:     []models.DetectedObject{{Name: "car", Count: 2}, {Name: "bus", Count: 1}},
+		People:      []models.Person{{Description: "a man in a coat", Action: "walking"}},
+		VisibleText: []string{"STOP", "Main St"},
+		Tags:        []string{"street", "traffic"},
+	}
+	want := "A man crosses the street.\nSetting: city\nObjects: car (2), bus (1)\nPerson: a man in a coat, walking" +
+		"\nVisible text: STOP | Main St\nTags: street, traffic"
+	if got := frameAnalysisText(analysis); got != want {
+		t.Errorf("text %q, want %q", got, want)
+	}
+	if got := frameAnalysisText(&models.FrameAnalysis{Summary: "Nothing."}); got != "Nothing." {
+		t.Errorf("text %q, want only the summary", got)
+	}
+}
+
+// scriptedProvider answers successive calls with its answers in turn and
+// records the prompts it was sent
+type scriptedProvider struct {
+	answers []string
+	prompts []string
+}
+
+func (s *scriptedProvider) DescribeImage(ctx context.Context, req DescribeRequest) (*Description, error) {
+	s.prompts = append(s.prompts, req.Prompt)
+	answer := s.answers[len(s.prompts)-1]
+	return &Description{Text: answer}, nil
+}
+
+func TestAnalyzeImageStructuredRepair(t *testing.T) {
+	const valid = `{"summary": "A red car."}`
+	tests := []struct {
+		name         string
+		retries      int
+		answers      []string
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"valid", 1, []string{valid}, 1, false},
+		{"repaired", 1, []string{"A red car, no JSON", valid}, 2, false},
+		{"repaired twice", 2, []string{`{"summary": ""}`, `{"summary": "A car", "mood": "calm"}`, valid}, 3, false},
+		{"still invalid", 1, []string{"no JSON", "still no JSON"}, 2, true},
+		{"no repairs", 0, []string{"no JSON"}, 1, true},
+	}
+	for _, tt := range tests {
+		provider := &scriptedProvider{answers: tt.answers}
+		p, err := NewProcessor(provider, nil, Options{Structured: true, StructuredRetries: tt.retries})
+		if err != nil {
+			t.Fatal(err)
+		}
+		work := models.WorkItem{FramePath: "frame_0001.jpg", FrameNum: 1}
+		result, attempts, err := p.analyzeImage(context.Background(), "frame_0001.jpg", "video.mp4", work)
+		if attempts != tt.wantAttempts || len(provider.prompts) != tt.wantAttempts {
+			t.Errorf("%s: %d attempts and %d calls, want %d", tt.name, attempts, len(provider.prompts), tt.wantAttempts)
+		}
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "still invalid") {
+				t.Errorf("%s: error %v, want the response still invalid", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if result.Structured == nil || result.Structured.Summary != "A red car." || result.Content != "A red car." {
+			t.Errorf("%s: result %+v, want the parsed analysis", tt.name, result)
+		}
+
+		// Every repair prompt repeats the schema and quotes the answer it repairs
+		for i, prompt := range provider.prompts[1:] {
+			if !strings.Contains(prompt, structuredInstructions) || !strings.Contains(prompt, "Previous answer:\n"+tt.answers[i]) {
+				t.Errorf("%s: repair prompt %d does not quote %q: %q", tt.name, i+1, tt.answers[i], prompt)
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bdougie/vision/internal/models"
+	"github.com/bdougie/vision/internal/storage"
+)
+
+// WorkerOptions controls how a worker takes frames from the shared queue
+type WorkerOptions struct {
+	// Name identifies the worker in the queue, it must be unique per process
+	Name string
+
+	// Concurrency is the number of frames analyzed at the same time
+	Concurrency int
+
+	// Lease is how long a claimed frame stays with this worker without a
+	// heartbeat, heartbeats are sent every third of it
+	Lease time.Duration
+
+	// PollInterval is the wait before looking again when the queue is empty
+	PollInterval time.Duration
+
+	// RetryDelay is the wait before a failed frame is tried again, doubling
+	// with every attempt
+	RetryDelay time.Duration
+
+	// ExitWhenIdle stops the worker once no frame is available
+	ExitWhenIdle bool
+}
+
+// RunWorker analyzes frames claimed from queue until ctx is cancelled, or
+// the queue is empty with ExitWhenIdle. Each frame is analyzed with the
+// prompts, structured mode and retry policy of the processor.
+func (p *Processor) RunWorker(ctx context.Context, queue storage.FrameQueue, opts WorkerOptions) error {
+	if opts.Name == "" || opts.Lease <= 0 {
+		return fmt.Errorf("a worker needs a name and a lease")
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = p.opts.Workers
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				job, err := queue.Claim(ctx, opts.Name, opts.Lease)
+				if err != nil && ctx.Err() == nil {
+					fmt.Printf("Warning: %v\n", err)
+				}
+				if job != nil {
+					p.runFrameJob(ctx, queue, *job, opts)
+					continue
+				}
+				if err == nil && opts.ExitWhenIdle {
+					return
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(opts.PollInterval):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// runFrameJob analyzes one claimed frame while keeping its lease alive and
+// reports the outcome to the queue
+func (p *Processor) runFrameJob(ctx context.Context, queue storage.FrameQueue, job models.FrameJob, opts WorkerOptions) {
+	label := fmt.Sprintf("%s frame %d/%d (attempt %d/%d)", job.VideoName, job.Work.FrameNum, job.Work.Total, job.Attempts, job.MaxAttempts)
+
+	// Providers read images from disk
+	image, err := os.CreateTemp("", "visionanalyzer-*.jpg")
+	if err == nil {
+		defer os.Remove(image.Name())
+		_, err = image.Write(job.Image)
+		if closeErr := image.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Printf("Warning: %s: failed to write frame image: %v\n", label, err)
+		queue.Release(ctx, job, opts.PollInterval)
+		return
+	}
+
+	// Losing the lease means another worker has the frame, stop working on it
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	leaseLost := make(chan struct{})
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		ticker := time.NewTicker(opts.Lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jobCtx.Done():
+				return
+			case <-ticker.C:
+				err := queue.Heartbeat(jobCtx, job, opts.Lease)
+				if errors.Is(err, storage.ErrLeaseLost) {
+					close(leaseLost)
+					cancel()
+					return
+				} else if err != nil && jobCtx.Err() == nil {
+					fmt.Printf("Warning: %s: %v\n", label, err)
+				}
+			}
+		}
+	}()
+
+	result, attempts, err := p.analyzeImage(jobCtx, image.Name(), job.VideoName, job.Work)
+	cancel()
+	<-heartbeatDone
+
+	// Outcomes are reported even while shutting down so frames are not left leased
+	reportCtx := context.WithoutCancel(ctx)
+	status := models.FrameStatus{
+		Frame:       job.Work.FramePath,
+		TimestampMs: job.Work.TimestampMs,
+		Status:      models.FrameOK,
+		Attempts:    attempts,
+		Hash:        job.Work.Hash,
+		Reason:      job.Work.Replaced,
+	}
+	select {
+	case <-leaseLost:
+		fmt.Printf("%s: lease lost, another worker took over\n", label)
+		return
+	default:
+	}
+
+	switch {
+	case err == nil:
+		err = queue.Complete(reportCtx, job, *result, status)
+		if err == nil {
+			fmt.Printf("%s: ok\n", label)
+		}
+	case ctx.Err() != nil:
+		fmt.Printf("%s: stopped, returning it to the queue\n", label)
+		err = queue.Release(reportCtx, job, 0)
+	case errors.Is(err, ErrCircuitOpen):
+		fmt.Printf("%s: %v, returning it to the queue\n", label, err)
+		err = queue.Release(reportCtx, job, p.opts.Retry.BreakerCooldown)
+	case isRetryable(err) && job.Attempts < job.MaxAttempts:
+		delay := opts.RetryDelay << (job.Attempts - 1)
+		fmt.Printf("%s: %v, retrying in %s\n", label, err, delay)
+		err = queue.Retry(reportCtx, job, err.Error(), delay)
+	default:
+		fmt.Printf("%s: failed: %v\n", label, err)
+		status.Status = models.FrameFailed
+		status.Reason = err.Error()
+		err = queue.Fail(reportCtx, job, status)
+	}
+	if errors.Is(err, storage.ErrLeaseLost) {
+		fmt.Printf("%s: lease lost, another worker took over\n", label)
+	} else if err != nil {
+		fmt.Printf("Warning: %s: %v\n", label, err)
+	}
+}
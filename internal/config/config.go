@@ -0,0 +1,571 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/bdougie/vision/internal/analyzer"
+	"github.com/bdougie/vision/internal/embeddings"
+	"github.com/bdougie/vision/internal/extractor"
+	"github.com/bdougie/vision/internal/imagehash"
+	"github.com/bdougie/vision/internal/quality"
+	"github.com/bdougie/vision/internal/storage"
+)
+
+// DefaultPath is the config file read when no path is given and it exists
+const DefaultPath = "visionanalyzer.yaml"
+
+// Storage backends
+const (
+	BackendFile     = "file"
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+)
+
+// Config is the effective configuration of visionanalyzer
+type Config struct {
+	Provider   ProviderConfig   `yaml:"provider"`
+	Embedding  EmbeddingConfig  `yaml:"embedding"`
+	Workers    int              `yaml:"workers"`
+	Structured StructuredConfig `yaml:"structured"`
+	Retry      RetryConfig      `yaml:"retry"`
+	Extraction ExtractionConfig `yaml:"extraction"`
+	Quality    QualityConfig    `yaml:"quality"`
+	Dedup      DedupConfig      `yaml:"dedup"`
+	Storage    StorageConfig    `yaml:"storage"`
+	Server     ServerConfig     `yaml:"server"`
+	Queue      QueueConfig      `yaml:"queue"`
+}
+
+// ProviderConfig configures the vision model backend and prompts
+type ProviderConfig struct {
+	Type         string `yaml:"type"`
+	BaseURL      string `yaml:"base_url"`
+	Model        string `yaml:"model"`
+	APIKey       string `yaml:"api_key"`
+	SystemPrompt string `yaml:"system_prompt"`
+
+	// FramePrompt is a text/template rendered for every frame, see analyzer.PromptData
+	FramePrompt string `yaml:"frame_prompt"`
+}
+
+// EmbeddingConfig configures the text embedding model used for search. An
+// empty base_url or api_key is taken from the provider when both use the same
+// backend type.
+type EmbeddingConfig struct {
+	Type    string `yaml:"type"`
+	BaseURL string `yaml:"base_url"`
+	Model   string `yaml:"model"`
+	APIKey  string `yaml:"api_key"`
+}
+
+// StructuredConfig enables typed JSON frame analyses
+type StructuredConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Retries is how often an unparseable answer is sent back for repair
+	Retries int `yaml:"retries"`
+}
+
+// RetryConfig controls timeouts, retries and the circuit breaker for model calls
+type RetryConfig struct {
+	Attempts         int           `yaml:"attempts"`
+	InitialBackoff   time.Duration `yaml:"initial_backoff"`
+	MaxBackoff       time.Duration `yaml:"max_backoff"`
+	CallTimeout      time.Duration `yaml:"call_timeout"`
+	BreakerThreshold int           `yaml:"breaker_threshold"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`
+}
+
+// ExtractionConfig configures frame sampling
+type ExtractionConfig struct {
+	Mode           string  `yaml:"mode"`
+	Interval       int     `yaml:"interval"`
+	SceneThreshold float64 `yaml:"scene_threshold"`
+	MinGap         float64 `yaml:"min_gap"`
+	MaxGap         float64 `yaml:"max_gap"`
+}
+
+// Quality filter actions
+const (
+	QualityDrop    = "drop"
+	QualityReplace = "replace"
+)
+
+// QualityConfig configures the filtering of black, blurred and blank frames,
+// a zero threshold is not checked
+type QualityConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Action is what happens to a bad frame: drop leaves it out, replace
+	// looks for a good frame within ReplaceWindow and drops it if none is found
+	Action        string        `yaml:"action"`
+	ReplaceWindow time.Duration `yaml:"replace_window"`
+
+	// MinSharpness is the lowest variance of the Laplacian, MinLuminance and
+	// MaxLuminance bound the mean brightness (0-255) and MinEntropy is the
+	// lowest brightness histogram entropy in bits (0-8)
+	MinSharpness float64 `yaml:"min_sharpness"`
+	MinLuminance float64 `yaml:"min_luminance"`
+	MaxLuminance float64 `yaml:"max_luminance"`
+	MinEntropy   float64 `yaml:"min_entropy"`
+}
+
+// DedupConfig configures the skipping of near-duplicate frames by their
+// perceptual hash
+type DedupConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Hash    string `yaml:"hash"`
+
+	// MaxDistance is the Hamming distance up to which frames count as duplicates
+	MaxDistance int `yaml:"max_distance"`
+}
+
+// StorageConfig selects where results are written
+type StorageConfig struct {
+	Backend   string         `yaml:"backend"`
+	OutputDir string         `yaml:"output_dir"`
+	Postgres  PostgresConfig `yaml:"postgres"`
+	SQLite    SQLiteConfig   `yaml:"sqlite"`
+}
+
+// PostgresConfig holds the database connection settings
+type PostgresConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	DBName   string `yaml:"dbname"`
+}
+
+// SQLiteConfig holds the location of the SQLite database file
+type SQLiteConfig struct {
+	Path string `yaml:"path"`
+}
+
+// ServerConfig configures the HTTP API started by the serve command
+type ServerConfig struct {
+	Listen string `yaml:"listen"`
+
+	// Jobs is the number of analysis jobs run at the same time
+	Jobs int `yaml:"jobs"`
+
+	// UploadDir keeps uploaded videos, MaxUploadMB limits their size
+	UploadDir   string `yaml:"upload_dir"`
+	MaxUploadMB int64  `yaml:"max_upload_mb"`
+
+	// AllowedDirs restricts jobs submitted by path to videos inside these
+	// directories; when empty any readable path is accepted
+	AllowedDirs []string `yaml:"allowed_dirs"`
+
+	// JobRetention is how long finished jobs stay listed, of which at most
+	// FinishedJobs are kept
+	JobRetention time.Duration `yaml:"job_retention"`
+	FinishedJobs int           `yaml:"finished_jobs"`
+}
+
+// QueueConfig configures the shared frame queue used by analyze --queue and
+// worker processes
+type QueueConfig struct {
+	// Lease is how long a worker holds a frame without sending a heartbeat
+	Lease time.Duration `yaml:"lease"`
+
+	// MaxAttempts is how often a frame is claimed before it is marked failed
+	MaxAttempts int `yaml:"max_attempts"`
+
+	// RetryDelay is the wait before a failed frame is tried again, doubling per attempt
+	RetryDelay time.Duration `yaml:"retry_delay"`
+
+	// PollInterval is how often idle workers look for new frames
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() *Config {
+	extract := extractor.DefaultOptions()
+	retry := analyzer.DefaultRetryPolicy()
+	return &Config{
+		Provider: ProviderConfig{
+			Type:         analyzer.ProviderOllama,
+			Model:        "llama3.2-vision:11b",
+			SystemPrompt: analyzer.DefaultSystemPrompt,
+			FramePrompt:  analyzer.DefaultFramePrompt,
+		},
+		Embedding: EmbeddingConfig{
+			Type:  embeddings.EmbedderOllama,
+			Model: "nomic-embed-text",
+		},
+		Workers: 4,
+		Structured: StructuredConfig{
+			Retries: 2,
+		},
+		Retry: RetryConfig{
+			Attempts:         retry.MaxAttempts,
+			InitialBackoff:   retry.InitialBackoff,
+			MaxBackoff:       retry.MaxBackoff,
+			CallTimeout:      retry.CallTimeout,
+			BreakerThreshold: retry.BreakerThreshold,
+			BreakerCooldown:  retry.BreakerCooldown,
+		},
+		Extraction: ExtractionConfig{
+			Mode:           string(extract.Mode),
+			Interval:       extract.Interval,
+			SceneThreshold: extract.SceneThreshold,
+			MinGap:         extract.MinGap,
+			MaxGap:         extract.MaxGap,
+		},
+		Quality: QualityConfig{
+			Action:        QualityReplace,
+			ReplaceWindow: analyzer.DefaultReplaceWindow,
+			MinSharpness:  30,
+			MinLuminance:  20,
+			MinEntropy:    0.5,
+		},
+		Dedup: DedupConfig{
+			Hash:        string(imagehash.DHash),
+			MaxDistance: analyzer.DefaultDedupDistance,
+		},
+		Storage: StorageConfig{
+			Backend:   BackendFile,
+			OutputDir: "output_frames",
+			Postgres: PostgresConfig{
+				Host:     "localhost",
+				Port:     "5432",
+				User:     "postgres",
+				Password: "postgres",
+				DBName:   "vision_analysis",
+			},
+			SQLite: SQLiteConfig{
+				Path: "vision.db",
+			},
+		},
+		Server: ServerConfig{
+			Listen:       "127.0.0.1:8090",
+			Jobs:         1,
+			UploadDir:    "uploads",
+			MaxUploadMB:  2048,
+			JobRetention: 24 * time.Hour,
+			FinishedJobs: 100,
+		},
+		Queue: QueueConfig{
+			Lease:        2 * time.Minute,
+			MaxAttempts:  3,
+			RetryDelay:   30 * time.Second,
+			PollInterval: 2 * time.Second,
+		},
+	}
+}
+
+// Load builds the configuration from defaults, the config file at path and
+// environment variables, in that order. An empty path reads DefaultPath if it
+// exists. Flags are applied by the caller afterwards.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	required := path != ""
+	if path == "" {
+		path = DefaultPath
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse config file '%s': %w", path, err)
+		}
+	case os.IsNotExist(err) && !required:
+		// No config file, defaults and environment only
+	default:
+		return nil, fmt.Errorf("failed to read config file '%s': %w", path, err)
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides settings from environment variables
+func (c *Config) applyEnv() error {
+	setString := func(key string, dst *string) {
+		if value, ok := os.LookupEnv(key); ok {
+			*dst = value
+		}
+	}
+
+	setString("VISION_PROVIDER", &c.Provider.Type)
+	setString("VISION_BASE_URL", &c.Provider.BaseURL)
+	setString("VISION_MODEL", &c.Provider.Model)
+	setString("VISION_API_KEY", &c.Provider.APIKey)
+	setString("VISION_SYSTEM_PROMPT", &c.Provider.SystemPrompt)
+	setString("VISION_FRAME_PROMPT", &c.Provider.FramePrompt)
+	setString("VISION_EMBED_PROVIDER", &c.Embedding.Type)
+	setString("VISION_EMBED_BASE_URL", &c.Embedding.BaseURL)
+	setString("VISION_EMBED_MODEL", &c.Embedding.Model)
+	setString("VISION_EMBED_API_KEY", &c.Embedding.APIKey)
+
+	if value, ok := os.LookupEnv("VISION_WORKERS"); ok {
+		workers, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid VISION_WORKERS '%s': %w", value, err)
+		}
+		c.Workers = workers
+	}
+
+	if value, ok := os.LookupEnv("VISION_STRUCTURED"); ok {
+		c.Structured.Enabled = value == "true"
+	}
+
+	if value, ok := os.LookupEnv("VISION_QUALITY"); ok {
+		c.Quality.Enabled = value == "true"
+	}
+
+	if value, ok := os.LookupEnv("VISION_DEDUP"); ok {
+		c.Dedup.Enabled = value == "true"
+	}
+
+	if value, ok := os.LookupEnv("DB_ENABLED"); ok {
+		if value == "true" {
+			c.Storage.Backend = BackendPostgres
+		} else {
+			c.Storage.Backend = BackendFile
+		}
+	}
+	setString("DB_HOST", &c.Storage.Postgres.Host)
+	setString("DB_PORT", &c.Storage.Postgres.Port)
+	setString("DB_USER", &c.Storage.Postgres.User)
+	setString("DB_PASSWORD", &c.Storage.Postgres.Password)
+	setString("DB_NAME", &c.Storage.Postgres.DBName)
+	setString("VISION_SQLITE_PATH", &c.Storage.SQLite.Path)
+	setString("VISION_LISTEN", &c.Server.Listen)
+
+	return nil
+}
+
+// Validate checks the configuration for mistakes before anything runs
+func (c *Config) Validate() error {
+	var problems []string
+
+	switch c.Provider.Type {
+	case analyzer.ProviderOllama, analyzer.ProviderOpenAI:
+	default:
+		problems = append(problems, fmt.Sprintf("provider.type must be '%s' or '%s', got '%s'",
+			analyzer.ProviderOllama, analyzer.ProviderOpenAI, c.Provider.Type))
+	}
+	if c.Provider.Model == "" {
+		problems = append(problems, "provider.model must be set")
+	}
+	switch c.Embedding.Type {
+	case embeddings.EmbedderOllama, embeddings.EmbedderOpenAI:
+	default:
+		problems = append(problems, fmt.Sprintf("embedding.type must be '%s' or '%s', got '%s'",
+			embeddings.EmbedderOllama, embeddings.EmbedderOpenAI, c.Embedding.Type))
+	}
+	if c.Embedding.Model == "" {
+		problems = append(problems, "embedding.model must be set")
+	}
+	if _, err := template.New("frame_prompt").Parse(c.Provider.FramePrompt); err != nil {
+		problems = append(problems, fmt.Sprintf("provider.frame_prompt is not a valid template: %v", err))
+	}
+	if c.Workers <= 0 {
+		problems = append(problems, fmt.Sprintf("workers must be positive, got %d", c.Workers))
+	}
+	if c.Structured.Retries < 0 {
+		problems = append(problems, fmt.Sprintf("structured.retries must not be negative, got %d", c.Structured.Retries))
+	}
+	if c.Retry.Attempts <= 0 {
+		problems = append(problems, fmt.Sprintf("retry.attempts must be positive, got %d", c.Retry.Attempts))
+	}
+	if c.Retry.InitialBackoff < 0 || c.Retry.MaxBackoff < c.Retry.InitialBackoff {
+		problems = append(problems, "retry.initial_backoff must not be negative or above retry.max_backoff")
+	}
+	if c.Retry.CallTimeout < 0 || c.Retry.BreakerCooldown < 0 || c.Retry.BreakerThreshold < 0 {
+		problems = append(problems, "retry.call_timeout, retry.breaker_threshold and retry.breaker_cooldown must not be negative")
+	}
+	if err := c.ExtractOptions().Validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("extraction: %v", err))
+	}
+	if c.Quality.Action != QualityDrop && c.Quality.Action != QualityReplace {
+		problems = append(problems, fmt.Sprintf("quality.action must be '%s' or '%s', got '%s'",
+			QualityDrop, QualityReplace, c.Quality.Action))
+	}
+	if c.Quality.ReplaceWindow <= 0 || c.Quality.MinSharpness < 0 || c.Quality.MinEntropy < 0 ||
+		c.Quality.MinLuminance < 0 || c.Quality.MaxLuminance < 0 {
+		problems = append(problems, "quality.replace_window must be positive and the quality thresholds not negative")
+	}
+	if c.Quality.MaxLuminance > 0 && c.Quality.MaxLuminance <= c.Quality.MinLuminance {
+		problems = append(problems, fmt.Sprintf("quality.max_luminance must be above quality.min_luminance, got %g", c.Quality.MaxLuminance))
+	}
+	switch imagehash.Algorithm(c.Dedup.Hash) {
+	case imagehash.DHash, imagehash.PHash:
+	default:
+		problems = append(problems, fmt.Sprintf("dedup.hash must be '%s' or '%s', got '%s'",
+			imagehash.DHash, imagehash.PHash, c.Dedup.Hash))
+	}
+	if c.Dedup.MaxDistance < 0 || c.Dedup.MaxDistance > 64 {
+		problems = append(problems, fmt.Sprintf("dedup.max_distance must be between 0 and 64, got %d", c.Dedup.MaxDistance))
+	}
+
+	switch c.Storage.Backend {
+	case BackendFile:
+		if c.Storage.OutputDir == "" {
+			problems = append(problems, "storage.output_dir must be set")
+		}
+	case BackendPostgres:
+		if c.Storage.Postgres.Host == "" || c.Storage.Postgres.DBName == "" {
+			problems = append(problems, "storage.postgres.host and storage.postgres.dbname must be set")
+		}
+	case BackendSQLite:
+		if c.Storage.SQLite.Path == "" {
+			problems = append(problems, "storage.sqlite.path must be set")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("storage.backend must be '%s', '%s' or '%s', got '%s'",
+			BackendFile, BackendPostgres, BackendSQLite, c.Storage.Backend))
+	}
+
+	if c.Server.Listen == "" {
+		problems = append(problems, "server.listen must be set")
+	}
+	if c.Server.Jobs <= 0 {
+		problems = append(problems, fmt.Sprintf("server.jobs must be positive, got %d", c.Server.Jobs))
+	}
+	if c.Server.UploadDir == "" || c.Server.MaxUploadMB <= 0 {
+		problems = append(problems, "server.upload_dir must be set and server.max_upload_mb must be positive")
+	}
+	if c.Server.JobRetention <= 0 || c.Server.FinishedJobs <= 0 {
+		problems = append(problems, "server.job_retention and server.finished_jobs must be positive")
+	}
+	if c.Queue.Lease < 3*time.Second || c.Queue.MaxAttempts <= 0 || c.Queue.PollInterval <= 0 || c.Queue.RetryDelay < 0 {
+		problems = append(problems, "queue.lease must be at least 3s, queue.max_attempts and queue.poll_interval positive and queue.retry_delay not negative")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// ExtractOptions converts the extraction settings for the extractor package
+func (c *Config) ExtractOptions() extractor.Options {
+	return extractor.Options{
+		Mode:           extractor.Mode(c.Extraction.Mode),
+		Interval:       c.Extraction.Interval,
+		SceneThreshold: c.Extraction.SceneThreshold,
+		MinGap:         c.Extraction.MinGap,
+		MaxGap:         c.Extraction.MaxGap,
+	}
+}
+
+// VisionProvider converts the provider settings for the analyzer package
+func (c *Config) VisionProvider() analyzer.ProviderConfig {
+	return analyzer.ProviderConfig{
+		Type:         c.Provider.Type,
+		BaseURL:      c.Provider.BaseURL,
+		Model:        c.Provider.Model,
+		APIKey:       c.Provider.APIKey,
+		SystemPrompt: c.Provider.SystemPrompt,
+	}
+}
+
+// Embedder converts the embedding settings for the embeddings package
+func (c *Config) Embedder() embeddings.Config {
+	embedder := embeddings.Config{
+		Type:    c.Embedding.Type,
+		BaseURL: c.Embedding.BaseURL,
+		Model:   c.Embedding.Model,
+		APIKey:  c.Embedding.APIKey,
+	}
+	if embedder.Type == c.Provider.Type {
+		if embedder.BaseURL == "" {
+			embedder.BaseURL = c.Provider.BaseURL
+		}
+		if embedder.APIKey == "" {
+			embedder.APIKey = c.Provider.APIKey
+		}
+	}
+	return embedder
+}
+
+// ProcessorOptions converts the processing settings for the analyzer package
+func (c *Config) ProcessorOptions() analyzer.Options {
+	return analyzer.Options{
+		Extract:           c.ExtractOptions(),
+		Workers:           c.Workers,
+		FramePrompt:       c.Provider.FramePrompt,
+		Structured:        c.Structured.Enabled,
+		StructuredRetries: c.Structured.Retries,
+		QueueAttempts:     c.Queue.MaxAttempts,
+		Quality: analyzer.QualityOptions{
+			Enabled: c.Quality.Enabled,
+			Thresholds: quality.Thresholds{
+				MinSharpness: c.Quality.MinSharpness,
+				MinLuminance: c.Quality.MinLuminance,
+				MaxLuminance: c.Quality.MaxLuminance,
+				MinEntropy:   c.Quality.MinEntropy,
+			},
+			Replace:       c.Quality.Action == QualityReplace,
+			ReplaceWindow: c.Quality.ReplaceWindow,
+		},
+		Dedup: analyzer.DedupOptions{
+			Enabled:     c.Dedup.Enabled,
+			Algorithm:   imagehash.Algorithm(c.Dedup.Hash),
+			MaxDistance: c.Dedup.MaxDistance,
+		},
+		Retry: analyzer.RetryPolicy{
+			MaxAttempts:      c.Retry.Attempts,
+			InitialBackoff:   c.Retry.InitialBackoff,
+			MaxBackoff:       c.Retry.MaxBackoff,
+			CallTimeout:      c.Retry.CallTimeout,
+			BreakerThreshold: c.Retry.BreakerThreshold,
+			BreakerCooldown:  c.Retry.BreakerCooldown,
+		},
+	}
+}
+
+// WorkerOptions converts the queue settings for queue workers
+func (c *Config) WorkerOptions() analyzer.WorkerOptions {
+	return analyzer.WorkerOptions{
+		Concurrency:  c.Workers,
+		Lease:        c.Queue.Lease,
+		PollInterval: c.Queue.PollInterval,
+		RetryDelay:   c.Queue.RetryDelay,
+	}
+}
+
+// Postgres converts the database settings for the storage package
+func (c *Config) Postgres() storage.PostgresConfig {
+	return storage.PostgresConfig{
+		Host:     c.Storage.Postgres.Host,
+		Port:     c.Storage.Postgres.Port,
+		User:     c.Storage.Postgres.User,
+		Password: c.Storage.Postgres.Password,
+		DBName:   c.Storage.Postgres.DBName,
+	}
+}
+
+// Dump renders the configuration as YAML with secrets redacted
+func (c *Config) Dump() ([]byte, error) {
+	redacted := *c
+	if redacted.Provider.APIKey != "" {
+		redacted.Provider.APIKey = "********"
+	}
+	if redacted.Embedding.APIKey != "" {
+		redacted.Embedding.APIKey = "********"
+	}
+	if redacted.Storage.Postgres.Password != "" {
+		redacted.Storage.Postgres.Password = "********"
+	}
+	return yaml.Marshal(&redacted)
+}
@@ -0,0 +1,137 @@
+package embeddings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Embedder types understood by NewEmbedder
+const (
+	EmbedderOllama = "ollama"
+	EmbedderOpenAI = "openai"
+)
+
+// requestTimeout bounds a single embedding request
+const requestTimeout = 2 * time.Minute
+
+// Embedder turns text into vectors with an embedding model
+type Embedder interface {
+	// Embed returns one vector per input text, in input order
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+
+	// Dimension is the length of every vector returned by Embed
+	Dimension() int
+
+	// Model is the name of the embedding model
+	Model() string
+}
+
+// Config selects and configures an embedding backend
+type Config struct {
+	// Type is EmbedderOllama or EmbedderOpenAI (any OpenAI-compatible
+	// /embeddings endpoint)
+	Type    string
+	BaseURL string
+	Model   string
+	APIKey  string
+}
+
+// probedEmbedder is an Embedder whose dimension is set once it is discovered
+type probedEmbedder interface {
+	Embedder
+	setDimension(dimension int)
+}
+
+// NewEmbedder creates the configured embedder and discovers the dimension of
+// its model by embedding a probe text, which also checks the backend is reachable
+func NewEmbedder(ctx context.Context, config Config, logger *logr.Logger) (Embedder, error) {
+	if config.Model == "" {
+		return nil, fmt.Errorf("no model configured for %s embedder", config.Type)
+	}
+
+	httpClient := &http.Client{Timeout: requestTimeout}
+
+	var embedder probedEmbedder
+	switch config.Type {
+	case EmbedderOllama:
+		if config.BaseURL == "" {
+			config.BaseURL = "http://localhost:11434"
+		}
+		config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+		embedder = &ollamaEmbedder{config: config, httpClient: httpClient}
+	case EmbedderOpenAI:
+		if config.BaseURL == "" {
+			config.BaseURL = "http://localhost:8080/v1"
+		}
+		config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+		embedder = &openAIEmbedder{config: config, httpClient: httpClient}
+	default:
+		return nil, fmt.Errorf("unknown embedder '%s'", config.Type)
+	}
+
+	probe, err := embedder.Embed(ctx, []string{"dimension probe"})
+	if err != nil {
+		return nil, fmt.Errorf("%s embedder at %s is not usable: %w", config.Type, config.BaseURL, err)
+	}
+	if len(probe[0]) == 0 {
+		return nil, fmt.Errorf("%s returned an empty embedding", config.Model)
+	}
+	embedder.setDimension(len(probe[0]))
+
+	logger.Info("Using embedder", "type", config.Type, "url", config.BaseURL, "model", config.Model, "dimension", embedder.Dimension())
+	return embedder, nil
+}
+
+// checkVectors verifies a backend answered with one vector per input, each
+// of the expected dimension. A zero dimension accepts any length.
+func checkVectors(model string, vectors [][]float32, inputs, dimension int) error {
+	if len(vectors) != inputs {
+		return fmt.Errorf("%s returned %d embeddings for %d inputs", model, len(vectors), inputs)
+	}
+	for _, vector := range vectors {
+		if dimension != 0 && len(vector) != dimension {
+			return fmt.Errorf("%s returned a %d-dimensional embedding, expected %d", model, len(vector), dimension)
+		}
+	}
+	return nil
+}
+
+// postJSON sends body as JSON to url and decodes the JSON answer
+func postJSON(ctx context.Context, httpClient *http.Client, url, apiKey string, body, answer any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("embedding request failed with status %d: %s", resp.StatusCode, bytes.TrimSpace(text))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(answer); err != nil {
+		return fmt.Errorf("failed to decode embedding response: %w", err)
+	}
+	return nil
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"sync"
-	"time"
 )
 
 // Vector represents a vector embedding
@@ -21,20 +20,23 @@ type Result struct {
 
 // Work represents a unit of embedding work
 type Work struct {
+	Ctx     context.Context
 	Content string
 	Result  chan<- Result
 }
 
 // Service manages embedding generation and caching
 type Service struct {
+	embedder   Embedder
 	numWorkers int
 	workQueue  chan Work
 	cache      sync.Map // Thread-safe map for caching embeddings
 	wg         sync.WaitGroup
 }
 
-// NewService creates a new embedding service with the specified number of workers
-func NewService(numWorkers int) *Service {
+// NewService creates a new embedding service that generates embeddings with
+// embedder using the specified number of workers
+func NewService(embedder Embedder, numWorkers int) *Service {
 	if numWorkers <= 0 {
 		numWorkers = 4 // Default to 4 workers if not specified
 	}
@@ -42,6 +44,7 @@ func NewService(numWorkers int) *Service {
 	workQueue := make(chan Work, 100) // Buffer size for embedding requests
 	
 	service := &Service{
+		embedder:   embedder,
 		numWorkers: numWorkers,
 		workQueue:  workQueue,
 	}
@@ -59,6 +62,15 @@ func (s *Service) startWorkers() {
 		go func() {
 			defer s.wg.Done()
 			for work := range s.workQueue {
+				// Requests cancelled while waiting in the queue are not sent to the model
+				if err := work.Ctx.Err(); err != nil {
+					work.Result <- Result{
+						Content: work.Content,
+						Error:   err,
+					}
+					continue
+				}
+
 				// Check cache first
 				if cachedEmb, ok := s.cache.Load(work.Content); ok {
 					if embedding, validCache := cachedEmb.([]float32); validCache {
@@ -71,7 +83,7 @@ func (s *Service) startWorkers() {
 				}
 
 				// Generate embedding
-				embedding, err := s.generateEmbedding(context.Background(), work.Content)
+				embedding, err := s.generateEmbedding(work.Ctx, work.Content)
 				if err == nil {
 					// Cache the successful result
 					s.cache.Store(work.Content, embedding)
@@ -88,13 +100,15 @@ func (s *Service) startWorkers() {
 	}
 }
 
-// GetEmbedding requests an embedding generation asynchronously
-func (s *Service) GetEmbedding(content string) <-chan Result {
+// GetEmbedding requests an embedding generation asynchronously, cancelling
+// ctx abandons the model call
+func (s *Service) GetEmbedding(ctx context.Context, content string) <-chan Result {
 	resultChan := make(chan Result, 1)
 	
 	// Check if we're already at capacity
 	select {
 	case s.workQueue <- Work{
+		Ctx:     ctx,
 		Content: content,
 		Result:  resultChan,
 	}:
@@ -113,16 +127,16 @@ func (s *Service) GetEmbedding(content string) <-chan Result {
 
 // generateEmbedding creates a vector embedding for the content
 func (s *Service) generateEmbedding(ctx context.Context, content string) ([]float32, error) {
-	// This is a placeholder - in a real application, you would:
-	// 1. Call an embedding API like OpenAI
-	// 2. Process the content to create embeddings
-	
-	// Simulate a computation-heavy task
-	time.Sleep(50 * time.Millisecond)
-	
-	// For now, we'll return a simple dummy embedding
-	// In a real application, this would be a high-dimensional vector
-	return []float32{0.1, 0.2, 0.3, 0.4}, nil
+	vectors, err := s.embedder.Embed(ctx, []string{content})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+// Dimension returns the length of the vectors generated by the service
+func (s *Service) Dimension() int {
+	return s.embedder.Dimension()
 }
 
 // Close shuts down the embedding service and waits for all workers to finish
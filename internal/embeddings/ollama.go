@@ -0,0 +1,40 @@
+package embeddings
+
+import (
+	"context"
+	"net/http"
+)
+
+// ollamaEmbedder calls Ollama's /api/embed endpoint
+type ollamaEmbedder struct {
+	config     Config
+	dimension  int
+	httpClient *http.Client
+}
+
+type ollamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type ollamaEmbedResponse struct {
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// Embed implements Embedder
+func (e *ollamaEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	var response ollamaEmbedResponse
+	request := ollamaEmbedRequest{Model: e.config.Model, Input: texts}
+	if err := postJSON(ctx, e.httpClient, e.config.BaseURL+"/api/embed", "", request, &response); err != nil {
+		return nil, err
+	}
+	return response.Embeddings, checkVectors(e.config.Model, response.Embeddings, len(texts), e.dimension)
+}
+
+// Dimension implements Embedder
+func (e *ollamaEmbedder) Dimension() int { return e.dimension }
+
+// Model implements Embedder
+func (e *ollamaEmbedder) Model() string { return e.config.Model }
+
+func (e *ollamaEmbedder) setDimension(dimension int) { e.dimension = dimension }
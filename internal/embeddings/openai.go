@@ -0,0 +1,53 @@
+package embeddings
+
+import (
+	"context"
+	"net/http"
+	"sort"
+)
+
+// openAIEmbedder calls any OpenAI-compatible /embeddings endpoint
+type openAIEmbedder struct {
+	config     Config
+	dimension  int
+	httpClient *http.Client
+}
+
+type openAIEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type openAIEmbedResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed implements Embedder
+func (e *openAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	var response openAIEmbedResponse
+	request := openAIEmbedRequest{Model: e.config.Model, Input: texts}
+	if err := postJSON(ctx, e.httpClient, e.config.BaseURL+"/embeddings", e.config.APIKey, request, &response); err != nil {
+		return nil, err
+	}
+
+	// Entries carry the index of their input and may come back in any order
+	sort.Slice(response.Data, func(i, j int) bool {
+		return response.Data[i].Index < response.Data[j].Index
+	})
+	vectors := make([][]float32, len(response.Data))
+	for i, item := range response.Data {
+		vectors[i] = item.Embedding
+	}
+	return vectors, checkVectors(e.config.Model, vectors, len(texts), e.dimension)
+}
+
+// Dimension implements Embedder
+func (e *openAIEmbedder) Dimension() int { return e.dimension }
+
+// Model implements Embedder
+func (e *openAIEmbedder) Model() string { return e.config.Model }
+
+func (e *openAIEmbedder) setDimension(dimension int) { e.dimension = dimension }
@@ -0,0 +1,179 @@
+// Package events carries structured progress events from a running analysis
+// to whoever is watching it
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bdougie/vision/internal/models"
+)
+
+// Type names what happened
+type Type string
+
+const (
+	ExtractionStarted  Type = "extraction_started"
+	ExtractionProgress Type = "extraction_progress"
+	ExtractionFinished Type = "extraction_finished"
+	FrameQueued        Type = "frame_queued"
+	FrameAnalyzed      Type = "frame_analyzed"
+	FrameFailed        Type = "frame_failed"
+	FrameDuplicate     Type = "frame_duplicate"
+	FrameRejected      Type = "frame_rejected"
+	FrameReplaced      Type = "frame_replaced"
+	EmbeddingStored    Type = "embedding_stored"
+	JobFinished        Type = "job_finished"
+)
+
+// Event is one step of an analysis. Only the fields that apply to its type are set.
+type Event struct {
+	// Seq numbers the events of a bus from 1, it is assigned by Publish
+	Seq  int64     `json:"seq"`
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+
+	Video       string            `json:"video,omitempty"`
+	Frame       string            `json:"frame,omitempty"`
+	FrameNumber int               `json:"frame_number,omitempty"`
+	TimestampMs int64             `json:"timestamp_ms,omitempty"`
+	Status      models.FrameState `json:"status,omitempty"`
+	Attempts    int               `json:"attempts,omitempty"`
+	Error       string            `json:"error,omitempty"`
+
+	// DuplicateOf is the earlier frame a frame_duplicate looks like
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+
+	// Reason is what is wrong with the frame, set on frame_rejected and
+	// frame_replaced
+	Reason string `json:"reason,omitempty"`
+
+	// Frames is the number of frames extracted, set on extraction_finished
+	Frames int `json:"frames,omitempty"`
+
+	// Extraction is how far ffmpeg has read, set on extraction_progress
+	Extraction *models.ExtractionProgress `json:"extraction,omitempty"`
+
+	// Progress counts the frames of the run so far, set on frame and job events
+	Progress *models.Progress `json:"progress,omitempty"`
+
+	// DurationMs is how long the run took, set on job_finished
+	DurationMs int64 `json:"duration_ms,omitempty"`
+
+	// Cancelled is set on job_finished when the run was stopped before all
+	// frames were analyzed
+	Cancelled bool `json:"cancelled,omitempty"`
+}
+
+// Publisher accepts events, it must be safe for concurrent use
+type Publisher interface {
+	Publish(event Event)
+}
+
+// PublisherFunc adapts a function to Publisher
+type PublisherFunc func(event Event)
+
+// Publish implements Publisher
+func (f PublisherFunc) Publish(event Event) { f(event) }
+
+// subscriberBuffer is how many events a subscriber may fall behind before it is dropped
+const subscriberBuffer = 256
+
+// Bus fans events out to subscribers and keeps the most recent ones so late
+// subscribers can catch up
+type Bus struct {
+	mu      sync.Mutex
+	seq     int64
+	history []Event
+	limit   int
+	subs    map[chan Event]struct{}
+	closed  bool
+}
+
+// NewBus creates a bus remembering up to history events
+func NewBus(history int) *Bus {
+	return &Bus{
+		limit: history,
+		subs:  make(map[chan Event]struct{}),
+	}
+}
+
+// Publish numbers and timestamps an event and delivers it to every
+// subscriber. A subscriber that cannot keep up is dropped, its channel is
+// closed and it may subscribe again from the last event it received.
+func (b *Bus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+
+	b.seq++
+	event.Seq = b.seq
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if b.limit > 0 {
+		if len(b.history) == b.limit {
+			b.history = append(b.history[:0], b.history[1:]...)
+		}
+		b.history = append(b.history, event)
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the remembered events after seq followed by every new
+// event. The channel is closed when the bus closes; cancel stops the
+// subscription early.
+func (b *Bus) Subscribe(after int64) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []Event
+	for _, event := range b.history {
+		if event.Seq > after {
+			replay = append(replay, event)
+		}
+	}
+	ch := make(chan Event, len(replay)+subscriberBuffer)
+	for _, event := range replay {
+		ch <- event
+	}
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	b.subs[ch] = struct{}{}
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// Close ends all subscriptions, later events are discarded
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for ch := range b.subs {
+		close(ch)
+	}
+	b.subs = nil
+}
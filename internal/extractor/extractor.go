@@ -1,69 +1,476 @@
 package extractor
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/bdougie/vision/internal/models"
 )
 
-// ExtractFrames extracts frames from a video file at specified intervals
-func ExtractFrames(videoPath, outputDir string, interval int) error {
-	// Check if video file exists
-	if _, err := os.Stat(videoPath); os.IsNotExist(err) {
-		return fmt.Errorf("video file does not exist at path: '%s'", videoPath)
+// Mode selects how frames are sampled from a video
+type Mode string
+
+const (
+	// ModeInterval samples one frame every Interval seconds
+	ModeInterval Mode = "interval"
+	// ModeScene samples a frame whenever the scene changes
+	ModeScene Mode = "scene"
+)
+
+// Options controls how frames are extracted
+type Options struct {
+	Mode Mode `json:"mode"`
+
+	// Interval is the sampling period in seconds for ModeInterval
+	Interval int `json:"interval,omitempty"`
+
+	// SceneThreshold is the ffmpeg scene score (0-1) above which a frame
+	// counts as a scene change in ModeScene
+	SceneThreshold float64 `json:"scene_threshold,omitempty"`
+
+	// MinGap suppresses scene changes closer than this many seconds to the
+	// previously selected frame
+	MinGap float64 `json:"min_gap,omitempty"`
+
+	// MaxGap forces a frame to be selected if no scene change was detected
+	// for this many seconds. Zero disables it.
+	MaxGap float64 `json:"max_gap,omitempty"`
+
+	// StartMs and EndMs limit extraction to part of the video, zero EndMs
+	// meaning its end. Frames of a part are added to those already extracted.
+	StartMs int64 `json:"start_ms,omitempty"`
+	EndMs   int64 `json:"end_ms,omitempty"`
+}
+
+// DefaultOptions returns the extraction settings used when nothing is configured
+func DefaultOptions() Options {
+	return Options{
+		Mode:           ModeInterval,
+		Interval:       15,
+		SceneThreshold: 0.3,
+		MinGap:         1,
+		MaxGap:         60,
 	}
+}
 
-	// Create base output directory if it doesn't exist
-	if _, err := os.Stat(outputDir); os.IsNotExist(err) {
-		err := os.MkdirAll(outputDir, 0755)
-		if err != nil {
-			return fmt.Errorf("failed to create output directory '%s': %v", outputDir, err)
+// Validate checks that the options are usable
+func (o Options) Validate() error {
+	if o.StartMs < 0 || o.EndMs < 0 {
+		return fmt.Errorf("start and end must not be negative")
+	}
+	if o.EndMs > 0 && o.EndMs <= o.StartMs {
+		return fmt.Errorf("end (%dms) must be after start (%dms)", o.EndMs, o.StartMs)
+	}
+	switch o.Mode {
+	case ModeInterval:
+		if o.Interval <= 0 {
+			return fmt.Errorf("interval must be positive, got %d", o.Interval)
+		}
+	case ModeScene:
+		if o.SceneThreshold <= 0 || o.SceneThreshold >= 1 {
+			return fmt.Errorf("scene threshold must be between 0 and 1, got %g", o.SceneThreshold)
 		}
+		if o.MinGap < 0 || o.MaxGap < 0 {
+			return fmt.Errorf("scene gaps must not be negative")
+		}
+		if o.MaxGap > 0 && o.MaxGap < o.MinGap {
+			return fmt.Errorf("max gap (%gs) must not be smaller than min gap (%gs)", o.MaxGap, o.MinGap)
+		}
+	default:
+		return fmt.Errorf("unknown extraction mode '%s'", o.Mode)
 	}
+	return nil
+}
+
+// normalized clears the settings that do not apply to the selected mode, so
+// options can be compared against the ones recorded in a manifest
+func (o Options) normalized() Options {
+	switch o.Mode {
+	case ModeInterval:
+		return Options{Mode: o.Mode, Interval: o.Interval, StartMs: o.StartMs, EndMs: o.EndMs}
+	case ModeScene:
+		o.Interval = 0
+	}
+	return o
+}
+
+// Partial reports whether the options cover only part of the video
+func (o Options) Partial() bool {
+	return o.StartMs > 0 || o.EndMs > 0
+}
 
-	// Create a subfolder with the video's name
-	videoName := strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath))
-	frameDirPath := filepath.Join(outputDir, videoName)
+// wholeVideo returns the options without their time range
+func (o Options) wholeVideo() Options {
+	o.StartMs, o.EndMs = 0, 0
+	return o
+}
+
+// Frame describes a single extracted frame
+type Frame struct {
+	File        string `json:"file"`
+	TimestampMs int64  `json:"pts_ms"`
+
+	// Replaced says why the frame first extracted here was swapped for a
+	// frame nearby, see ReplaceFrame
+	Replaced string `json:"replaced,omitempty"`
+}
+
+var (
+	// showinfoPTS matches the presentation time printed by ffmpeg's showinfo filter
+	showinfoPTS = regexp.MustCompile(`\] n:\s*\d+ .*?pts_time:(-?[0-9.]+)`)
+
+	// videoStream matches the resolution and frame rate of the input video stream
+	videoStream = regexp.MustCompile(`Stream #0:\d+.*?: Video: .*?, (\d{2,5})x(\d{2,5}).*?, ([0-9.]+) fps`)
+)
+
+// ExtractFrames extracts frames from a video file into frameDirPath using the
+// given options, writes a manifest next to them and returns it. Frames of a
+// time range are added to those extracted before and the returned manifest
+// holds only the frames within the range. onProgress, if set, follows ffmpeg
+// through the video. Cancelling ctx stops ffmpeg and nothing is added.
+func ExtractFrames(ctx context.Context, videoPath, frameDirPath string, opts Options, onProgress ProgressFunc) (*Manifest, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	opts = opts.normalized()
 
-	// Check if frames already exist in the subfolder
-	if files, err := os.ReadDir(frameDirPath); err == nil && len(files) > 0 {
-		// Count the number of jpg files
-		frameCount := 0
-		for _, file := range files {
-			if !file.IsDir() && strings.HasSuffix(strings.ToLower(file.Name()), ".jpg") {
-				frameCount++
+	manifest, err := openManifest(videoPath, frameDirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reuse frames from a previous run with the same options
+	if opts.Partial() {
+		if manifest.Options == opts.wholeVideo() || slices.Contains(manifest.Ranges, opts) {
+			selected := manifest.Between(opts.StartMs, opts.EndMs)
+			fmt.Printf("Frames of this range already exist in %s. Skipping extraction. Found %d frames.\n", frameDirPath, len(selected.Frames))
+			return selected, nil
+		}
+	} else if manifest.Options == opts {
+		fmt.Printf("Frames already exist in %s. Skipping extraction. Found %d frames.\n", frameDirPath, len(manifest.Frames))
+		return manifest, nil
+	} else if manifest.Options.Mode != "" {
+		fmt.Printf("Extraction options changed since the last run, extracting frames again\n")
+		if manifest, err = resetFrames(videoPath, frameDirPath); err != nil {
+			return nil, err
+		}
+	}
+
+	var filter string
+	switch opts.Mode {
+	case ModeScene:
+		fmt.Printf("Extracting frames from '%s' to '%s' on scene changes (threshold %g)...\n", videoPath, frameDirPath, opts.SceneThreshold)
+		filter = sceneFilter(opts)
+	default:
+		fmt.Printf("Extracting frames from '%s' to '%s' at %d second intervals...\n", videoPath, frameDirPath, opts.Interval)
+		filter = fmt.Sprintf("fps=1/%d", opts.Interval)
+	}
+
+	// Frames are extracted next to the manifest and merged once ffmpeg is done
+	dir, err := os.MkdirTemp(frameDirPath, ".extract-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create frame directory in '%s': %v", frameDirPath, err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Seeking the input makes ffmpeg count time from the start of the range
+	var args []string
+	if opts.StartMs > 0 {
+		fmt.Printf("Starting at %s\n", time.Duration(opts.StartMs)*time.Millisecond)
+		args = append(args, "-ss", formatSeconds(opts.StartMs))
+	}
+	args = append(args, "-i", videoPath)
+	if opts.EndMs > 0 {
+		fmt.Printf("Stopping at %s\n", time.Duration(opts.EndMs)*time.Millisecond)
+		args = append(args, "-t", formatSeconds(opts.EndMs-opts.StartMs))
+	}
+
+	// Extract frames using ffmpeg, showinfo reports the pts of every output frame
+	args = append(args,
+		"-vf", filter+",showinfo",
+		"-fps_mode", "vfr",
+		fmt.Sprintf("%s/frame_%%04d.jpg", dir),
+	)
+	output, err := runFFmpeg(ctx, args, opts.StartMs, opts.EndMs-opts.StartMs, onProgress)
+	if err != nil {
+		return nil, err
+	}
+
+	frames, err := output.frames(dir, opts.StartMs)
+	if err != nil {
+		return nil, err
+	}
+	manifest.setStreamInfo(output)
+	frames, err = manifest.merge(frameDirPath, dir, frames)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Partial() {
+		manifest.Ranges = append(manifest.Ranges, opts)
+	} else {
+		manifest.Options = opts
+	}
+	if err := manifest.Save(frameDirPath); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Successfully extracted %d frames to %s\n", len(frames), frameDirPath)
+	if opts.Partial() {
+		return manifest.Between(opts.StartMs, opts.EndMs), nil
+	}
+	return manifest, nil
+}
+
+// ExtractFramesAt grabs the frame shown at each of the timestamps, given in
+// milliseconds, and adds them to the frames extracted before. The returned
+// manifest holds the frame of every timestamp in the order given. Frames
+// already extracted within one source frame after a timestamp are reused.
+func ExtractFramesAt(ctx context.Context, videoPath, frameDirPath string, timestamps []int64, onProgress ProgressFunc) (*Manifest, error) {
+	for _, ms := range timestamps {
+		if ms < 0 {
+			return nil, fmt.Errorf("timestamps must not be negative, got %dms", ms)
+		}
+	}
+
+	manifest, err := openManifest(videoPath, frameDirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []int64
+	for _, ms := range timestamps {
+		if _, ok := manifest.frameAt(ms); !ok && !slices.Contains(missing, ms) {
+			missing = append(missing, ms)
+		}
+	}
+
+	if len(missing) > 0 {
+		fmt.Printf("Grabbing %d frames from '%s' to '%s'...\n", len(missing), videoPath, frameDirPath)
+		dir, err := os.MkdirTemp(frameDirPath, ".extract-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create frame directory in '%s': %v", frameDirPath, err)
+		}
+		defer os.RemoveAll(dir)
+
+		var frames []Frame
+		for i, ms := range missing {
+			frame, output, err := grabFrame(ctx, videoPath, dir, ms, i+1)
+			if err != nil {
+				return nil, err
+			}
+			frames = append(frames, frame)
+			manifest.setStreamInfo(output)
+
+			if onProgress != nil {
+				onProgress(models.ExtractionProgress{
+					OutTimeMs: ms,
+					Percent:   float64(i+1) * 100 / float64(len(missing)),
+					Frames:    i + 1,
+				})
 			}
 		}
 
-		if frameCount > 0 {
-			fmt.Printf("Frames already exist in %s. Skipping extraction. Found %d frames.\n", frameDirPath, frameCount)
-			return nil
+		if _, err := manifest.merge(frameDirPath, dir, frames); err != nil {
+			return nil, err
+		}
+		if err := manifest.Save(frameDirPath); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Successfully grabbed %d frames to %s\n", len(frames), frameDirPath)
+	} else {
+		fmt.Printf("Frames at all %d timestamps already exist in %s. Skipping extraction.\n", len(timestamps), frameDirPath)
+	}
+
+	selected := *manifest
+	selected.Frames = nil
+	for _, ms := range timestamps {
+		frame, ok := manifest.frameAt(ms)
+		if !ok {
+			return nil, fmt.Errorf("no frame at %s after extraction", time.Duration(ms)*time.Millisecond)
+		}
+		if !slices.Contains(selected.Frames, frame) {
+			selected.Frames = append(selected.Frames, frame)
+		}
+	}
+	return &selected, nil
+}
+
+// GrabFrame writes the frame shown at ms to path and returns its timestamp.
+// The manifest is left alone, ReplaceFrame puts the frame in place of
+// another one.
+func GrabFrame(ctx context.Context, videoPath, path string, ms int64) (int64, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".grab-")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create frame directory in '%s': %v", filepath.Dir(path), err)
+	}
+	defer os.RemoveAll(dir)
+
+	frame, _, err := grabFrame(ctx, videoPath, dir, ms, 1)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Rename(filepath.Join(dir, frame.File), path); err != nil {
+		return 0, fmt.Errorf("failed to move grabbed frame: %w", err)
+	}
+	return frame.TimestampMs, nil
+}
+
+// grabFrame writes the frame shown at ms into dir as frame number number
+func grabFrame(ctx context.Context, videoPath, dir string, ms int64, number int) (Frame, *ffmpegLog, error) {
+	output, err := runFFmpeg(ctx, []string{
+		"-ss", formatSeconds(ms),
+		"-i", videoPath,
+		"-vf", "showinfo",
+		"-frames:v", "1",
+		"-start_number", strconv.Itoa(number),
+		fmt.Sprintf("%s/frame_%%04d.jpg", dir),
+	}, ms, 0, nil)
+	if err != nil {
+		return Frame{}, nil, err
+	}
+
+	// The pts counts from the seek position
+	name := fmt.Sprintf("frame_%04d.jpg", number)
+	if _, err := os.Stat(filepath.Join(dir, name)); err != nil || len(output.timestamps) == 0 {
+		return Frame{}, nil, fmt.Errorf("no frame at %s, it may be past the end of the video", time.Duration(ms)*time.Millisecond)
+	}
+	return Frame{File: name, TimestampMs: ms + output.timestamps[0]}, output, nil
+}
+
+// ReplaceFrame moves the image at path over the frame file of the manifest
+// in frameDirPath and records its timestamp and the reason for the swap, so
+// later runs take the replacement for the frame. The replacement must not
+// be shown before the frame preceding file or after the one following it.
+func ReplaceFrame(frameDirPath, file, path string, timestampMs int64, reason string) error {
+	manifest, err := LoadManifest(frameDirPath)
+	if err != nil {
+		return fmt.Errorf("failed to load frame manifest: %w", err)
+	}
+	index := slices.IndexFunc(manifest.Frames, func(frame Frame) bool { return frame.File == file })
+	if index < 0 {
+		return fmt.Errorf("frame '%s' is not in the manifest", file)
+	}
+	before, after := manifest.Neighbours(file)
+	if (before >= 0 && timestampMs <= before) || (after >= 0 && timestampMs >= after) {
+		return fmt.Errorf("replacement for '%s' at %s is not between its neighbours", file, time.Duration(timestampMs)*time.Millisecond)
+	}
+
+	if err := os.Rename(path, filepath.Join(frameDirPath, file)); err != nil {
+		return fmt.Errorf("failed to replace frame '%s': %w", file, err)
+	}
+	manifest.Frames[index].TimestampMs = timestampMs
+	manifest.Frames[index].Replaced = reason
+	return manifest.Save(frameDirPath)
+}
+
+// openManifest loads the manifest of a frame directory, or starts an empty
+// one for the video after removing frames without a manifest
+func openManifest(videoPath, frameDirPath string) (*Manifest, error) {
+	// Check if video file exists
+	if _, err := os.Stat(videoPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("video file does not exist at path: '%s'", videoPath)
+	}
+
+	if manifest, err := LoadManifest(frameDirPath); err == nil {
+		return manifest, nil
+	}
+	return resetFrames(videoPath, frameDirPath)
+}
+
+// resetFrames removes the frames of a directory and returns an empty manifest
+func resetFrames(videoPath, frameDirPath string) (*Manifest, error) {
+	// Frames without a matching manifest have unknown timestamps, remove them
+	if existing, err := listFrames(frameDirPath); err == nil {
+		for _, name := range existing {
+			if err := os.Remove(filepath.Join(frameDirPath, name)); err != nil {
+				return nil, fmt.Errorf("failed to remove stale frame '%s': %v", name, err)
+			}
 		}
 	}
 
 	// Create the frame directory
 	if err := os.MkdirAll(frameDirPath, 0755); err != nil {
-		return fmt.Errorf("failed to create frame directory '%s': %v", frameDirPath, err)
+		return nil, fmt.Errorf("failed to create frame directory '%s': %v", frameDirPath, err)
 	}
 
-	fmt.Printf("Extracting frames from '%s' to '%s' at %d second intervals...\n", videoPath, frameDirPath, interval)
+	return &Manifest{
+		Video:     filepath.Base(videoPath),
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
 
-	// Extract frames using ffmpeg
-	ffmpegCommand := exec.Command(
-		"ffmpeg",
-		"-i", videoPath,
-		"-vf", fmt.Sprintf("fps=1/%d", interval),
-		fmt.Sprintf("%s/frame_%%04d.jpg", frameDirPath),
-	)
+// frameAt returns the frame shown at ms, a frame extracted at most one
+// source frame later
+func (m *Manifest) frameAt(ms int64) (Frame, bool) {
+	window := int64(100)
+	if m.SourceFPS > 0 {
+		window = int64(1000/m.SourceFPS + 0.5)
+	}
+	for _, frame := range m.Frames {
+		if frame.TimestampMs >= ms && frame.TimestampMs <= ms+window {
+			return frame, true
+		}
+	}
+	return Frame{}, false
+}
 
-	// Capture output for better error reporting
-	output, err := ffmpegCommand.CombinedOutput()
+// setStreamInfo records the video stream ffmpeg reported if the manifest has none yet
+func (m *Manifest) setStreamInfo(output *ffmpegLog) {
+	if m.Width == 0 && output.width > 0 {
+		m.Width, m.Height, m.SourceFPS = output.width, output.height, output.fps
+	}
+}
+
+// formatSeconds renders milliseconds as seconds for ffmpeg's time options
+func formatSeconds(ms int64) string {
+	return strconv.FormatFloat(float64(ms)/1000, 'f', 3, 64)
+}
+
+// sceneFilter builds the ffmpeg select expression for scene change sampling.
+// The first frame is always kept, later frames need a scene score above the
+// threshold and at least MinGap seconds since the last pick, or MaxGap
+// seconds without any pick.
+func sceneFilter(opts Options) string {
+	expr := fmt.Sprintf("isnan(prev_selected_t)+gt(scene,%g)*gte(t-prev_selected_t,%g)", opts.SceneThreshold, opts.MinGap)
+	if opts.MaxGap > 0 {
+		expr += fmt.Sprintf("+gte(t-prev_selected_t,%g)", opts.MaxGap)
+	}
+	return fmt.Sprintf("select='%s'", expr)
+}
+
+// parseStreamInfo extracts the resolution and frame rate of a video stream
+func parseStreamInfo(output string) (width, height int, fps float64) {
+	match := videoStream.FindStringSubmatch(output)
+	if match == nil {
+		return 0, 0, 0
+	}
+	width, _ = strconv.Atoi(match[1])
+	height, _ = strconv.Atoi(match[2])
+	fps, _ = strconv.ParseFloat(match[3], 64)
+	return width, height, fps
+}
+
+// listFrames returns the sorted JPEG frame file names in a directory
+func listFrames(frameDirPath string) ([]string, error) {
+	files, err := os.ReadDir(frameDirPath)
 	if err != nil {
-		return fmt.Errorf("ffmpeg failed: %v\nOutput: %s", err, string(output))
+		return nil, err
 	}
 
-	fmt.Printf("Successfully extracted frames to %s\n", frameDirPath)
-	return nil
+	var frames []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(strings.ToLower(file.Name()), ".jpg") {
+			frames = append(frames, file.Name())
+		}
+	}
+	// ReadDir returns entries sorted by name, which matches frame order
+	return frames, nil
 }
@@ -0,0 +1,208 @@
+package extractor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bdougie/vision/internal/models"
+)
+
+// ProgressFunc receives the progress of a running extraction, it is called
+// from the goroutine reading ffmpeg's output
+type ProgressFunc func(progress models.ExtractionProgress)
+
+// stderrTailLines is how many of the last lines ffmpeg logged are kept for
+// error messages
+const stderrTailLines = 40
+
+// inputDuration matches the duration ffmpeg prints for its input
+var inputDuration = regexp.MustCompile(`^\s*Duration: (\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+
+// ffmpegLog is what ExtractFrames needs from the log of an ffmpeg run
+type ffmpegLog struct {
+	// timestamps is the pts of every frame written, in milliseconds
+	timestamps []int64
+
+	width, height int
+	fps           float64
+
+	// durationMs is the length of the input, read while progress is reported
+	durationMs atomic.Int64
+
+	// tail holds the last lines logged
+	tail *ringBuffer
+}
+
+// parseLine picks frame timestamps, stream information and the input
+// duration out of a line ffmpeg logged
+func (l *ffmpegLog) parseLine(line string) {
+	l.tail.add(line)
+	if match := showinfoPTS.FindStringSubmatch(line); match != nil {
+		if seconds, err := strconv.ParseFloat(match[1], 64); err == nil {
+			l.timestamps = append(l.timestamps, int64(seconds*1000+0.5))
+		}
+		return
+	}
+	if l.width == 0 {
+		if width, height, fps := parseStreamInfo(line); width > 0 {
+			l.width, l.height, l.fps = width, height, fps
+			return
+		}
+	}
+	if match := inputDuration.FindStringSubmatch(line); match != nil && l.durationMs.Load() == 0 {
+		hours, _ := strconv.Atoi(match[1])
+		minutes, _ := strconv.Atoi(match[2])
+		seconds, _ := strconv.ParseFloat(match[3], 64)
+		l.durationMs.Store(int64(hours)*3600000 + int64(minutes)*60000 + int64(seconds*1000+0.5))
+	}
+}
+
+// frames pairs the frame files ffmpeg wrote into dir with their timestamps,
+// offsetMs is the position in the video ffmpeg started from
+func (l *ffmpegLog) frames(dir string, offsetMs int64) ([]Frame, error) {
+	files, err := listFrames(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read frames directory '%s': %v", dir, err)
+	}
+	if len(l.timestamps) != len(files) {
+		return nil, fmt.Errorf("ffmpeg reported %d frame timestamps but wrote %d frames", len(l.timestamps), len(files))
+	}
+
+	frames := make([]Frame, len(files))
+	for i, name := range files {
+		frames[i] = Frame{File: name, TimestampMs: offsetMs + l.timestamps[i]}
+	}
+	return frames, nil
+}
+
+// runFFmpeg runs ffmpeg with args, reporting its progress to onProgress if
+// it is set, and returns its log. offsetMs is where in the video ffmpeg
+// starts reading and spanMs how much of it is read, zero meaning up to the
+// end, so progress is reported in true video times. The error of a failed
+// run ends with the last lines ffmpeg logged.
+func runFFmpeg(ctx context.Context, args []string, offsetMs, spanMs int64, onProgress ProgressFunc) (*ffmpegLog, error) {
+	// Progress goes to stdout as key=value blocks, -nostats keeps the
+	// interactive status line out of the log
+	args = append([]string{"-nostats", "-progress", "pipe:1"}, args...)
+	ffmpegCommand := exec.CommandContext(ctx, "ffmpeg", args...)
+	stdout, err := ffmpegCommand.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %v", err)
+	}
+	stderr, err := ffmpegCommand.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %v", err)
+	}
+	if err := ffmpegCommand.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %v", err)
+	}
+
+	output := &ffmpegLog{tail: newRingBuffer(stderrTailLines)}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		readProgress(stdout, &output.durationMs, offsetMs, spanMs, onProgress)
+	}()
+	go func() {
+		defer wg.Done()
+		scanner := bufio.NewScanner(stderr)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			output.parseLine(scanner.Text())
+		}
+		// Keep the pipe drained so ffmpeg does not block on an overlong line
+		io.Copy(io.Discard, stderr)
+	}()
+
+	// The pipes must be read to the end before waiting
+	wg.Wait()
+	if err := ffmpegCommand.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("frame extraction cancelled: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("ffmpeg failed: %v\nOutput: %s", err, output.tail)
+	}
+	return output, nil
+}
+
+// readProgress parses the blocks ffmpeg writes with -progress. Every block
+// ends with a progress=continue or progress=end line.
+func readProgress(r io.Reader, durationMs *atomic.Int64, offsetMs, spanMs int64, onProgress ProgressFunc) {
+	var progress models.ExtractionProgress
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "frame":
+			if frames, err := strconv.Atoi(value); err == nil {
+				progress.Frames = frames
+			}
+		case "out_time_us", "out_time_ms":
+			// Both are microseconds, out_time_ms is misnamed in older versions
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				progress.OutTimeMs = offsetMs + us/1000
+			}
+		case "speed":
+			if speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64); err == nil {
+				progress.Speed = speed
+			}
+		case "progress":
+			progress.DurationMs = durationMs.Load()
+			span := spanMs
+			if span <= 0 {
+				span = progress.DurationMs - offsetMs
+			}
+			progress.Percent = 0
+			if span > 0 && progress.DurationMs > 0 {
+				progress.Percent = min(100, max(0, float64(progress.OutTimeMs-offsetMs)*100/float64(span)))
+				if value == "end" {
+					progress.Percent = 100
+				}
+			}
+			if onProgress != nil {
+				onProgress(progress)
+			}
+		}
+	}
+	io.Copy(io.Discard, r)
+}
+
+// ringBuffer keeps the last lines added to it
+type ringBuffer struct {
+	lines []string
+	next  int
+	full  bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{lines: make([]string, size)}
+}
+
+func (b *ringBuffer) add(line string) {
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// String returns the kept lines, oldest first
+func (b *ringBuffer) String() string {
+	if !b.full {
+		return strings.Join(b.lines[:b.next], "\n")
+	}
+	return strings.Join(append(append([]string{}, b.lines[b.next:]...), b.lines[:b.next]...), "\n")
+}
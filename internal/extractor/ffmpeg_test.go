@@ -0,0 +1,125 @@
+package extractor
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bdougie/vision/internal/models"
+)
+
+func TestReadProgress(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		durationMs       int64
+		offsetMs, spanMs int64
+		want             []models.ExtractionProgress // one per block
+	}{
+		{
+			name:       "block",
+			input:      "frame=12\nfps=24.0\nout_time_us=5000000\nout_time=00:00:05.000000\nspeed=2.5x\nprogress=continue\n",
+			durationMs: 10000,
+			want:       []models.ExtractionProgress{{OutTimeMs: 5000, DurationMs: 10000, Percent: 50, Speed: 2.5, Frames: 12}},
+		},
+		{
+			// Later blocks keep the values earlier ones set, the last one is complete
+			name:       "blocks",
+			input:      "frame=1\nout_time_us=1000000\nprogress=continue\nframe=4\nout_time_us=9500000\nspeed=3x\nprogress=end\n",
+			durationMs: 10000,
+			want: []models.ExtractionProgress{
+				{OutTimeMs: 1000, DurationMs: 10000, Percent: 10, Frames: 1},
+				{OutTimeMs: 9500, DurationMs: 10000, Percent: 100, Speed: 3, Frames: 4},
+			},
+		},
+		{
+			// out_time_ms is microseconds too in older ffmpeg versions
+			name:       "out_time_ms",
+			input:      "out_time_ms=2500000\nprogress=continue\n",
+			durationMs: 10000,
+			want:       []models.ExtractionProgress{{OutTimeMs: 2500, DurationMs: 10000, Percent: 25}},
+		},
+		{
+			// A range extraction reports positions in the video and percent of the span
+			name:       "offset and span",
+			input:      "out_time_us=5000000\nprogress=continue\n",
+			durationMs: 120000, offsetMs: 60000, spanMs: 20000,
+			want: []models.ExtractionProgress{{OutTimeMs: 65000, DurationMs: 120000, Percent: 25}},
+		},
+		{
+			name:       "offset to the end",
+			input:      "out_time_us=30000000\nprogress=continue\n",
+			durationMs: 120000, offsetMs: 60000,
+			want: []models.ExtractionProgress{{OutTimeMs: 90000, DurationMs: 120000, Percent: 50}},
+		},
+		{
+			name:  "unknown duration",
+			input: "out_time_us=5000000\nprogress=continue\n",
+			want:  []models.ExtractionProgress{{OutTimeMs: 5000}},
+		},
+		{
+			name:       "past the duration",
+			input:      "out_time_us=12000000\nprogress=continue\n",
+			durationMs: 10000,
+			want:       []models.ExtractionProgress{{OutTimeMs: 12000, DurationMs: 10000, Percent: 100}},
+		},
+		{
+			// ffmpeg starts with N/A and negative times, stderr noise has no key
+			name: "unparsable values",
+			input: "frame=abc\nout_time_us=N/A\nout_time_us=-577014\nspeed=N/A\nbitrate=N/A\n" +
+				"[mjpeg @ 0x55d] deprecated pixel format\n\nprogress=continue\n",
+			durationMs: 10000,
+			want:       []models.ExtractionProgress{{DurationMs: 10000}},
+		},
+		{
+			name:       "no block end",
+			input:      "frame=3\nout_time_us=1000000\n",
+			durationMs: 10000,
+		},
+	}
+	for _, tt := range tests {
+		var duration atomic.Int64
+		duration.Store(tt.durationMs)
+		var got []models.ExtractionProgress
+		readProgress(strings.NewReader(tt.input), &duration, tt.offsetMs, tt.spanMs, func(progress models.ExtractionProgress) {
+			got = append(got, progress)
+		})
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: progress %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Without a callback the blocks are read and dropped
+func TestReadProgressNoCallback(t *testing.T) {
+	var duration atomic.Int64
+	readProgress(strings.NewReader("out_time_us=1000000\nprogress=end\n"), &duration, 0, 0, nil)
+}
+
+func TestRingBuffer(t *testing.T) {
+	tests := []struct {
+		size, lines int
+		want        string
+	}{
+		{3, 0, ""},
+		{3, 2, "line 1\nline 2"},
+		{3, 3, "line 1\nline 2\nline 3"},
+		// Older lines are overwritten, the rest stay oldest first
+		{3, 4, "line 2\nline 3\nline 4"},
+		{3, 5, "line 3\nline 4\nline 5"},
+		{3, 6, "line 4\nline 5\nline 6"},
+		{3, 7, "line 5\nline 6\nline 7"},
+		{1, 3, "line 3"},
+	}
+	for _, tt := range tests {
+		b := newRingBuffer(tt.size)
+		for i := range tt.lines {
+			b.add(fmt.Sprintf("line %d", i+1))
+		}
+		if got := b.String(); got != tt.want {
+			t.Errorf("%d lines in a buffer of %d: %q, want %q", tt.lines, tt.size, got, tt.want)
+		}
+	}
+}
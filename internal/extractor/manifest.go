@@ -0,0 +1,154 @@
+package extractor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ManifestFileName is the name of the manifest written next to extracted frames
+const ManifestFileName = "frames.json"
+
+// Manifest records how frames were extracted and where each one sits in the video
+type Manifest struct {
+	Video     string  `json:"video"`
+	SourceFPS float64 `json:"source_fps"`
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+
+	// Options are those of the last extraction of the whole video, the mode
+	// is empty when only parts of it were extracted
+	Options Options `json:"options"`
+
+	// Ranges are the options of the time ranges extracted in addition
+	Ranges []Options `json:"ranges,omitempty"`
+
+	// Frames are ordered by timestamp
+	Frames    []Frame   `json:"frames"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Timestamp returns the timestamp of a frame file, or false if it is not in the manifest
+func (m *Manifest) Timestamp(file string) (int64, bool) {
+	for _, frame := range m.Frames {
+		if frame.File == file {
+			return frame.TimestampMs, true
+		}
+	}
+	return 0, false
+}
+
+// Neighbours returns the timestamps of the frames before and after file,
+// -1 where there is none
+func (m *Manifest) Neighbours(file string) (before, after int64) {
+	before, after = -1, -1
+	for i, frame := range m.Frames {
+		if frame.File != file {
+			continue
+		}
+		if i > 0 {
+			before = m.Frames[i-1].TimestampMs
+		}
+		if i+1 < len(m.Frames) {
+			after = m.Frames[i+1].TimestampMs
+		}
+		break
+	}
+	return before, after
+}
+
+// Between returns a copy of the manifest holding only the frames from startMs
+// up to endMs, zero endMs meaning the end of the video
+func (m *Manifest) Between(startMs, endMs int64) *Manifest {
+	selected := *m
+	selected.Frames = nil
+	for _, frame := range m.Frames {
+		if frame.TimestampMs >= startMs && (endMs == 0 || frame.TimestampMs < endMs) {
+			selected.Frames = append(selected.Frames, frame)
+		}
+	}
+	return &selected
+}
+
+// merge moves frames extracted into dir next to the frames already in the
+// manifest, numbering them after the highest existing frame, and returns
+// the manifest frames now standing for them. A frame at the timestamp of an
+// existing one is dropped in favor of it.
+func (m *Manifest) merge(frameDirPath, dir string, frames []Frame) ([]Frame, error) {
+	existing := make(map[int64]Frame, len(m.Frames))
+	next := 1
+	for _, frame := range m.Frames {
+		existing[frame.TimestampMs] = frame
+		if number := frameFileNumber(frame.File); number >= next {
+			next = number + 1
+		}
+	}
+
+	merged := make([]Frame, 0, len(frames))
+	for _, frame := range frames {
+		if known, ok := existing[frame.TimestampMs]; ok {
+			merged = append(merged, known)
+			continue
+		}
+		name := fmt.Sprintf("frame_%04d.jpg", next)
+		next++
+		if err := os.Rename(filepath.Join(dir, frame.File), filepath.Join(frameDirPath, name)); err != nil {
+			return nil, fmt.Errorf("failed to move extracted frame: %w", err)
+		}
+		added := Frame{File: name, TimestampMs: frame.TimestampMs}
+		existing[added.TimestampMs] = added
+		m.Frames = append(m.Frames, added)
+		merged = append(merged, added)
+	}
+	sort.SliceStable(m.Frames, func(i, j int) bool { return m.Frames[i].TimestampMs < m.Frames[j].TimestampMs })
+	return merged, nil
+}
+
+// frameFileNumber returns the number in a frame file name like frame_0042.jpg
+func frameFileNumber(name string) int {
+	digits := strings.TrimSuffix(strings.TrimPrefix(name, "frame_"), filepath.Ext(name))
+	number, _ := strconv.Atoi(digits)
+	return number
+}
+
+// Save writes the manifest into the frame directory
+func (m *Manifest) Save(frameDirPath string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal frame manifest: %w", err)
+	}
+
+	path := filepath.Join(frameDirPath, ManifestFileName)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write frame manifest '%s': %w", path, err)
+	}
+	return nil
+}
+
+// LoadManifest reads the manifest from a frame directory
+func LoadManifest(frameDirPath string) (*Manifest, error) {
+	path := filepath.Join(frameDirPath, ManifestFileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse frame manifest '%s': %w", path, err)
+	}
+
+	// A manifest that no longer matches the files on disk cannot be trusted
+	for _, frame := range manifest.Frames {
+		if _, err := os.Stat(filepath.Join(frameDirPath, frame.File)); err != nil {
+			return nil, fmt.Errorf("frame '%s' listed in manifest is missing: %w", frame.File, err)
+		}
+	}
+
+	return &manifest, nil
+}
@@ -0,0 +1,209 @@
+package extractor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// touch creates files under dir holding their own name
+func touch(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestManifestMerge(t *testing.T) {
+	tests := []struct {
+		name       string
+		existing   []Frame
+		extracted  []Frame // files in the extraction directory
+		wantMerged []Frame
+		wantFrames []Frame
+	}{
+		{
+			name:       "empty manifest",
+			extracted:  []Frame{{File: "out_0001.jpg", TimestampMs: 0}, {File: "out_0002.jpg", TimestampMs: 1000}},
+			wantMerged: []Frame{{File: "frame_0001.jpg", TimestampMs: 0}, {File: "frame_0002.jpg", TimestampMs: 1000}},
+			wantFrames: []Frame{{File: "frame_0001.jpg", TimestampMs: 0}, {File: "frame_0002.jpg", TimestampMs: 1000}},
+		},
+		{
+			// New frames are numbered after the highest frame, not the count,
+			// and sorted in between the existing ones
+			name:      "numbered after existing frames",
+			existing:  []Frame{{File: "frame_0001.jpg", TimestampMs: 0}, {File: "frame_0007.jpg", TimestampMs: 4000}},
+			extracted: []Frame{{File: "out_0001.jpg", TimestampMs: 2000}, {File: "out_0002.jpg", TimestampMs: 6000}},
+			wantMerged: []Frame{
+				{File: "frame_0008.jpg", TimestampMs: 2000},
+				{File: "frame_0009.jpg", TimestampMs: 6000},
+			},
+			wantFrames: []Frame{
+				{File: "frame_0001.jpg", TimestampMs: 0},
+				{File: "frame_0008.jpg", TimestampMs: 2000},
+				{File: "frame_0007.jpg", TimestampMs: 4000},
+				{File: "frame_0009.jpg", TimestampMs: 6000},
+			},
+		},
+		{
+			// A frame at a known timestamp stands for the existing frame,
+			// which keeps its replacement note
+			name: "existing timestamps",
+			existing: []Frame{
+				{File: "frame_0001.jpg", TimestampMs: 0},
+				{File: "frame_0002.jpg", TimestampMs: 1000, Replaced: "black frame"},
+			},
+			extracted: []Frame{
+				{File: "out_0001.jpg", TimestampMs: 1000},
+				{File: "out_0002.jpg", TimestampMs: 1500},
+				{File: "out_0003.jpg", TimestampMs: 1500},
+			},
+			wantMerged: []Frame{
+				{File: "frame_0002.jpg", TimestampMs: 1000, Replaced: "black frame"},
+				{File: "frame_0003.jpg", TimestampMs: 1500},
+				{File: "frame_0003.jpg", TimestampMs: 1500},
+			},
+			wantFrames: []Frame{
+				{File: "frame_0001.jpg", TimestampMs: 0},
+				{File: "frame_0002.jpg", TimestampMs: 1000, Replaced: "black frame"},
+				{File: "frame_0003.jpg", TimestampMs: 1500},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frameDir := t.TempDir()
+			extractDir := t.TempDir()
+			for _, frame := range tt.existing {
+				touch(t, frameDir, frame.File)
+			}
+			for _, frame := range tt.extracted {
+				touch(t, extractDir, frame.File)
+			}
+
+			m := &Manifest{Frames: append([]Frame(nil), tt.existing...)}
+			merged, err := m.merge(frameDir, extractDir, tt.extracted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(merged, tt.wantMerged) {
+				t.Errorf("merged %+v, want %+v", merged, tt.wantMerged)
+			}
+			if !reflect.DeepEqual(m.Frames, tt.wantFrames) {
+				t.Errorf("manifest frames %+v, want %+v", m.Frames, tt.wantFrames)
+			}
+
+			// Every manifest frame is on disk, existing files are untouched
+			for _, frame := range m.Frames {
+				if _, err := os.Stat(filepath.Join(frameDir, frame.File)); err != nil {
+					t.Errorf("%s: %v", frame.File, err)
+				}
+			}
+			for _, frame := range tt.existing {
+				data, _ := os.ReadFile(filepath.Join(frameDir, frame.File))
+				if string(data) != frame.File {
+					t.Errorf("%s was overwritten", frame.File)
+				}
+			}
+		})
+	}
+}
+
+func TestManifestMergeMissingFile(t *testing.T) {
+	m := &Manifest{}
+	if _, err := m.merge(t.TempDir(), t.TempDir(), []Frame{{File: "missing.jpg"}}); err == nil {
+		t.Error("expected an error for a frame missing from the extraction directory")
+	}
+}
+
+func TestFrameFileNumber(t *testing.T) {
+	tests := []struct {
+		name string
+		want int
+	}{
+		{"frame_0001.jpg", 1},
+		{"frame_0042.jpg", 42},
+		{"frame_12345.jpg", 12345},
+		{"frame_0003.png", 3},
+		{"cover.jpg", 0},
+		{"frame_.jpg", 0},
+	}
+	for _, tt := range tests {
+		if got := frameFileNumber(tt.name); got != tt.want {
+			t.Errorf("frameFileNumber(%q) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestManifestPositions(t *testing.T) {
+	m := &Manifest{Frames: []Frame{
+		{File: "frame_0001.jpg", TimestampMs: 0},
+		{File: "frame_0002.jpg", TimestampMs: 1000},
+		{File: "frame_0003.jpg", TimestampMs: 2500},
+	}}
+
+	neighbours := []struct {
+		file          string
+		before, after int64
+	}{
+		{"frame_0001.jpg", -1, 1000},
+		{"frame_0002.jpg", 0, 2500},
+		{"frame_0003.jpg", 1000, -1},
+		{"frame_0009.jpg", -1, -1},
+	}
+	for _, tt := range neighbours {
+		if before, after := m.Neighbours(tt.file); before != tt.before || after != tt.after {
+			t.Errorf("Neighbours(%q) = %d, %d, want %d, %d", tt.file, before, after, tt.before, tt.after)
+		}
+	}
+
+	between := []struct {
+		start, end int64
+		want       []string
+	}{
+		{0, 0, []string{"frame_0001.jpg", "frame_0002.jpg", "frame_0003.jpg"}},
+		{1000, 0, []string{"frame_0002.jpg", "frame_0003.jpg"}},
+		{0, 1000, []string{"frame_0001.jpg"}},
+		{500, 2500, []string{"frame_0002.jpg"}},
+		{3000, 0, nil},
+	}
+	for _, tt := range between {
+		var got []string
+		for _, frame := range m.Between(tt.start, tt.end).Frames {
+			got = append(got, frame.File)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Between(%d, %d) = %v, want %v", tt.start, tt.end, got, tt.want)
+		}
+	}
+	if len(m.Frames) != 3 {
+		t.Errorf("Between changed the manifest, %d frames left", len(m.Frames))
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+	m := &Manifest{Video: "video.mp4", Frames: []Frame{{File: "frame_0001.jpg"}, {File: "frame_0002.jpg", TimestampMs: 1000}}}
+	if err := m.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, dir, "frame_0001.jpg")
+
+	// A manifest listing a missing frame is not trusted
+	if _, err := LoadManifest(dir); err == nil {
+		t.Error("expected an error for a missing frame")
+	}
+
+	touch(t, dir, "frame_0002.jpg")
+	loaded, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, m) {
+		t.Errorf("loaded %+v, want %+v", loaded, m)
+	}
+}
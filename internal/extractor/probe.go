@@ -0,0 +1,215 @@
+package extractor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bdougie/vision/internal/models"
+)
+
+const (
+	// sampledHashThreshold is the file size above which Fingerprint samples chunks
+	sampledHashThreshold = 1 << 30
+	sampledChunks        = 64
+	sampledChunkSize     = 1 << 20
+)
+
+// supportedVideoCodecs lists the codecs we know ffmpeg can decode into frames reliably
+var supportedVideoCodecs = map[string]bool{
+	"h264":       true,
+	"hevc":       true,
+	"vp8":        true,
+	"vp9":        true,
+	"av1":        true,
+	"mpeg4":      true,
+	"mpeg2video": true,
+	"prores":     true,
+	"mjpeg":      true,
+}
+
+// ffprobeOutput is the subset of `ffprobe -print_format json` we use
+type ffprobeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		Size       string `json:"size"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType    string            `json:"codec_type"`
+		CodecName    string            `json:"codec_name"`
+		Width        int               `json:"width"`
+		Height       int               `json:"height"`
+		AvgFrameRate string            `json:"avg_frame_rate"`
+		RFrameRate   string            `json:"r_frame_rate"`
+		Tags         map[string]string `json:"tags"`
+		SideDataList []struct {
+			Rotation int `json:"rotation"`
+		} `json:"side_data_list"`
+		Disposition struct {
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+	} `json:"streams"`
+}
+
+// ProbeVideo inspects a video file with ffprobe and fingerprints its contents
+func ProbeVideo(ctx context.Context, videoPath string) (*models.VideoMetadata, error) {
+	if _, err := os.Stat(videoPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("video file does not exist at path: '%s'", videoPath)
+	}
+
+	ffprobeCommand := exec.CommandContext(ctx,
+		"ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		videoPath,
+	)
+
+	output, err := ffprobeCommand.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("probing cancelled: %w", ctx.Err())
+	}
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("ffprobe failed: %v\nOutput: %s", err, string(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("ffprobe failed: %v", err)
+	}
+
+	var probe ffprobeOutput
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %v", err)
+	}
+
+	meta := &models.VideoMetadata{
+		Name:      strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath)),
+		Path:      videoPath,
+		Container: probe.Format.FormatName,
+	}
+	if duration, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+		meta.DurationMs = int64(duration * 1000)
+	}
+	if size, err := strconv.ParseInt(probe.Format.Size, 10, 64); err == nil {
+		meta.SizeBytes = size
+	}
+
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "video":
+			// Cover art shows up as a video stream, skip it
+			if meta.VideoCodec != "" || stream.Disposition.AttachedPic == 1 {
+				continue
+			}
+			meta.VideoCodec = stream.CodecName
+			meta.Width = stream.Width
+			meta.Height = stream.Height
+			meta.FrameRate = parseFrameRate(stream.AvgFrameRate)
+			if meta.FrameRate == 0 {
+				meta.FrameRate = parseFrameRate(stream.RFrameRate)
+			}
+			if rotate, ok := stream.Tags["rotate"]; ok {
+				meta.Rotation, _ = strconv.Atoi(rotate)
+			}
+			for _, sideData := range stream.SideDataList {
+				if sideData.Rotation != 0 {
+					meta.Rotation = sideData.Rotation
+				}
+			}
+		case "audio":
+			if !meta.HasAudio {
+				meta.HasAudio = true
+				meta.AudioCodec = stream.CodecName
+			}
+		}
+	}
+
+	fingerprint, err := Fingerprint(videoPath)
+	if err != nil {
+		return nil, err
+	}
+	meta.Fingerprint = fingerprint
+
+	return meta, nil
+}
+
+// ValidateVideo rejects inputs that frame extraction cannot handle
+func ValidateVideo(meta *models.VideoMetadata) error {
+	if meta.VideoCodec == "" {
+		return fmt.Errorf("'%s' has no video stream", meta.Name)
+	}
+	if !supportedVideoCodecs[meta.VideoCodec] {
+		return fmt.Errorf("'%s' uses unsupported video codec '%s'", meta.Name, meta.VideoCodec)
+	}
+	if meta.DurationMs <= 0 {
+		return fmt.Errorf("'%s' has no duration, it may be a still image or a broken file", meta.Name)
+	}
+	if meta.Width <= 0 || meta.Height <= 0 {
+		return fmt.Errorf("'%s' has an invalid resolution %dx%d", meta.Name, meta.Width, meta.Height)
+	}
+	return nil
+}
+
+// parseFrameRate converts an ffprobe rational such as "30000/1001" to frames per second
+func parseFrameRate(rate string) float64 {
+	num, den, found := strings.Cut(rate, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// Fingerprint returns a hex SHA-256 identifying the contents of a file. Files
+// up to sampledHashThreshold are hashed in full; larger files hash their size
+// and sampledChunks evenly spaced chunks, so identity stays cheap for long
+// recordings while renames and copies still resolve to the same video.
+func Fingerprint(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open video for hashing: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat video for hashing: %v", err)
+	}
+
+	hasher := sha256.New()
+	if info.Size() <= sampledHashThreshold {
+		if _, err := io.Copy(hasher, file); err != nil {
+			return "", fmt.Errorf("failed to hash video: %v", err)
+		}
+		return hex.EncodeToString(hasher.Sum(nil)), nil
+	}
+
+	// The prefix keeps sampled fingerprints apart from full ones
+	fmt.Fprintf(hasher, "sampled:%d:", info.Size())
+	chunk := make([]byte, sampledChunkSize)
+	step := (info.Size() - sampledChunkSize) / (sampledChunks - 1)
+	for i := int64(0); i < sampledChunks; i++ {
+		n, err := file.ReadAt(chunk, i*step)
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("failed to hash video: %v", err)
+		}
+		hasher.Write(chunk[:n])
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
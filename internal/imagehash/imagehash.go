@@ -0,0 +1,206 @@
+// Package imagehash computes perceptual hashes of images, 64 bit
+// fingerprints that stay close for images that look alike
+package imagehash
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"math/bits"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// Algorithm selects how an image is hashed
+type Algorithm string
+
+const (
+	// DHash compares the brightness of neighbouring pixels of a 9x8
+	// thumbnail, it is fast and tolerates compression and small shifts
+	DHash Algorithm = "dhash"
+	// PHash compares the low frequencies of a 32x32 thumbnail's discrete
+	// cosine transform with their median, it also tolerates changes of
+	// contrast and gamma
+	PHash Algorithm = "phash"
+)
+
+// Hash is a perceptual hash, similar images have hashes a small Hamming
+// distance apart
+type Hash uint64
+
+// Distance returns the number of bits in which h and other differ, from 0
+// for identical hashes to 64
+func (h Hash) Distance(other Hash) int {
+	return bits.OnesCount64(uint64(h ^ other))
+}
+
+// String renders the hash as 16 hex digits
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// Parse reads a hash rendered by String
+func Parse(s string) (Hash, error) {
+	value, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid image hash '%s': %w", s, err)
+	}
+	return Hash(value), nil
+}
+
+// File decodes the JPEG or PNG image at path and hashes it
+func File(path string, algorithm Algorithm) (Hash, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode '%s': %w", path, err)
+	}
+	return Compute(img, algorithm)
+}
+
+// Compute hashes an image with the given algorithm
+func Compute(img image.Image, algorithm Algorithm) (Hash, error) {
+	if img.Bounds().Empty() {
+		return 0, fmt.Errorf("cannot hash an empty image")
+	}
+	switch algorithm {
+	case DHash:
+		return dHash(img), nil
+	case PHash:
+		return pHash(img), nil
+	default:
+		return 0, fmt.Errorf("unknown hash algorithm '%s', use '%s' or '%s'", algorithm, DHash, PHash)
+	}
+}
+
+// dHash sets a bit for every pixel of a 9x8 thumbnail that is brighter than
+// its right neighbour
+func dHash(img image.Image) Hash {
+	const width, height = 9, 8
+	pixels := Thumbnail(img, width, height)
+
+	var hash Hash
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			hash <<= 1
+			if pixels[y*width+x] > pixels[y*width+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// pHashSize is the side of the thumbnail transformed by pHash, pHashBands the
+// side of the block of lowest frequencies kept from it
+const (
+	pHashSize  = 32
+	pHashBands = 8
+)
+
+// pHashCosines[u][x] is the DCT-II basis function of frequency u at pixel x
+var pHashCosines = func() [pHashBands][pHashSize]float64 {
+	var table [pHashBands][pHashSize]float64
+	for u := range table {
+		for x := range table[u] {
+			table[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * pHashSize))
+		}
+	}
+	return table
+}()
+
+// pHash sets a bit for every low frequency coefficient of a 32x32
+// thumbnail's DCT that is above their median. The median leaves out the
+// constant term, which only reflects the overall brightness.
+func pHash(img image.Image) Hash {
+	pixels := Thumbnail(img, pHashSize, pHashSize)
+
+	// The DCT is separable, transform the rows first and then the columns
+	var rows [pHashSize][pHashBands]float64
+	for y := 0; y < pHashSize; y++ {
+		for u := 0; u < pHashBands; u++ {
+			sum := 0.0
+			for x := 0; x < pHashSize; x++ {
+				sum += pixels[y*pHashSize+x] * pHashCosines[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	coefficients := make([]float64, 0, pHashBands*pHashBands)
+	for v := 0; v < pHashBands; v++ {
+		for u := 0; u < pHashBands; u++ {
+			sum := 0.0
+			for y := 0; y < pHashSize; y++ {
+				sum += rows[y][u] * pHashCosines[v][y]
+			}
+			coefficients = append(coefficients, sum)
+		}
+	}
+
+	sorted := slices.Clone(coefficients[1:])
+	slices.Sort(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash Hash
+	for _, coefficient := range coefficients {
+		hash <<= 1
+		if coefficient > median {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+// Thumbnail scales img down to width x height grayscale pixels, row by row,
+// each the mean luminance (0-255) of the source pixels it covers
+func Thumbnail(img image.Image, width, height int) []float64 {
+	bounds := img.Bounds()
+	sourceWidth, sourceHeight := bounds.Dx(), bounds.Dy()
+	luma := lumaFunc(img)
+
+	sums := make([]float64, width*height)
+	counts := make([]int, width*height)
+	for y := 0; y < sourceHeight; y++ {
+		row := y * height / sourceHeight * width
+		for x := 0; x < sourceWidth; x++ {
+			cell := row + x*width/sourceWidth
+			sums[cell] += luma(bounds.Min.X+x, bounds.Min.Y+y)
+			counts[cell]++
+		}
+	}
+
+	// Thumbnails larger than the image have cells no pixel falls into, they
+	// take the pixel under their centre
+	for cell := range sums {
+		if counts[cell] == 0 {
+			x := (cell%width*2 + 1) * sourceWidth / (2 * width)
+			y := (cell/width*2 + 1) * sourceHeight / (2 * height)
+			sums[cell] = luma(bounds.Min.X+x, bounds.Min.Y+y)
+		} else {
+			sums[cell] /= float64(counts[cell])
+		}
+	}
+	return sums
+}
+
+// lumaFunc returns a function reading the luminance of a pixel, reading
+// the luma plane directly for decoded JPEGs and grayscale images
+func lumaFunc(img image.Image) func(x, y int) float64 {
+	switch img := img.(type) {
+	case *image.YCbCr:
+		return func(x, y int) float64 { return float64(img.Y[img.YOffset(x, y)]) }
+	case *image.Gray:
+		return func(x, y int) float64 { return float64(img.Pix[img.PixOffset(x, y)]) }
+	default:
+		return func(x, y int) float64 { return float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y) }
+	}
+}
@@ -0,0 +1,250 @@
+package imagehash
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// scene draws a smooth synthetic picture, shift moves it sideways, contrast
+// scales it around mid grey and brightness is added to every pixel
+func scene(width, height, shift int, contrast, brightness float64) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx := float64(x+shift) / float64(width)
+			fy := float64(y) / float64(height)
+			value := 128 + brightness + contrast*(40*math.Sin(2*math.Pi*(fx+0.1))+
+				30*math.Cos(2*math.Pi*(fy*1.5+fx*0.5))+
+				25*math.Sin(2*math.Pi*(fx*2.5-fy))+
+				20*math.Cos(2*math.Pi*(fx*0.7+fy*3.2)))
+			img.SetGray(x, y, color.Gray{Y: uint8(max(0, min(255, value)))})
+		}
+	}
+	return img
+}
+
+// gradient draws a horizontal ramp, rising to the right unless falling is set
+func gradient(width, height int, falling bool) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			value := x * 255 / (width - 1)
+			if falling {
+				value = 255 - value
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(value)})
+		}
+	}
+	return img
+}
+
+// transposed mirrors an image along its diagonal
+func transposed(src *image.Gray) *image.Gray {
+	bounds := src.Bounds()
+	img := image.NewGray(image.Rect(0, 0, bounds.Dy(), bounds.Dx()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			img.SetGray(y, x, src.GrayAt(x, y))
+		}
+	}
+	return img
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b Hash
+		want int
+	}{
+		{0, 0, 0},
+		{0, 1, 1},
+		{0xff, 0x0f, 4},
+		{0, 0xffffffffffffffff, 64},
+		{0xaaaaaaaaaaaaaaaa, 0x5555555555555555, 64},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Distance(tt.b); got != tt.want {
+			t.Errorf("%s.Distance(%s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := tt.b.Distance(tt.a); got != tt.want {
+			t.Errorf("%s.Distance(%s) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Hash
+		wantErr bool
+	}{
+		{in: "0000000000000000", want: 0},
+		{in: "00000000000000ff", want: 0xff},
+		{in: "ffffffffffffffff", want: 0xffffffffffffffff},
+		{in: "", wantErr: true},
+		{in: "not a hash", wantErr: true},
+		{in: "1ffffffffffffffff", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+		if err == nil && got.String() != tt.in {
+			t.Errorf("Parse(%q).String() = %q", tt.in, got.String())
+		}
+	}
+}
+
+func TestDHashGradients(t *testing.T) {
+	tests := []struct {
+		name string
+		img  image.Image
+		want Hash
+	}{
+		// No pixel is brighter than its right neighbour
+		{"rising", gradient(90, 80, false), 0},
+		// Every pixel is brighter than its right neighbour
+		{"falling", gradient(90, 80, true), 0xffffffffffffffff},
+	}
+	for _, tt := range tests {
+		got, err := Compute(tt.img, DHash)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: dhash = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestComputeThresholds(t *testing.T) {
+	original := scene(320, 180, 0, 1, 0)
+	tests := []struct {
+		name        string
+		algorithm   Algorithm
+		other       image.Image
+		minDistance int // the hashes must be at least this far apart
+		maxDistance int // and at most this far
+	}{
+		{"identical", DHash, scene(320, 180, 0, 1, 0), 0, 0},
+		{"identical", PHash, scene(320, 180, 0, 1, 0), 0, 0},
+		{"brighter", DHash, scene(320, 180, 0, 1, 12), 0, 4},
+		{"brighter", PHash, scene(320, 180, 0, 1, 12), 0, 4},
+		{"less contrast", DHash, scene(320, 180, 0, 0.7, 0), 0, 4},
+		{"less contrast", PHash, scene(320, 180, 0, 0.7, 0), 0, 4},
+		{"downscaled", DHash, scene(160, 90, 0, 1, 0), 0, 4},
+		{"downscaled", PHash, scene(160, 90, 0, 1, 0), 0, 4},
+		// Coefficients close to the median flip when the picture moves,
+		// dHash only compares neighbours and holds up better
+		{"shifted a pixel", DHash, scene(320, 180, 1, 1, 0), 0, 4},
+		{"shifted a pixel", PHash, scene(320, 180, 1, 1, 0), 0, 8},
+		{"transposed", DHash, transposed(scene(180, 320, 0, 1, 0)), 16, 64},
+		{"transposed", PHash, transposed(scene(180, 320, 0, 1, 0)), 16, 64},
+	}
+	for _, tt := range tests {
+		base, err := Compute(original, tt.algorithm)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.algorithm, err)
+		}
+		hash, err := Compute(tt.other, tt.algorithm)
+		if err != nil {
+			t.Fatalf("%s %s: %v", tt.algorithm, tt.name, err)
+		}
+		distance := base.Distance(hash)
+		if distance < tt.minDistance || distance > tt.maxDistance {
+			t.Errorf("%s %s: distance %d, want %d to %d", tt.algorithm, tt.name, distance, tt.minDistance, tt.maxDistance)
+		}
+	}
+}
+
+func TestComputeErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		img       image.Image
+		algorithm Algorithm
+	}{
+		{"empty image", image.NewGray(image.Rect(0, 0, 0, 0)), DHash},
+		{"unknown algorithm", scene(16, 16, 0, 1, 0), Algorithm("ahash")},
+	}
+	for _, tt := range tests {
+		if _, err := Compute(tt.img, tt.algorithm); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	img := scene(320, 180, 0, 1, 0)
+	path := filepath.Join(t.TempDir(), "frame.jpg")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: 85}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// JPEG compression must not move the hash much
+	for _, algorithm := range []Algorithm{DHash, PHash} {
+		fromFile, err := File(path, algorithm)
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		direct, _ := Compute(img, algorithm)
+		if distance := fromFile.Distance(direct); distance > 2 {
+			t.Errorf("%s: JPEG hash %d bits from the decoded image's", algorithm, distance)
+		}
+	}
+
+	if _, err := File(filepath.Join(t.TempDir(), "missing.jpg"), DHash); err == nil {
+		t.Error("File of a missing image: expected an error")
+	}
+	garbage := filepath.Join(t.TempDir(), "garbage.jpg")
+	os.WriteFile(garbage, []byte("not an image"), 0644)
+	if _, err := File(garbage, DHash); err == nil {
+		t.Error("File of an undecodable image: expected an error")
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	// 2x2 blocks of 0 and 200 on the left, 100 on the right
+	img := image.NewGray(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		img.SetGray(0, y, color.Gray{Y: 0})
+		img.SetGray(1, y, color.Gray{Y: 200})
+		img.SetGray(2, y, color.Gray{Y: 100})
+		img.SetGray(3, y, color.Gray{Y: 100})
+	}
+	tests := []struct {
+		name          string
+		width, height int
+		want          []float64
+	}{
+		{"mean", 2, 1, []float64{100, 100}},
+		{"single cell", 1, 1, []float64{100}},
+		{"same size", 4, 1, []float64{0, 200, 100, 100}},
+		// Cells no pixel falls into take the pixel under their centre
+		{"upscaled", 8, 1, []float64{0, 0, 200, 200, 100, 100, 100, 100}},
+	}
+	for _, tt := range tests {
+		got := Thumbnail(img, tt.width, tt.height)
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: %d cells, want %d", tt.name, len(got), len(tt.want))
+		}
+		for i := range got {
+			if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+				t.Errorf("%s: cell %d = %v, want %v", tt.name, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
@@ -1,22 +1,339 @@
 package models
 
+import "time"
+
 // WorkItem represents a frame to be processed
 type WorkItem struct {
-    FramePath string
-    FrameNum  int
-    Total     int
+    FramePath   string
+    FrameNum    int
+    Total       int
+    TimestampMs int64
+
+    // Hash is the perceptual hash of the frame, empty when frames are not deduplicated
+    Hash string
+
+    // Replaced says why the frame extracted first at this position was
+    // swapped for one nearby, it becomes the reason of the frame's status
+    Replaced string
 }
 
 // AnalysisResult represents the result of analyzing a frame
 type AnalysisResult struct {
-    Frame   string `json:"frame"`
-    Content string `json:"content"`
+    Frame       string `json:"frame"`
+    TimestampMs int64  `json:"timestamp_ms"`
+    Content     string `json:"content"`
+
+    // Structured is set when the frame was analyzed in structured mode
+    Structured *FrameAnalysis `json:"structured,omitempty"`
 }
 
 // FrameSearchResult represents a search result when looking for similar frames
 type FrameSearchResult struct {
+    VideoFingerprint string `json:"video_fingerprint"`
+    VideoName        string `json:"video_name"`
+
     FrameNumber int     `json:"frame_number"`
     FramePath   string  `json:"frame_path"`
+    TimestampMs int64   `json:"timestamp_ms"`
     Description string  `json:"description"`
-    Similarity  float64 `json:"similarity"`
+
+    // Score orders the results: the similarity in semantic mode, the text
+    // score in text mode and the fused rank score in hybrid mode
+    Score float64 `json:"score"`
+
+    // Similarity is the cosine similarity of the frame to the query embedding
+    Similarity float64 `json:"similarity,omitempty"`
+
+    // TextScore is the full-text rank of the description for the query
+    TextScore float64 `json:"text_score,omitempty"`
+
+    // SemanticRank and TextRank are the 1-based positions of the frame in the
+    // rankings fused by hybrid mode, zero when it was not in that ranking
+    SemanticRank int `json:"semantic_rank,omitempty"`
+    TextRank     int `json:"text_rank,omitempty"`
+
+    // VideoDurationMs is the length of the source video, zero if it was never probed
+    VideoDurationMs int64 `json:"video_duration_ms,omitempty"`
+}
+
+// SearchMode selects how a search query is matched against analyses
+type SearchMode string
+
+const (
+    // SearchSemantic ranks frames by embedding similarity to the query
+    SearchSemantic SearchMode = "semantic"
+    // SearchText ranks frames by full-text match of their description
+    SearchText SearchMode = "text"
+    // SearchHybrid fuses the semantic and text rankings
+    SearchHybrid SearchMode = "hybrid"
+)
+
+// SearchRequest describes a search over the frames of all stored videos,
+// optionally narrowed down by the filters
+type SearchRequest struct {
+    Query string     `json:"query"`
+    Mode  SearchMode `json:"mode"`
+    Limit int        `json:"limit"`
+
+    // Videos limits the search to these video fingerprints
+    Videos []string `json:"videos,omitempty"`
+
+    // VideoName keeps videos whose name contains this text, ignoring case
+    VideoName string `json:"video_name,omitempty"`
+
+    // Tags keeps frames whose structured analysis has all of these tags
+    Tags []string `json:"tags,omitempty"`
+
+    // Objects keeps frames whose structured analysis lists all of these objects
+    Objects []string `json:"objects,omitempty"`
+
+    // StoredAfter and StoredBefore keep videos first stored in this range
+    StoredAfter  *time.Time `json:"stored_after,omitempty"`
+    StoredBefore *time.Time `json:"stored_before,omitempty"`
+
+    // FromMs and ToMs keep frames within this window of their video, a zero
+    // ToMs leaves the window open ended
+    FromMs int64 `json:"from_ms,omitempty"`
+    ToMs   int64 `json:"to_ms,omitempty"`
+
+    // MinScore drops results whose Score is lower. Scores depend on the mode:
+    // cosine similarity (-1 to 1), text rank (0 up) or fused rank (0 to 2/61).
+    MinScore float64 `json:"min_score,omitempty"`
+
+    // Offset skips this many results. Cursor continues after the last result
+    // of an earlier page instead, the two cannot be combined.
+    Offset int    `json:"offset,omitempty"`
+    Cursor string `json:"cursor,omitempty"`
+}
+
+// SearchPage is one page of search results
+type SearchPage struct {
+    Results []FrameSearchResult `json:"results"`
+
+    // Total counts all results matching the request across pages. Hybrid
+    // searches count the candidates taken from both rankings.
+    Total int `json:"total"`
+
+    // NextCursor fetches the following page, empty on the last page
+    NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// VideoMetadata describes a source video as reported by ffprobe
+type VideoMetadata struct {
+    // Fingerprint identifies the video by content, see extractor.Fingerprint
+    Fingerprint string `json:"fingerprint"`
+
+    // Name is the display name taken from the file name, Path where it was read from
+    Name       string  `json:"name"`
+    Path       string  `json:"path"`
+    DurationMs int64   `json:"duration_ms"`
+    Container  string  `json:"container"`
+    VideoCodec string  `json:"video_codec"`
+    AudioCodec string  `json:"audio_codec,omitempty"`
+    Width      int     `json:"width"`
+    Height     int     `json:"height"`
+    FrameRate  float64 `json:"frame_rate"`
+    Rotation   int     `json:"rotation"`
+    HasAudio   bool    `json:"has_audio"`
+    SizeBytes  int64   `json:"size_bytes"`
+}
+
+// Key returns the short form of the fingerprint used for directory names
+func (m VideoMetadata) Key() string {
+    if len(m.Fingerprint) > 16 {
+        return m.Fingerprint[:16]
+    }
+    return m.Fingerprint
+}
+
+// FrameAnalysis is the typed description of a frame produced in structured mode
+type FrameAnalysis struct {
+    Summary     string           `json:"summary"`
+    Objects     []DetectedObject `json:"objects"`
+    People      []Person         `json:"people"`
+    VisibleText []string         `json:"visible_text"`
+    Setting     string           `json:"setting"`
+    Tags        []string         `json:"tags"`
+}
+
+// DetectedObject is a kind of object visible in a frame and how many there are
+type DetectedObject struct {
+    Name  string `json:"name"`
+    Count int    `json:"count"`
+}
+
+// Person is someone visible in a frame and what they are doing
+type Person struct {
+    Description string `json:"description"`
+    Action      string `json:"action"`
+}
+
+// FrameState is the outcome of analyzing a frame
+type FrameState string
+
+const (
+    FrameOK      FrameState = "ok"
+    FrameFailed  FrameState = "failed"
+    FrameSkipped FrameState = "skipped"
+
+    // FrameDuplicate is a frame not analyzed because it looks like an earlier
+    // one, its description is that of the frame it duplicates
+    FrameDuplicate FrameState = "duplicate"
+
+    // FrameRejected is a frame not analyzed because it is black, blurred or
+    // blank and no better frame was found near it
+    FrameRejected FrameState = "rejected"
+)
+
+// FrameStatus records the final state of a frame so failed ones can be retried
+type FrameStatus struct {
+    Frame       string     `json:"frame"`
+    TimestampMs int64      `json:"timestamp_ms"`
+    Status      FrameState `json:"status"`
+    Reason      string     `json:"reason,omitempty"`
+    Attempts    int        `json:"attempts"`
+
+    // Hash is the perceptual hash of the frame, DuplicateOf the frame it
+    // duplicates when Status is FrameDuplicate
+    Hash        string `json:"hash,omitempty"`
+    DuplicateOf string `json:"duplicate_of,omitempty"`
+}
+
+// VideoSummary is a stored video with counts of its frames
+type VideoSummary struct {
+    Video     VideoMetadata `json:"video"`
+    Frames    int           `json:"frames"`
+    Analyzed  int           `json:"analyzed"`
+    Failed    int           `json:"failed"`
+    CreatedAt time.Time     `json:"created_at"`
+}
+
+// FrameRecord is everything stored about one frame of a video
+type FrameRecord struct {
+    Frame       string     `json:"frame"`
+    FrameNumber int        `json:"frame_number"`
+    TimestampMs int64      `json:"timestamp_ms"`
+    Status      FrameState `json:"status,omitempty"`
+    Reason      string     `json:"reason,omitempty"`
+    Attempts    int        `json:"attempts,omitempty"`
+    Content     string     `json:"content,omitempty"`
+
+    // Structured is set when the frame was analyzed in structured mode
+    Structured *FrameAnalysis `json:"structured,omitempty"`
+
+    // Hash is the perceptual hash of the frame. DuplicateOf names the frame
+    // a duplicate looks like, Content and Structured are then that frame's.
+    Hash        string `json:"hash,omitempty"`
+    DuplicateOf string `json:"duplicate_of,omitempty"`
+}
+
+// JobState is where an analysis job is in its lifecycle
+type JobState string
+
+const (
+    JobQueued    JobState = "queued"
+    JobRunning   JobState = "running"
+    JobSucceeded JobState = "succeeded"
+    JobFailed    JobState = "failed"
+    JobCancelled JobState = "cancelled"
+)
+
+// JobOptions are the per-job overrides of the configured processing options
+type JobOptions struct {
+    // Force extracts frames again and analyzes all of them
+    Force bool `json:"force,omitempty"`
+
+    // RetryFailed only analyzes frames that failed or were skipped before
+    RetryFailed bool `json:"retry_failed,omitempty"`
+
+    // StartMs and EndMs limit the analysis to part of the video
+    StartMs int64 `json:"start_ms,omitempty"`
+    EndMs   int64 `json:"end_ms,omitempty"`
+
+    // TimestampsMs analyzes single frames at these positions instead
+    TimestampsMs []int64 `json:"timestamps_ms,omitempty"`
+}
+
+// Progress counts the frames of a running analysis
+type Progress struct {
+    // Total is the number of frames queued for analysis in this run
+    Total   int `json:"total"`
+    Done    int `json:"done"`
+    OK      int `json:"ok"`
+    Failed  int `json:"failed"`
+    Skipped int `json:"skipped"`
+
+    // Duplicates counts the frames left out of the run as near-duplicates
+    Duplicates int `json:"duplicates,omitempty"`
+
+    // Rejected counts the frames left out for their quality, Replaced the
+    // frames swapped for a better one nearby
+    Rejected int `json:"rejected,omitempty"`
+    Replaced int `json:"replaced,omitempty"`
+}
+
+// ExtractionProgress reports how far ffmpeg has read into a video
+type ExtractionProgress struct {
+    // OutTimeMs is the position in the video reached so far
+    OutTimeMs  int64 `json:"out_time_ms"`
+    DurationMs int64 `json:"duration_ms,omitempty"`
+
+    // Percent is OutTimeMs relative to DurationMs, zero while the duration is unknown
+    Percent float64 `json:"percent"`
+
+    // Speed is how many times faster than real time the video is read
+    Speed float64 `json:"speed,omitempty"`
+
+    // Frames is the number of frames written so far
+    Frames int `json:"frames"`
+}
+
+// Job is a video analysis submitted to the server
+type Job struct {
+    ID        string         `json:"id"`
+    State     JobState       `json:"state"`
+    VideoPath string         `json:"video_path"`
+    Options   JobOptions     `json:"options"`
+    Video     *VideoMetadata `json:"video,omitempty"`
+    Progress  Progress       `json:"progress"`
+    Error     string         `json:"error,omitempty"`
+
+    // Extraction is the last reported progress of frame extraction
+    Extraction *ExtractionProgress `json:"extraction,omitempty"`
+
+    CreatedAt  time.Time  `json:"created_at"`
+    StartedAt  *time.Time `json:"started_at,omitempty"`
+    FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// FrameJob is a frame claimed from the shared work queue by a worker
+type FrameJob struct {
+    ID        int64
+    VideoName string
+    Work      WorkItem
+
+    // Worker is the name the frame was claimed under
+    Worker string
+
+    // LeaseToken identifies this claim of the frame and changes whenever it
+    // is claimed again. The queue only takes heartbeats and outcomes carrying
+    // the token of the current claim, as every goroutine of a worker claims
+    // under the same name.
+    LeaseToken int64
+
+    // Image is the extracted frame, workers do not need the frame directory
+    Image []byte
+
+    // Attempts counts the claims of this frame including the current one
+    Attempts    int
+    MaxAttempts int
+}
+
+// QueueSummary counts the queued frames of a video by state
+type QueueSummary struct {
+    Video   VideoMetadata `json:"video"`
+    Pending int           `json:"pending"`
+    Running int           `json:"running"`
+    Done    int           `json:"done"`
+    Failed  int           `json:"failed"`
 }
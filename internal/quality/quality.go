@@ -0,0 +1,118 @@
+// Package quality measures how much a frame is worth analyzing: its
+// sharpness, brightness and information content
+package quality
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+
+	"github.com/bdougie/vision/internal/imagehash"
+)
+
+// measureWidth is the width frames are scaled down to before they are
+// measured, so sharpness reads the same for every resolution
+const measureWidth = 640
+
+// Metrics describes the quality of a frame
+type Metrics struct {
+	// Sharpness is the variance of the Laplacian, low for blurred frames
+	Sharpness float64
+
+	// Luminance is the mean brightness from 0 (black) to 255 (white)
+	Luminance float64
+
+	// Entropy is the Shannon entropy of the brightness histogram in bits,
+	// from 0 for a single flat colour to 8
+	Entropy float64
+}
+
+// File decodes the JPEG or PNG image at path and measures it
+func File(path string) (Metrics, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Metrics{}, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return Metrics{}, fmt.Errorf("failed to decode '%s': %w", path, err)
+	}
+	return Measure(img), nil
+}
+
+// Measure computes the metrics of an image
+func Measure(img image.Image) Metrics {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return Metrics{}
+	}
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > measureWidth {
+		height = max(1, height*measureWidth/width)
+		width = measureWidth
+	}
+	pixels := imagehash.Thumbnail(img, width, height)
+
+	var metrics Metrics
+	var histogram [256]int
+	for _, value := range pixels {
+		metrics.Luminance += value
+		histogram[min(255, int(value))]++
+	}
+	metrics.Luminance /= float64(len(pixels))
+
+	for _, count := range histogram {
+		if count > 0 {
+			p := float64(count) / float64(len(pixels))
+			metrics.Entropy -= p * math.Log2(p)
+		}
+	}
+
+	// 4-neighbour Laplacian over the pixels that have all their neighbours
+	if width >= 3 && height >= 3 {
+		var sum, sumSquares float64
+		for y := 1; y < height-1; y++ {
+			for x := 1; x < width-1; x++ {
+				i := y*width + x
+				laplacian := pixels[i-width] + pixels[i+width] + pixels[i-1] + pixels[i+1] - 4*pixels[i]
+				sum += laplacian
+				sumSquares += laplacian * laplacian
+			}
+		}
+		n := float64((width - 2) * (height - 2))
+		mean := sum / n
+		metrics.Sharpness = sumSquares/n - mean*mean
+	}
+	return metrics
+}
+
+// Thresholds are the limits a frame must keep to be analyzed, a zero
+// threshold is not checked
+type Thresholds struct {
+	MinSharpness float64
+	MinLuminance float64
+	MaxLuminance float64
+	MinEntropy   float64
+}
+
+// Check returns why a frame with the given metrics is not worth analyzing,
+// or an empty string if it is. Brightness is checked first since black and
+// white frames are also flat and blurred.
+func (t Thresholds) Check(m Metrics) string {
+	switch {
+	case t.MinLuminance > 0 && m.Luminance < t.MinLuminance:
+		return fmt.Sprintf("black frame, mean luminance %.1f below %.1f", m.Luminance, t.MinLuminance)
+	case t.MaxLuminance > 0 && m.Luminance > t.MaxLuminance:
+		return fmt.Sprintf("washed out frame, mean luminance %.1f above %.1f", m.Luminance, t.MaxLuminance)
+	case t.MinEntropy > 0 && m.Entropy < t.MinEntropy:
+		return fmt.Sprintf("low information frame, entropy %.2f bits below %.2f", m.Entropy, t.MinEntropy)
+	case t.MinSharpness > 0 && m.Sharpness < t.MinSharpness:
+		return fmt.Sprintf("blurred frame, sharpness %.1f below %.1f", m.Sharpness, t.MinSharpness)
+	}
+	return ""
+}
@@ -0,0 +1,173 @@
+package quality
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// filled returns a width x height image with every pixel set by value
+func filled(width, height int, value func(x, y int) uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetGray(x, y, color.Gray{Y: value(x, y)})
+		}
+	}
+	return img
+}
+
+// checkerboard alternates black and white squares of the given size
+func checkerboard(width, height, size int) *image.Gray {
+	return filled(width, height, func(x, y int) uint8 {
+		if (x/size+y/size)%2 == 0 {
+			return 0
+		}
+		return 255
+	})
+}
+
+func TestMeasure(t *testing.T) {
+	tests := []struct {
+		name string
+		img  image.Image
+		want Metrics
+	}{
+		{
+			name: "flat grey",
+			img:  filled(32, 32, func(x, y int) uint8 { return 100 }),
+			want: Metrics{Luminance: 100},
+		},
+		{
+			name: "black",
+			img:  filled(32, 32, func(x, y int) uint8 { return 0 }),
+			want: Metrics{},
+		},
+		{
+			// Every interior pixel has a Laplacian of +-4*255
+			name: "checkerboard",
+			img:  checkerboard(10, 10, 1),
+			want: Metrics{Sharpness: 1020 * 1020, Luminance: 127.5, Entropy: 1},
+		},
+		{
+			// Measured at 640 pixels wide, where the 2x2 squares become single pixels
+			name: "large checkerboard",
+			img:  checkerboard(1280, 720, 2),
+			want: Metrics{Sharpness: 1020 * 1020, Luminance: 127.5, Entropy: 1},
+		},
+		{
+			// The Laplacian is zero away from the edge and +-255 next to it
+			name: "halves",
+			img: filled(10, 10, func(x, y int) uint8 {
+				if x < 5 {
+					return 0
+				}
+				return 255
+			}),
+			want: Metrics{Sharpness: 255.0 * 255 / 4, Luminance: 127.5, Entropy: 1},
+		},
+		{
+			name: "four levels",
+			img:  filled(4, 4, func(x, y int) uint8 { return uint8(x * 60) }),
+			want: Metrics{Luminance: 90, Entropy: 2},
+		},
+		{
+			// Too small for the Laplacian
+			name: "single row",
+			img:  filled(8, 1, func(x, y int) uint8 { return uint8(x % 2 * 200) }),
+			want: Metrics{Luminance: 100, Entropy: 1},
+		},
+		{
+			name: "empty",
+			img:  image.NewGray(image.Rect(0, 0, 0, 0)),
+			want: Metrics{},
+		},
+	}
+	for _, tt := range tests {
+		got := Measure(tt.img)
+		if !near(got.Sharpness, tt.want.Sharpness) || !near(got.Luminance, tt.want.Luminance) || !near(got.Entropy, tt.want.Entropy) {
+			t.Errorf("%s: Measure = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// near reports whether two metrics are equal up to rounding
+func near(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-6*max(1, math.Abs(b))
+}
+
+func TestMeasureBlur(t *testing.T) {
+	sharp := checkerboard(64, 64, 8)
+	blurred := filled(64, 64, func(x, y int) uint8 {
+		// The same squares with a soft edge
+		value := 127.5 + 127.5*math.Sin(math.Pi*float64(x)/8)*math.Sin(math.Pi*float64(y)/8)
+		return uint8(value)
+	})
+	if s, b := Measure(sharp).Sharpness, Measure(blurred).Sharpness; s <= 10*b {
+		t.Errorf("sharpness of sharp edges %.1f not well above that of soft ones %.1f", s, b)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "frame.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, checkerboard(10, 10, 1)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	metrics, err := File(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Metrics{Sharpness: 1020 * 1020, Luminance: 127.5, Entropy: 1}); metrics != want {
+		t.Errorf("File = %+v, want %+v", metrics, want)
+	}
+
+	if _, err := File(filepath.Join(dir, "missing.png")); err == nil {
+		t.Error("File of a missing image: expected an error")
+	}
+	garbage := filepath.Join(dir, "garbage.png")
+	os.WriteFile(garbage, []byte("not an image"), 0644)
+	if _, err := File(garbage); err == nil {
+		t.Error("File of an undecodable image: expected an error")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	thresholds := Thresholds{MinSharpness: 30, MinLuminance: 20, MaxLuminance: 240, MinEntropy: 0.5}
+	tests := []struct {
+		name       string
+		thresholds Thresholds
+		metrics    Metrics
+		want       string // prefix of the problem, empty when the frame passes
+	}{
+		{"good", thresholds, Metrics{Sharpness: 100, Luminance: 120, Entropy: 6}, ""},
+		{"at the limits", thresholds, Metrics{Sharpness: 30, Luminance: 20, Entropy: 0.5}, ""},
+		{"black", thresholds, Metrics{Sharpness: 100, Luminance: 5, Entropy: 6}, "black frame, mean luminance 5.0 below 20.0"},
+		{"white", thresholds, Metrics{Sharpness: 100, Luminance: 250, Entropy: 6}, "washed out frame, mean luminance 250.0 above 240.0"},
+		{"blank", thresholds, Metrics{Sharpness: 100, Luminance: 120, Entropy: 0.2}, "low information frame, entropy 0.20 bits below 0.50"},
+		{"blurred", thresholds, Metrics{Sharpness: 12.5, Luminance: 120, Entropy: 6}, "blurred frame, sharpness 12.5 below 30.0"},
+		// A black frame is flat and blurred too, brightness is reported
+		{"black and flat", thresholds, Metrics{}, "black frame"},
+		{"blank and blurred", thresholds, Metrics{Luminance: 120}, "low information frame"},
+		// Zero thresholds are not checked
+		{"no thresholds", Thresholds{}, Metrics{}, ""},
+		{"only max luminance", Thresholds{MaxLuminance: 200}, Metrics{Luminance: 201}, "washed out frame"},
+	}
+	for _, tt := range tests {
+		got := tt.thresholds.Check(tt.metrics)
+		if (tt.want == "") != (got == "") || !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%s: Check = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/bdougie/vision/internal/analyzer"
+	"github.com/bdougie/vision/internal/config"
+	"github.com/bdougie/vision/internal/events"
+	"github.com/bdougie/vision/internal/extractor"
+	"github.com/bdougie/vision/internal/models"
+	"github.com/bdougie/vision/internal/storage"
+)
+
+// maxQueuedJobs is how many jobs may wait for a worker before submissions are refused
+const maxQueuedJobs = 256
+
+// eventHistory is how many events of a job are kept for clients that connect late
+const eventHistory = 5000
+
+var (
+	// errQueueFull is returned when too many jobs are waiting
+	errQueueFull = errors.New("too many queued jobs, try again later")
+
+	// errJobNotFound is returned for an unknown job id
+	errJobNotFound = errors.New("job not found")
+
+	// errJobEnded is returned when cancelling a job that has already ended
+	errJobEnded = errors.New("job has already ended")
+)
+
+// jobQueue keeps the submitted jobs in memory and hands queued ones to
+// workers. Finished jobs are kept for a while without their events.
+type jobQueue struct {
+	mu     sync.Mutex
+	jobs   map[string]*models.Job
+	order  []string
+	queued chan string
+
+	// events holds the event bus of every job that has not ended
+	events map[string]*events.Bus
+
+	// cancels stops the running jobs
+	cancels map[string]context.CancelFunc
+
+	// videos serializes jobs analyzing the same video, they share a frame directory
+	videos map[string]*sync.Mutex
+
+	// uploads holds the directory of jobs analyzing an uploaded video, it is
+	// removed once the job has ended
+	uploads map[string]string
+
+	// retention is how long finished jobs are kept, keep how many at most
+	retention time.Duration
+	keep      int
+	logger    logr.Logger
+}
+
+func newJobQueue(cfg config.ServerConfig, logger logr.Logger) *jobQueue {
+	return &jobQueue{
+		jobs:      make(map[string]*models.Job),
+		queued:    make(chan string, maxQueuedJobs),
+		events:    make(map[string]*events.Bus),
+		cancels:   make(map[string]context.CancelFunc),
+		videos:    make(map[string]*sync.Mutex),
+		uploads:   make(map[string]string),
+		retention: cfg.JobRetention,
+		keep:      cfg.FinishedJobs,
+		logger:    logger,
+	}
+}
+
+// submit queues an analysis of the video at path. uploadDir is the directory
+// of an uploaded video, removed with the video once the job has ended.
+func (q *jobQueue) submit(path, uploadDir string, opts models.JobOptions) (models.Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return models.Job{}, err
+	}
+	job := &models.Job{
+		ID:        id,
+		State:     models.JobQueued,
+		VideoPath: path,
+		Options:   opts,
+		CreatedAt: time.Now(),
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.queued <- id:
+	default:
+		return models.Job{}, errQueueFull
+	}
+	q.jobs[id] = job
+	q.events[id] = events.NewBus(eventHistory)
+	if uploadDir != "" {
+		q.uploads[id] = uploadDir
+	}
+	q.order = append(q.order, id)
+	q.prune(job.CreatedAt)
+	return *job, nil
+}
+
+// get returns a copy of a job
+func (q *jobQueue) get(id string) (models.Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return models.Job{}, false
+	}
+	return *job, true
+}
+
+// list returns copies of all jobs, newest first
+func (q *jobQueue) list() []models.Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune(time.Now())
+	jobs := make([]models.Job, 0, len(q.order))
+	for i := len(q.order) - 1; i >= 0; i-- {
+		jobs = append(jobs, *q.jobs[q.order[i]])
+	}
+	return jobs
+}
+
+// bus returns the event bus of a job, or nil once the job has ended
+func (q *jobQueue) bus(id string) *events.Bus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.events[id]
+}
+
+// update changes a job under the lock
+func (q *jobQueue) update(id string, change func(job *models.Job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job, ok := q.jobs[id]; ok {
+		change(job)
+	}
+}
+
+// cancel stops a job. A queued job ends right away, a running one once its
+// analysis has stored the frames finished so far.
+func (q *jobQueue) cancel(id string) (models.Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return models.Job{}, errJobNotFound
+	}
+
+	switch job.State {
+	case models.JobQueued:
+		finished := time.Now()
+		job.State = models.JobCancelled
+		job.FinishedAt = &finished
+		q.events[id].Publish(events.Event{Type: events.JobFinished, Error: "job cancelled", Cancelled: true})
+		q.end(id)
+	case models.JobRunning:
+		q.cancels[id]()
+	default:
+		return *job, errJobEnded
+	}
+	return *job, nil
+}
+
+// lockVideo waits until no other job works on the video with this key
+func (q *jobQueue) lockVideo(key string) func() {
+	q.mu.Lock()
+	lock, ok := q.videos[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		q.videos[key] = lock
+	}
+	q.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// work runs queued jobs on n workers until ctx is cancelled
+func (q *jobQueue) work(ctx context.Context, n int, run func(ctx context.Context, id string) error) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-q.queued:
+					jobCtx, cancel := context.WithCancel(ctx)
+					if !q.start(id, cancel) {
+						cancel()
+						continue
+					}
+
+					err := run(jobCtx, id)
+					cancelled := jobCtx.Err() != nil
+					cancel()
+
+					finished := time.Now()
+					q.update(id, func(job *models.Job) {
+						switch {
+						case err == nil:
+							job.State = models.JobSucceeded
+						case cancelled:
+							job.State = models.JobCancelled
+							job.Error = err.Error()
+						default:
+							job.State = models.JobFailed
+							job.Error = err.Error()
+						}
+						job.FinishedAt = &finished
+						delete(q.cancels, id)
+						q.end(id)
+					})
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// end lets go of what a job no longer needs once it has ended: its event bus,
+// whose subscribers receive what was published so far, and its upload. It
+// is called with the lock held.
+func (q *jobQueue) end(id string) {
+	if bus, ok := q.events[id]; ok {
+		bus.Close()
+		delete(q.events, id)
+	}
+	if dir, ok := q.uploads[id]; ok {
+		if err := os.RemoveAll(dir); err != nil {
+			q.logger.Error(err, "failed to remove upload", "job", id, "dir", dir)
+		}
+		delete(q.uploads, id)
+	}
+	q.prune(time.Now())
+}
+
+// prune forgets the finished jobs that ended longer than the retention ago
+// and the oldest ones beyond the number kept. It is called with the lock held.
+func (q *jobQueue) prune(now time.Time) {
+	finished := 0
+	expired := make(map[string]bool)
+	for i := len(q.order) - 1; i >= 0; i-- {
+		job := q.jobs[q.order[i]]
+		if job.FinishedAt == nil {
+			continue
+		}
+		finished++
+		if finished > q.keep || now.Sub(*job.FinishedAt) > q.retention {
+			expired[job.ID] = true
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	order := q.order[:0]
+	for _, id := range q.order {
+		if expired[id] {
+			delete(q.jobs, id)
+			continue
+		}
+		order = append(order, id)
+	}
+	q.order = order
+}
+
+// start marks a queued job as running with the function cancelling it, it
+// reports false for a job cancelled while it was queued
+func (q *jobQueue) start(id string, cancel context.CancelFunc) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok || job.State != models.JobQueued {
+		return false
+	}
+	started := time.Now()
+	job.State = models.JobRunning
+	job.StartedAt = &started
+	q.cancels[id] = cancel
+	return true
+}
+
+func newJobID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// runJob runs a job and logs how it ended
+func (s *Server) runJob(ctx context.Context, id string) error {
+	// The processor reports the end of its run, failures before it started are reported here
+	bus := s.jobs.bus(id)
+	finished := false
+	publisher := events.PublisherFunc(func(event events.Event) {
+		if event.Progress != nil {
+			progress := *event.Progress
+			s.jobs.update(id, func(job *models.Job) { job.Progress = progress })
+		}
+		if event.Extraction != nil {
+			extraction := *event.Extraction
+			s.jobs.update(id, func(job *models.Job) { job.Extraction = &extraction })
+		}
+		if event.Type == events.JobFinished {
+			finished = true
+		}
+		bus.Publish(event)
+	})
+
+	if err := s.analyze(ctx, id, publisher); err != nil {
+		if !finished {
+			bus.Publish(events.Event{Type: events.JobFinished, Error: err.Error(), Cancelled: ctx.Err() != nil})
+		}
+		if ctx.Err() != nil {
+			s.logger.Info("job cancelled", "job", id)
+		} else {
+			s.logger.Error(err, "job failed", "job", id)
+		}
+		return err
+	}
+	s.logger.Info("job finished", "job", id)
+	return nil
+}
+
+// analyze processes the video of a job with the configured storage and provider
+func (s *Server) analyze(ctx context.Context, id string, publisher events.Publisher) error {
+	job, ok := s.jobs.get(id)
+	if !ok {
+		return fmt.Errorf("unknown job %s", id)
+	}
+
+	video, err := extractor.ProbeVideo(ctx, job.VideoPath)
+	if err != nil {
+		return fmt.Errorf("failed to probe video: %w", err)
+	}
+	s.jobs.update(id, func(job *models.Job) { job.Video = video })
+
+	unlock := s.jobs.lockVideo(video.Key())
+	defer unlock()
+
+	var store storage.Storage
+	if s.cfg.Storage.Backend == config.BackendPostgres {
+		pgStorage, err := storage.NewPostgresStorage(ctx, s.cfg.Postgres(), *video, s.embedder)
+		if err != nil {
+			return fmt.Errorf("failed to open PostgreSQL storage: %w", err)
+		}
+		defer pgStorage.Close()
+		store = pgStorage
+	} else if s.cfg.Storage.Backend == config.BackendSQLite {
+		sqliteStorage, err := storage.NewSQLiteStorage(ctx, s.cfg.Storage.SQLite.Path, *video, s.embedder)
+		if err != nil {
+			return fmt.Errorf("failed to open SQLite storage: %w", err)
+		}
+		defer sqliteStorage.Close()
+		store = sqliteStorage
+	} else {
+		store = storage.NewFileStorage(s.cfg.Storage.OutputDir, video.Key(), s.embedder)
+	}
+
+	opts := s.cfg.ProcessorOptions()
+	opts.Resume = true
+	opts.Force = job.Options.Force
+	opts.RetryFailed = job.Options.RetryFailed
+	opts.Extract.StartMs = job.Options.StartMs
+	opts.Extract.EndMs = job.Options.EndMs
+	opts.Timestamps = job.Options.TimestampsMs
+	opts.Events = publisher
+	opts.Logger = s.logger.WithValues("job", id)
+	processor, err := analyzer.NewProcessor(s.provider, store, opts)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("job started", "job", id, "video", video.Name, "key", video.Key())
+	return processor.ProcessVideo(ctx, video, s.cfg.Storage.OutputDir)
+}
@@ -0,0 +1,683 @@
+// Package server exposes analysis jobs, stored videos and search over a JSON HTTP API
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/bdougie/vision/internal/analyzer"
+	"github.com/bdougie/vision/internal/config"
+	"github.com/bdougie/vision/internal/embeddings"
+	"github.com/bdougie/vision/internal/events"
+	"github.com/bdougie/vision/internal/models"
+	"github.com/bdougie/vision/internal/storage"
+)
+
+// shutdownTimeout is how long open requests may take once the server stops
+const shutdownTimeout = 10 * time.Second
+
+// Options wires the server to the configured backends
+type Options struct {
+	Config   *config.Config
+	Provider analyzer.VisionProvider
+
+	// Embedder is used by PostgreSQL storage, nil for file storage
+	Embedder embeddings.Embedder
+
+	Catalog storage.Catalog
+
+	Logger logr.Logger
+}
+
+// Server runs analysis jobs and answers API requests
+type Server struct {
+	cfg      *config.Config
+	provider analyzer.VisionProvider
+	embedder embeddings.Embedder
+	catalog  storage.Catalog
+	logger   logr.Logger
+	jobs     *jobQueue
+
+	// stopping is closed when the server shuts down, ending event streams
+	stopping chan struct{}
+}
+
+// New creates a server, ListenAndServe starts it
+func New(opts Options) *Server {
+	return &Server{
+		cfg:      opts.Config,
+		provider: opts.Provider,
+		embedder: opts.Embedder,
+		catalog:  opts.Catalog,
+		logger:   opts.Logger,
+		jobs:     newJobQueue(opts.Config.Server, opts.Logger),
+		stopping: make(chan struct{}),
+	}
+}
+
+// ListenAndServe serves the API on the configured address and runs jobs
+// until ctx is cancelled, then shuts down gracefully
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	httpServer := &http.Server{
+		Addr:              s.cfg.Server.Listen,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	httpServer.RegisterOnShutdown(func() { close(s.stopping) })
+
+	workCtx, stopWork := context.WithCancel(ctx)
+	defer stopWork()
+	workersDone := make(chan struct{})
+	go func() {
+		s.jobs.work(workCtx, s.cfg.Server.Jobs, s.runJob)
+		close(workersDone)
+	}()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		s.logger.Info("listening", "address", s.cfg.Server.Listen)
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err = httpServer.Shutdown(shutdownCtx)
+	}
+	stopWork()
+	<-workersDone
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Handler routes the API endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("POST /api/jobs", s.handleSubmitJob)
+	mux.HandleFunc("GET /api/jobs", s.handleListJobs)
+	mux.HandleFunc("GET /api/jobs/{id}", s.handleGetJob)
+	mux.HandleFunc("GET /api/jobs/{id}/events", s.handleJobEvents)
+	mux.HandleFunc("POST /api/jobs/{id}/cancel", s.handleCancelJob)
+	mux.HandleFunc("GET /api/videos", s.handleListVideos)
+	mux.HandleFunc("GET /api/videos/{video}", s.handleGetVideo)
+	mux.HandleFunc("GET /api/videos/{video}/frames", s.handleListFrames)
+	mux.HandleFunc("GET /api/videos/{video}/frames/{number}", s.handleGetFrame)
+	mux.HandleFunc("GET /api/videos/{video}/frames/{number}/image", s.handleFrameImage)
+	mux.HandleFunc("GET /api/search", s.handleSearch)
+	mux.HandleFunc("POST /api/search", s.handleSearch)
+	return mux
+}
+
+// httpError is an error with the status code it is reported with
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string { return e.err.Error() }
+
+func errorf(status int, format string, args ...any) error {
+	return &httpError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError reports err as {"error": "..."} with a status derived from it
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	var httpErr *httpError
+	switch {
+	case errors.As(err, &httpErr):
+		status = httpErr.status
+	case errors.Is(err, storage.ErrVideoNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, storage.ErrAmbiguousVideo), errors.Is(err, storage.ErrInvalidSearch):
+		status = http.StatusBadRequest
+	}
+	if status == http.StatusInternalServerError {
+		s.logger.Error(err, "request failed", "method", r.Method, "path", r.URL.Path)
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// jobRequest is the JSON body submitting a video by path
+type jobRequest struct {
+	Path string `json:"path"`
+	models.JobOptions
+}
+
+// handleSubmitJob queues a video given by path as JSON, or uploaded as the
+// "video" field of a multipart form
+func (s *Server) handleSubmitJob(w http.ResponseWriter, r *http.Request) {
+	var path string
+	var opts models.JobOptions
+	var err error
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		path, opts, err = s.receiveUpload(w, r)
+	} else {
+		var req jobRequest
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+			err = errorf(http.StatusBadRequest, "invalid job request: %v", err)
+		} else {
+			path, err = s.checkVideoPath(req.Path)
+			opts = req.JobOptions
+		}
+	}
+	if err == nil {
+		err = checkJobOptions(opts)
+	}
+
+	// An uploaded video is removed when its job ends, or right away when
+	// there is no job
+	var uploadDir string
+	if mediaType == "multipart/form-data" && path != "" {
+		uploadDir = filepath.Dir(path)
+	}
+	var job models.Job
+	if err == nil {
+		job, err = s.jobs.submit(path, uploadDir, opts)
+		if errors.Is(err, errQueueFull) {
+			err = errorf(http.StatusServiceUnavailable, "%v", err)
+		}
+	}
+	if err != nil {
+		if uploadDir != "" {
+			os.RemoveAll(uploadDir)
+		}
+		s.writeError(w, r, err)
+		return
+	}
+	s.logger.Info("job queued", "job", job.ID, "path", path)
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// checkJobOptions rejects a part of a video that cannot be extracted
+func checkJobOptions(opts models.JobOptions) error {
+	if len(opts.TimestampsMs) > 0 && (opts.StartMs > 0 || opts.EndMs > 0) {
+		return errorf(http.StatusBadRequest, "timestamps_ms cannot be combined with start_ms or end_ms")
+	}
+	if opts.StartMs < 0 || opts.EndMs < 0 || slices.ContainsFunc(opts.TimestampsMs, func(ms int64) bool { return ms < 0 }) {
+		return errorf(http.StatusBadRequest, "positions in the video must not be negative")
+	}
+	if opts.EndMs > 0 && opts.EndMs <= opts.StartMs {
+		return errorf(http.StatusBadRequest, "end_ms must be after start_ms")
+	}
+	return nil
+}
+
+// checkVideoPath resolves a submitted path and makes sure it may be read
+func (s *Server) checkVideoPath(path string) (string, error) {
+	if path == "" {
+		return "", errorf(http.StatusBadRequest, "no video path given")
+	}
+	resolved, err := filepath.Abs(path)
+	if err == nil {
+		resolved, err = filepath.EvalSymlinks(resolved)
+	}
+	if err != nil {
+		return "", errorf(http.StatusBadRequest, "cannot read video '%s'", path)
+	}
+
+	if len(s.cfg.Server.AllowedDirs) > 0 {
+		allowed := false
+		for _, dir := range s.cfg.Server.AllowedDirs {
+			if inside(dir, resolved) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "", errorf(http.StatusForbidden, "video '%s' is outside the allowed directories", path)
+		}
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil || info.IsDir() {
+		return "", errorf(http.StatusBadRequest, "cannot read video '%s'", path)
+	}
+	return resolved, nil
+}
+
+// inside reports whether path is within dir, following symlinks in dir
+func inside(dir, path string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// receiveUpload streams the uploaded video into its own directory under the
+// upload directory, keeping the file name as the video name
+func (s *Server) receiveUpload(w http.ResponseWriter, r *http.Request) (string, models.JobOptions, error) {
+	var opts models.JobOptions
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.Server.MaxUploadMB<<20)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return "", opts, errorf(http.StatusBadRequest, "invalid upload: %v", err)
+	}
+
+	var path string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", opts, errorf(http.StatusBadRequest, "invalid upload: %v", err)
+		}
+
+		switch part.FormName() {
+		case "video":
+			if path != "" {
+				return "", opts, errorf(http.StatusBadRequest, "only one video may be uploaded per job")
+			}
+			if path, err = s.saveUpload(part); err != nil {
+				return "", opts, err
+			}
+		case "force", "retry_failed":
+			value, _ := io.ReadAll(io.LimitReader(part, 16))
+			enabled, err := strconv.ParseBool(strings.TrimSpace(string(value)))
+			if err != nil {
+				return "", opts, errorf(http.StatusBadRequest, "invalid %s value '%s'", part.FormName(), value)
+			}
+			if part.FormName() == "force" {
+				opts.Force = enabled
+			} else {
+				opts.RetryFailed = enabled
+			}
+		case "start_ms", "end_ms", "timestamps_ms":
+			value, _ := io.ReadAll(io.LimitReader(part, 4096))
+			var positions []int64
+			for _, field := range strings.Split(string(value), ",") {
+				ms, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+				if err != nil || ms < 0 {
+					return "", opts, errorf(http.StatusBadRequest, "invalid %s value '%s'", part.FormName(), value)
+				}
+				positions = append(positions, ms)
+			}
+			switch {
+			case part.FormName() == "timestamps_ms":
+				opts.TimestampsMs = positions
+			case len(positions) != 1:
+				return "", opts, errorf(http.StatusBadRequest, "invalid %s value '%s'", part.FormName(), value)
+			case part.FormName() == "start_ms":
+				opts.StartMs = positions[0]
+			default:
+				opts.EndMs = positions[0]
+			}
+		}
+	}
+	if path == "" {
+		return "", opts, errorf(http.StatusBadRequest, "no video uploaded, send it as the 'video' form field")
+	}
+	return path, opts, nil
+}
+
+// saveUpload writes one uploaded file and returns its path
+func (s *Server) saveUpload(part *multipart.Part) (string, error) {
+	name := filepath.Base(part.FileName())
+	if name == "" || name == "." || name == string(filepath.Separator) {
+		name = "upload"
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(s.cfg.Server.UploadDir, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	path, err := filepath.Abs(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to store upload: %w", err)
+	}
+	_, copyErr := io.Copy(file, part)
+	closeErr := file.Close()
+	if copyErr != nil || closeErr != nil {
+		os.RemoveAll(dir)
+		var tooLarge *http.MaxBytesError
+		if errors.As(copyErr, &tooLarge) {
+			return "", errorf(http.StatusRequestEntityTooLarge, "upload exceeds %d MB", s.cfg.Server.MaxUploadMB)
+		}
+		return "", fmt.Errorf("failed to store upload: %w", errors.Join(copyErr, closeErr))
+	}
+	return path, nil
+}
+
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.jobs.list())
+}
+
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		s.writeError(w, r, errorf(http.StatusNotFound, "job '%s' not found", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleCancelJob stops a queued or running job, a running job reports
+// "cancelled" once the frames it finished are stored
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	job, err := s.jobs.cancel(id)
+	switch {
+	case errors.Is(err, errJobNotFound):
+		err = errorf(http.StatusNotFound, "job '%s' not found", id)
+	case errors.Is(err, errJobEnded):
+		err = errorf(http.StatusConflict, "job '%s' has already %s", id, job.State)
+	}
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	s.logger.Info("job cancel requested", "job", id)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// keepAliveInterval is how often an idle event stream sends a comment so
+// proxies do not close it
+const keepAliveInterval = 15 * time.Second
+
+// handleJobEvents streams the events of a job as Server-Sent Events. The
+// stream starts with a "job" event holding the job, replays the events so
+// far (or those after Last-Event-ID) and ends with another "job" event once
+// the job has finished.
+func (s *Server) handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var after int64
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		after, _ = strconv.ParseInt(lastID, 10, 64)
+	}
+
+	// The events of a job are let go once it has ended, its stream then only
+	// holds the job
+	var stream <-chan events.Event
+	if bus := s.jobs.bus(id); bus != nil {
+		var cancel func()
+		stream, cancel = bus.Subscribe(after)
+		defer cancel()
+	} else {
+		ended := make(chan events.Event)
+		close(ended)
+		stream = ended
+	}
+	job, found := s.jobs.get(id)
+	flusher, ok := w.(http.Flusher)
+	if !found || !ok {
+		s.writeError(w, r, errorf(http.StatusNotFound, "job '%s' not found", id))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	writeEvent(w, "", "job", job)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.stopping:
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event, ok := <-stream:
+			if !ok {
+				// The job ended, or the client fell behind and has to reconnect
+				if ended, _ := s.jobs.get(id); ended.FinishedAt != nil && job.FinishedAt == nil {
+					writeEvent(w, "", "job", ended)
+				}
+				flusher.Flush()
+				return
+			}
+			writeEvent(w, strconv.FormatInt(event.Seq, 10), string(event.Type), event)
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes one Server-Sent Event with a JSON payload
+func writeEvent(w io.Writer, id, name string, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+}
+
+func (s *Server) handleListVideos(w http.ResponseWriter, r *http.Request) {
+	videos, err := s.catalog.Videos(r.Context())
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	if videos == nil {
+		videos = []models.VideoSummary{}
+	}
+	writeJSON(w, http.StatusOK, videos)
+}
+
+func (s *Server) handleGetVideo(w http.ResponseWriter, r *http.Request) {
+	video, err := s.catalog.FindVideo(r.Context(), r.PathValue("video"))
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, video)
+}
+
+func (s *Server) handleListFrames(w http.ResponseWriter, r *http.Request) {
+	_, frames, err := s.videoFrames(r)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, frames)
+}
+
+func (s *Server) handleGetFrame(w http.ResponseWriter, r *http.Request) {
+	_, frame, err := s.videoFrame(r)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, frame)
+}
+
+// handleFrameImage serves the extracted image of a frame
+func (s *Server) handleFrameImage(w http.ResponseWriter, r *http.Request) {
+	video, frame, err := s.videoFrame(r)
+	if err == nil && video.Key() == "" {
+		err = errorf(http.StatusNotFound, "video '%s' has no extracted frames", video.Name)
+	}
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	// Extracted frames live in the output directory for every backend
+	path := filepath.Join(s.cfg.Storage.OutputDir, video.Key(), filepath.Base(frame.Frame))
+	if _, err := os.Stat(path); err != nil {
+		s.writeError(w, r, errorf(http.StatusNotFound, "image of frame %d is no longer on disk", frame.FrameNumber))
+		return
+	}
+	http.ServeFile(w, r, path)
+}
+
+// videoFrames looks up the video of the request and its stored frames
+func (s *Server) videoFrames(r *http.Request) (*models.VideoMetadata, []models.FrameRecord, error) {
+	video, err := s.catalog.FindVideo(r.Context(), r.PathValue("video"))
+	if err != nil {
+		return nil, nil, err
+	}
+	frames, err := s.catalog.Frames(r.Context(), *video)
+	if err != nil {
+		return nil, nil, err
+	}
+	if frames == nil {
+		frames = []models.FrameRecord{}
+	}
+	return video, frames, nil
+}
+
+// videoFrame looks up the frame of the request by frame number
+func (s *Server) videoFrame(r *http.Request) (*models.VideoMetadata, *models.FrameRecord, error) {
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if err != nil {
+		return nil, nil, errorf(http.StatusBadRequest, "invalid frame number '%s'", r.PathValue("number"))
+	}
+	video, frames, err := s.videoFrames(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range frames {
+		if frames[i].FrameNumber == number {
+			return video, &frames[i], nil
+		}
+	}
+	return nil, nil, errorf(http.StatusNotFound, "frame %d of %s is not stored", number, video.Name)
+}
+
+// handleSearch runs a search given as query parameters (GET) or as a JSON
+// models.SearchRequest (POST). Videos may be given as any video reference.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var req models.SearchRequest
+	var err error
+	if r.Method == http.MethodPost {
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+			err = errorf(http.StatusBadRequest, "invalid search request: %v", err)
+		}
+	} else {
+		req, err = searchParams(r)
+	}
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	for i, ref := range req.Videos {
+		video, err := s.catalog.FindVideo(r.Context(), ref)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		req.Videos[i] = video.Fingerprint
+	}
+
+	page, err := s.catalog.Search(r.Context(), req)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// searchParams reads a search request from the URL query: q, mode, limit,
+// video (repeatable), name, tag, object, since, until, from_ms, to_ms,
+// min_score, offset and cursor
+func searchParams(r *http.Request) (models.SearchRequest, error) {
+	query := r.URL.Query()
+	req := models.SearchRequest{
+		Query:     query.Get("q"),
+		Mode:      models.SearchMode(query.Get("mode")),
+		Videos:    query["video"],
+		VideoName: query.Get("name"),
+		Tags:      query["tag"],
+		Objects:   query["object"],
+		Cursor:    query.Get("cursor"),
+	}
+
+	var problems []string
+	parseInt := func(key string, dst *int64) {
+		if value := query.Get(key); value != "" {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s must be an integer", key))
+			}
+			*dst = n
+		}
+	}
+	parseTime := func(key string) *time.Time {
+		value := query.Get(key)
+		if value == "" {
+			return nil
+		}
+		for _, layout := range []string{"2006-01-02", time.RFC3339} {
+			if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+				return &t
+			}
+		}
+		problems = append(problems, fmt.Sprintf("%s must be YYYY-MM-DD or an RFC 3339 timestamp", key))
+		return nil
+	}
+
+	var limit, offset int64
+	parseInt("limit", &limit)
+	parseInt("offset", &offset)
+	parseInt("from_ms", &req.FromMs)
+	parseInt("to_ms", &req.ToMs)
+	req.Limit, req.Offset = int(limit), int(offset)
+	req.StoredAfter = parseTime("since")
+	req.StoredBefore = parseTime("until")
+	if value := query.Get("min_score"); value != "" {
+		score, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			problems = append(problems, "min_score must be a number")
+		}
+		req.MinScore = score
+	}
+
+	if len(problems) > 0 {
+		return req, errorf(http.StatusBadRequest, "invalid search parameters: %s", strings.Join(problems, ", "))
+	}
+	return req, nil
+}
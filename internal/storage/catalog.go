@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bdougie/vision/internal/embeddings"
+	"github.com/bdougie/vision/internal/models"
+)
+
+// ErrVideoNotFound is returned when no stored video matches a reference
+var ErrVideoNotFound = errors.New("video not found")
+
+// ErrAmbiguousVideo is returned when a reference matches several stored videos
+var ErrAmbiguousVideo = errors.New("ambiguous video reference")
+
+// Catalog reads and manages stored videos without analyzing anything
+type Catalog interface {
+	// Videos lists every stored video with frame counts
+	Videos(ctx context.Context) ([]models.VideoSummary, error)
+
+	// FindVideo resolves a fingerprint, a fingerprint prefix such as the
+	// video key, or a video name to a stored video
+	FindVideo(ctx context.Context, ref string) (*models.VideoMetadata, error)
+
+	// Frames returns every stored frame of a video ordered by frame number
+	Frames(ctx context.Context, video models.VideoMetadata) ([]models.FrameRecord, error)
+
+	// DeleteVideo removes a video and everything stored about it
+	DeleteVideo(ctx context.Context, video models.VideoMetadata) error
+
+	// Searcher runs searches across every stored video
+	Searcher
+}
+
+// Reindexer is implemented by database catalogs that can embed their stored
+// analyses again
+type Reindexer interface {
+	// Reindex embeds the analyses of one video, or of all videos when video
+	// is nil, and returns how many were updated
+	Reindex(ctx context.Context, video *models.VideoMetadata) (int, error)
+}
+
+// matchVideo picks the video a reference points to. Fingerprint prefixes win
+// over names; a reference matching several videos is an error.
+func matchVideo(videos []models.VideoSummary, ref string) (*models.VideoMetadata, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("no video given")
+	}
+
+	var byFingerprint, byName []models.VideoMetadata
+	for _, summary := range videos {
+		video := summary.Video
+		if video.Fingerprint != "" && strings.HasPrefix(video.Fingerprint, strings.ToLower(ref)) {
+			byFingerprint = append(byFingerprint, video)
+		}
+		if video.Name == ref {
+			byName = append(byName, video)
+		}
+	}
+
+	for _, matches := range [][]models.VideoMetadata{byFingerprint, byName} {
+		switch len(matches) {
+		case 0:
+			continue
+		case 1:
+			return &matches[0], nil
+		default:
+			keys := make([]string, len(matches))
+			for i, video := range matches {
+				keys[i] = video.Key()
+			}
+			return nil, fmt.Errorf("%w: '%s' matches %d videos (%s), use a longer key", ErrAmbiguousVideo, ref, len(matches), strings.Join(keys, ", "))
+		}
+	}
+	return nil, fmt.Errorf("%w: '%s'", ErrVideoNotFound, ref)
+}
+
+// FileCatalog implements Catalog over the video directories written by FileStorage
+type FileCatalog struct {
+	outputDir string
+	embedder  embeddings.Embedder
+}
+
+// NewFileCatalog creates a catalog of the videos stored under outputDir.
+// Search queries are embedded with embedder, which may be nil when only text
+// search is used.
+func NewFileCatalog(outputDir string, embedder embeddings.Embedder) *FileCatalog {
+	return &FileCatalog{outputDir: outputDir, embedder: embedder}
+}
+
+// Videos lists the directories holding a video.json
+func (c *FileCatalog) Videos(ctx context.Context) ([]models.VideoSummary, error) {
+	videos, err := c.readVideos()
+	if err != nil {
+		return nil, err
+	}
+	for i := range videos {
+		summary := &videos[i]
+		frames, err := c.Frames(ctx, summary.Video)
+		if err != nil {
+			return nil, err
+		}
+		for _, frame := range frames {
+			summary.Frames++
+			if frame.Content != "" {
+				summary.Analyzed++
+			}
+			if frame.Status == models.FrameFailed || frame.Status == models.FrameSkipped {
+				summary.Failed++
+			}
+		}
+	}
+	return videos, nil
+}
+
+// readVideos reads the video.json of every video directory, without frame
+// counts, ordered by when the video was stored
+func (c *FileCatalog) readVideos() ([]models.VideoSummary, error) {
+	entries, err := os.ReadDir(c.outputDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read output directory: %w", err)
+	}
+
+	var videos []models.VideoSummary
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		video, err := readVideo(filepath.Join(c.outputDir, entry.Name(), videoFileName))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		videos = append(videos, models.VideoSummary{Video: video.VideoMetadata, CreatedAt: video.CreatedAt})
+	}
+
+	sort.Slice(videos, func(i, j int) bool { return videos[i].CreatedAt.Before(videos[j].CreatedAt) })
+	return videos, nil
+}
+
+// FindVideo implements Catalog
+func (c *FileCatalog) FindVideo(ctx context.Context, ref string) (*models.VideoMetadata, error) {
+	videos, err := c.Videos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return matchVideo(videos, ref)
+}
+
+// Frames merges the stored results and frame statuses of a video, frames
+// skipped as duplicates get the results of the frame they duplicate
+func (c *FileCatalog) Frames(ctx context.Context, video models.VideoMetadata) ([]models.FrameRecord, error) {
+	store := NewFileStorage(c.outputDir, video.Key(), nil)
+	results, err := store.loadResults()
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := store.FrameStatuses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]*models.FrameRecord)
+	record := func(frame string) *models.FrameRecord {
+		if r, ok := records[frame]; ok {
+			return r
+		}
+		r := &models.FrameRecord{Frame: frame}
+		r.FrameNumber, _ = frameNumber(frame)
+		records[frame] = r
+		return r
+	}
+	for frame, result := range results {
+		r := record(frame)
+		r.TimestampMs = result.TimestampMs
+		r.Content = result.Content
+		r.Structured = result.Structured
+	}
+	for frame, status := range statuses {
+		r := record(frame)
+		r.TimestampMs = status.TimestampMs
+		r.Status = status.Status
+		r.Reason = status.Reason
+		r.Attempts = status.Attempts
+		r.Hash = status.Hash
+		r.DuplicateOf = status.DuplicateOf
+	}
+
+	// Near-duplicates share the description of the frame they look like
+	for _, r := range records {
+		original, ok := results[r.DuplicateOf]
+		if r.DuplicateOf != "" && r.Content == "" && ok {
+			r.Content = original.Content
+			r.Structured = original.Structured
+		}
+	}
+
+	frames := make([]models.FrameRecord, 0, len(records))
+	for _, r := range records {
+		frames = append(frames, *r)
+	}
+	sort.Slice(frames, func(i, j int) bool { return frames[i].Frame < frames[j].Frame })
+	return frames, nil
+}
+
+// DeleteVideo removes the video directory with its frames and results
+func (c *FileCatalog) DeleteVideo(ctx context.Context, video models.VideoMetadata) error {
+	if video.Key() == "" {
+		return fmt.Errorf("video '%s' has no fingerprint", video.Name)
+	}
+	if err := os.RemoveAll(filepath.Join(c.outputDir, video.Key())); err != nil {
+		return fmt.Errorf("failed to delete video '%s': %w", video.Name, err)
+	}
+	return nil
+}
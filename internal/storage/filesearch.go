@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/bdougie/vision/internal/models"
+)
+
+// Search implements Searcher over the analysis_results.json of every stored
+// video, with the modes, filters and paging of PostgresStorage.Search.
+// Semantic search compares the query with the embedding stored with each
+// result; text search scores descriptions by BM25, see keywordScores.
+func (c *FileCatalog) Search(ctx context.Context, req models.SearchRequest) (*models.SearchPage, error) {
+	plan, err := planSearch(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var query []float32
+	if plan.req.Mode != models.SearchText {
+		if c.embedder == nil {
+			return nil, fmt.Errorf("%w: %s search needs an embedder", ErrInvalidSearch, plan.req.Mode)
+		}
+		vectors, err := c.embedder.Embed(ctx, []string{plan.req.Query})
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate query embedding: %w", err)
+		}
+		query = vectors[0]
+	}
+
+	candidates, err := c.searchCandidates(plan.req.Videos)
+	if err != nil {
+		return nil, err
+	}
+	if query != nil {
+		for _, candidate := range candidates {
+			if candidate.Embedding != nil && len(candidate.Embedding) != len(query) {
+				return nil, fmt.Errorf("%s holds embeddings with %d dimensions but the embedding model produces %d, "+
+					"use the original embedding model or run 'visionanalyzer reindex' to rebuild them",
+					candidate.Hit.VideoName, len(candidate.Embedding), len(query))
+			}
+		}
+	}
+
+	var textScores map[int]float64
+	if plan.req.Mode != models.SearchSemantic {
+		textScores = keywordScores(plan.req.Query, candidates)
+	}
+	return rankCandidates(plan, candidates, query, textScores), nil
+}
+
+// searchCandidates loads the stored results of the given videos, or of all
+// videos when fingerprints is empty
+func (c *FileCatalog) searchCandidates(fingerprints []string) ([]frameCandidate, error) {
+	videos, err := c.readVideos()
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []frameCandidate
+	for _, summary := range videos {
+		video := summary.Video
+		if len(fingerprints) > 0 && !slices.Contains(fingerprints, video.Fingerprint) {
+			continue
+		}
+		results, err := NewFileStorage(c.outputDir, video.Key(), nil).loadResults()
+		if err != nil {
+			return nil, err
+		}
+		for frame, result := range results {
+			number, _ := frameNumber(frame)
+			candidates = append(candidates, frameCandidate{
+				ID: candidateID(video.Fingerprint, frame),
+				Hit: models.FrameSearchResult{
+					VideoFingerprint: video.Fingerprint,
+					VideoName:        video.Name,
+					FrameNumber:      number,
+					FramePath:        frame,
+					TimestampMs:      result.TimestampMs,
+					Description:      result.Content,
+					VideoDurationMs:  video.DurationMs,
+				},
+				Structured: result.Structured,
+				Embedding:  result.Embedding,
+				StoredAt:   summary.CreatedAt,
+			})
+		}
+	}
+
+	// Ids key the text scores and the fused ranking, so they must be unique.
+	// A frame whose hash collides with another takes the next free id in
+	// frame order.
+	slices.SortFunc(candidates, func(a, b frameCandidate) int {
+		return cmp.Or(strings.Compare(a.Hit.VideoFingerprint, b.Hit.VideoFingerprint),
+			strings.Compare(a.Hit.FramePath, b.Hit.FramePath))
+	})
+	taken := make(map[int]bool, len(candidates))
+	for i := range candidates {
+		for taken[candidates[i].ID] {
+			candidates[i].ID++
+		}
+		taken[candidates[i].ID] = true
+	}
+	return candidates, nil
+}
+
+// candidateID derives a stable id for a frame from the 64 bit hash of its
+// video and file name, it orders results of equal score and positions cursors
+func candidateID(fingerprint, frame string) int {
+	h := fnv.New64a()
+	h.Write([]byte(fingerprint + "/" + frame))
+	return int(h.Sum64())
+}
+
+// Reindex embeds the stored results again with the current embedder, for one
+// video or for all of them when video is nil, and returns how many were
+// updated. Results stored without an embedding become searchable by meaning.
+func (c *FileCatalog) Reindex(ctx context.Context, video *models.VideoMetadata) (int, error) {
+	if c.embedder == nil {
+		return 0, fmt.Errorf("no embedder configured")
+	}
+
+	var videos []models.VideoMetadata
+	if video != nil {
+		videos = append(videos, *video)
+	} else {
+		summaries, err := c.readVideos()
+		if err != nil {
+			return 0, err
+		}
+		for _, summary := range summaries {
+			videos = append(videos, summary.Video)
+		}
+	}
+
+	updated := 0
+	for _, video := range videos {
+		count, err := NewFileStorage(c.outputDir, video.Key(), c.embedder).reindex(ctx)
+		updated += count
+		if err != nil {
+			return updated, fmt.Errorf("failed to reindex %s: %w", video.Name, err)
+		}
+		fmt.Printf("Reindexed %d analyses of %s\n", count, video.Name)
+	}
+	return updated, nil
+}
+
+// reindex embeds every stored result again and rewrites the results snapshot
+func (s *FileStorage) reindex(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results, err := s.loadResults()
+	if err != nil {
+		return 0, err
+	}
+	resultList := make([]storedResult, 0, len(results))
+	for _, result := range results {
+		resultList = append(resultList, result)
+	}
+	if len(resultList) == 0 {
+		return 0, nil
+	}
+	sort.Slice(resultList, func(i, j int) bool { return resultList[i].Frame < resultList[j].Frame })
+
+	for start := 0; start < len(resultList); start += reindexBatchSize {
+		end := min(start+reindexBatchSize, len(resultList))
+		contents := make([]string, 0, end-start)
+		for _, result := range resultList[start:end] {
+			contents = append(contents, result.Content)
+		}
+		vectors, err := s.embedder.Embed(ctx, contents)
+		if err != nil {
+			return 0, fmt.Errorf("failed to embed analyses: %w", err)
+		}
+		for i, vector := range vectors {
+			resultList[start+i].Embedding = vector
+		}
+	}
+
+	// Nothing is written until every result has its new embedding
+	if err := s.compact(resultsFileName, resultsLogFileName, resultList); err != nil {
+		return 0, err
+	}
+	return len(resultList), nil
+}
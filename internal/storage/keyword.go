@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// webQuery is a search query as websearch_to_tsquery reads it for
+// PostgreSQL: words and "quoted phrases" must all match, "or" between them
+// accepts either and a leading - excludes a word or phrase
+type webQuery struct {
+	// groups must all match, a group matches when any of its terms does
+	groups [][]string
+
+	// excluded terms must not match
+	excluded []string
+}
+
+// parseWebQuery splits a query into its terms. Terms without a letter or
+// digit are dropped.
+func parseWebQuery(query string) webQuery {
+	var parsed webQuery
+	pendingOr := false
+
+	add := func(term string, negate bool) {
+		if strings.IndexFunc(term, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+			return
+		}
+		switch {
+		case negate:
+			parsed.excluded = append(parsed.excluded, term)
+		case pendingOr && len(parsed.groups) > 0:
+			last := len(parsed.groups) - 1
+			parsed.groups[last] = append(parsed.groups[last], term)
+		default:
+			parsed.groups = append(parsed.groups, []string{term})
+		}
+		pendingOr = false
+	}
+
+	for rest := strings.TrimSpace(query); rest != ""; rest = strings.TrimSpace(rest) {
+		negate := false
+		if strings.HasPrefix(rest, "-") {
+			negate = true
+			rest = rest[1:]
+		}
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			add(rest[1:end+1], negate)
+			rest = rest[min(end+2, len(rest)):]
+			continue
+		}
+
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end < 0 {
+			end = len(rest)
+		}
+		word := rest[:end]
+		rest = rest[end:]
+		if !negate && strings.EqualFold(word, "or") {
+			pendingOr = true
+			continue
+		}
+		add(word, negate)
+	}
+	return parsed
+}
+
+// keywordTokens splits text into lower case words in singular form, so
+// "dogs" and "boxes" match "dog" and "box"
+func keywordTokens(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = singular(word)
+	}
+	return words
+}
+
+// singular strips regular English plural endings
+func singular(word string) string {
+	if len(word) <= 3 {
+		return word
+	}
+	if stem, ok := strings.CutSuffix(word, "ies"); ok {
+		return stem + "y"
+	}
+	for _, suffix := range []string{"sses", "xes", "zes", "ches", "shes"} {
+		if strings.HasSuffix(word, suffix) {
+			return word[:len(word)-2]
+		}
+	}
+	for _, suffix := range []string{"ss", "us", "is"} {
+		if strings.HasSuffix(word, suffix) {
+			return word
+		}
+	}
+	return strings.TrimSuffix(word, "s")
+}
+
+// countPhrase returns how often the token sequence phrase occurs in tokens
+func countPhrase(tokens, phrase []string) int {
+	count := 0
+	for i := 0; i+len(phrase) <= len(tokens); i++ {
+		match := true
+		for j, word := range phrase {
+			if tokens[i+j] != word {
+				match = false
+				break
+			}
+		}
+		if match {
+			count++
+		}
+	}
+	return count
+}
+
+// BM25 parameters of keywordScores, the usual defaults
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// keywordScores scores the candidates matching a web search style query by
+// BM25 over their descriptions, keyed by candidate id. Term statistics come
+// from all candidates, like a full-text index of every stored analysis.
+func keywordScores(query string, candidates []frameCandidate) map[int]float64 {
+	parsed := parseWebQuery(query)
+	scores := make(map[int]float64)
+	if len(parsed.groups) == 0 || len(candidates) == 0 {
+		return scores
+	}
+
+	docs := make([][]string, len(candidates))
+	totalLength := 0
+	for i, candidate := range candidates {
+		docs[i] = keywordTokens(candidate.Hit.Description)
+		totalLength += len(docs[i])
+	}
+	averageLength := max(float64(totalLength)/float64(len(docs)), 1)
+
+	// Every distinct term with the number of descriptions containing it
+	phrases := make(map[string][]string)
+	for _, group := range parsed.groups {
+		for _, term := range group {
+			phrases[term] = keywordTokens(term)
+		}
+	}
+	for _, term := range parsed.excluded {
+		phrases[term] = keywordTokens(term)
+	}
+	documents := make(map[string]int)
+	counts := make([]map[string]int, len(docs))
+	for i, doc := range docs {
+		counts[i] = make(map[string]int, len(phrases))
+		for term, phrase := range phrases {
+			if n := countPhrase(doc, phrase); n > 0 {
+				counts[i][term] = n
+				documents[term]++
+			}
+		}
+	}
+
+	for i, candidate := range candidates {
+		matched := true
+		for _, group := range parsed.groups {
+			found := false
+			for _, term := range group {
+				if counts[i][term] > 0 {
+					found = true
+					break
+				}
+			}
+			if !found {
+				matched = false
+				break
+			}
+		}
+		for _, term := range parsed.excluded {
+			if counts[i][term] > 0 {
+				matched = false
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		// The idf variant that stays positive for terms in most descriptions
+		score := 0.0
+		norm := bm25K1 * (1 - bm25B + bm25B*float64(len(docs[i]))/averageLength)
+		for _, group := range parsed.groups {
+			for _, term := range group {
+				tf := float64(counts[i][term])
+				if tf == 0 {
+					continue
+				}
+				n := float64(documents[term])
+				idf := math.Log(1 + (float64(len(docs))-n+0.5)/(n+0.5))
+				score += idf * tf * (bm25K1 + 1) / (tf + norm)
+			}
+		}
+		scores[candidate.ID] = score
+	}
+	return scores
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/bdougie/vision/internal/models"
+)
+
+func TestParseWebQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  webQuery
+	}{
+		{"", webQuery{}},
+		{"dog", webQuery{groups: [][]string{{"dog"}}}},
+		{"  red   car ", webQuery{groups: [][]string{{"red"}, {"car"}}}},
+		{`"red car" parked`, webQuery{groups: [][]string{{"red car"}, {"parked"}}}},
+		{"cat or dog", webQuery{groups: [][]string{{"cat", "dog"}}}},
+		{"cat OR dog or bird fish", webQuery{groups: [][]string{{"cat", "dog", "bird"}, {"fish"}}}},
+		{"dog -cat", webQuery{groups: [][]string{{"dog"}}, excluded: []string{"cat"}}},
+		{`dog -"black cat"`, webQuery{groups: [][]string{{"dog"}}, excluded: []string{"black cat"}}},
+		// A leading or has nothing to join and is dropped
+		{"or dog", webQuery{groups: [][]string{{"dog"}}}},
+		// An excluded or is a word
+		{"dog -or", webQuery{groups: [][]string{{"dog"}}, excluded: []string{"or"}}},
+		// An unterminated quote runs to the end
+		{`"red car`, webQuery{groups: [][]string{{"red car"}}}},
+		// Terms without letters or digits are dropped
+		{`- "" ... dog`, webQuery{groups: [][]string{{"dog"}}}},
+	}
+	for _, tt := range tests {
+		if got := parseWebQuery(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseWebQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSingular(t *testing.T) {
+	tests := []struct {
+		word, want string
+	}{
+		{"dogs", "dog"},
+		{"cars", "car"},
+		{"puppies", "puppy"},
+		{"boxes", "box"},
+		{"buzzes", "buzz"},
+		{"glasses", "glass"},
+		{"benches", "bench"},
+		{"dishes", "dish"},
+		{"glass", "glass"},
+		{"bus", "bus"},
+		{"cactus", "cactus"},
+		{"analysis", "analysis"},
+		// Short words are left alone
+		{"gas", "gas"},
+		{"is", "is"},
+		{"dog", "dog"},
+	}
+	for _, tt := range tests {
+		if got := singular(tt.word); got != tt.want {
+			t.Errorf("singular(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+// keywordCorpus is a fixed set of descriptions, candidate ids are their
+// positions plus one
+var keywordCorpus = []string{
+	"A red car parked in front of a house.",
+	"A blue car drives past two dogs.",
+	"A dog sleeps on the sofa next to a black cat.",
+	"Two red cars, a red truck and a red bicycle.",
+	"An empty street at night.",
+}
+
+func keywordCandidates(descriptions []string) []frameCandidate {
+	candidates := make([]frameCandidate, len(descriptions))
+	for i, description := range descriptions {
+		candidates[i] = frameCandidate{ID: i + 1, Hit: models.FrameSearchResult{Description: description}}
+	}
+	return candidates
+}
+
+func TestKeywordScores(t *testing.T) {
+	candidates := keywordCandidates(keywordCorpus)
+	tests := []struct {
+		query string
+		want  []int // matching candidate ids, best first
+	}{
+		{"red", []int{4, 1}},
+		// One car each, the shorter description ranks higher
+		{"car", []int{2, 1, 4}},
+		{"cars", []int{2, 1, 4}},
+		{"red car", []int{4, 1}},
+		{`"red car"`, []int{1, 4}},
+		{`"car parked"`, []int{1}},
+		{"dog", []int{2, 3}},
+		{"cat or truck", []int{4, 3}},
+		{"dog -cat", []int{2}},
+		{`car -"blue car"`, []int{1, 4}},
+		{"giraffe", nil},
+		{"", nil},
+		{"...", nil},
+	}
+	for _, tt := range tests {
+		scores := keywordScores(tt.query, candidates)
+		if len(scores) != len(tt.want) {
+			t.Errorf("keywordScores(%q) = %v, want matches %v", tt.query, scores, tt.want)
+			continue
+		}
+		for i, id := range tt.want {
+			score, ok := scores[id]
+			if !ok || score <= 0 {
+				t.Errorf("keywordScores(%q): candidate %d scored %v, want a positive score", tt.query, id, score)
+			}
+			if i > 0 && score >= scores[tt.want[i-1]] {
+				t.Errorf("keywordScores(%q): candidate %d scored %v, not below candidate %d with %v",
+					tt.query, id, score, tt.want[i-1], scores[tt.want[i-1]])
+			}
+		}
+	}
+}
+
+func TestKeywordScoresBM25(t *testing.T) {
+	// Two descriptions of average length with the term in one of them: the
+	// idf is ln(1 + 1.5/1.5) and tf*(k1+1)/(tf+k1) is 1
+	scores := keywordScores("red", keywordCandidates([]string{"red car", "blue car"}))
+	if want := math.Log(2); len(scores) != 1 || math.Abs(scores[1]-want) > 1e-12 {
+		t.Errorf("keywordScores = %v, want {1: %v}", scores, want)
+	}
+
+	// A term in every description still scores above zero
+	scores = keywordScores("car", keywordCandidates([]string{"red car", "blue car"}))
+	if len(scores) != 2 || scores[1] <= 0 || scores[1] != scores[2] {
+		t.Errorf("keywordScores = %v, want two equal positive scores", scores)
+	}
+
+	// The same count of the term weighs less in a longer description
+	scores = keywordScores("dog", keywordCandidates([]string{"a dog", "a dog on a long walk through the park"}))
+	if scores[1] <= scores[2] {
+		t.Errorf("keywordScores = %v, want the short description first", scores)
+	}
+}
+
+func TestKeywordScoresEmptyCorpus(t *testing.T) {
+	if scores := keywordScores("dog", nil); len(scores) != 0 {
+		t.Errorf("keywordScores = %v, want none", scores)
+	}
+}
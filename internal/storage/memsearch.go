@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bdougie/vision/internal/models"
+)
+
+// frameCandidate is a stored analysis considered by a search that ranks
+// frames in memory instead of in the database
+type frameCandidate struct {
+	// ID keys the scores of a candidate and orders candidates with equal
+	// scores, it must be unique and stable across searches
+	ID int
+
+	// Hit holds the fields identifying the frame, scores are filled in by ranking
+	Hit models.FrameSearchResult
+
+	Structured *models.FrameAnalysis
+	Embedding  []float32
+
+	// StoredAt is when the video was first stored
+	StoredAt time.Time
+}
+
+// matches applies the filters of a search request, with the same meaning as
+// the conditions added by queryBuilder.filter
+func (c *frameCandidate) matches(req models.SearchRequest) bool {
+	if len(req.Videos) > 0 && !slices.Contains(req.Videos, c.Hit.VideoFingerprint) {
+		return false
+	}
+	if req.VideoName != "" && !strings.Contains(strings.ToLower(c.Hit.VideoName), strings.ToLower(req.VideoName)) {
+		return false
+	}
+	if len(req.Tags) > 0 || len(req.Objects) > 0 {
+		if c.Structured == nil {
+			return false
+		}
+		for _, tag := range req.Tags {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if !slices.ContainsFunc(c.Structured.Tags, func(t string) bool { return strings.ToLower(t) == tag }) {
+				return false
+			}
+		}
+		for _, object := range req.Objects {
+			object = strings.ToLower(strings.TrimSpace(object))
+			if !slices.ContainsFunc(c.Structured.Objects, func(o models.DetectedObject) bool { return strings.ToLower(o.Name) == object }) {
+				return false
+			}
+		}
+	}
+	if req.FromMs > 0 && c.Hit.TimestampMs < req.FromMs {
+		return false
+	}
+	if req.ToMs > 0 && c.Hit.TimestampMs >= req.ToMs {
+		return false
+	}
+	if req.StoredAfter != nil && c.StoredAt.Before(*req.StoredAfter) {
+		return false
+	}
+	if req.StoredBefore != nil && !c.StoredAt.Before(*req.StoredBefore) {
+		return false
+	}
+	return true
+}
+
+// cosineSimilarity returns the cosine of the angle between two vectors of the
+// same length, zero when either is all zeros
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// rankedHit is a candidate with its scores
+type rankedHit struct {
+	id  int
+	hit models.FrameSearchResult
+}
+
+// sortHits orders hits by score, then id, the order cursors rely on
+func sortHits(hits []rankedHit) {
+	slices.SortFunc(hits, func(a, b rankedHit) int {
+		switch {
+		case a.hit.Score > b.hit.Score:
+			return -1
+		case a.hit.Score < b.hit.Score:
+			return 1
+		}
+		return cmp.Compare(a.id, b.id)
+	})
+}
+
+// rankCandidates runs a planned search over candidates in memory. query is
+// the embedded query for semantic and hybrid searches, only candidates with
+// an embedding of the same length take part in the semantic ranking.
+// textScores holds the full-text score of every candidate matching the query
+// for text and hybrid searches, keyed by candidate id. Paging, scores and
+// totals behave like PostgresStorage.Search.
+func rankCandidates(plan *searchPlan, candidates []frameCandidate, query []float32, textScores map[int]float64) *models.SearchPage {
+	var semantic, lexical []rankedHit
+	for _, candidate := range candidates {
+		if !candidate.matches(plan.req) {
+			continue
+		}
+		if query != nil && len(candidate.Embedding) == len(query) {
+			hit := candidate.Hit
+			hit.Similarity = cosineSimilarity(candidate.Embedding, query)
+			hit.Score = hit.Similarity
+			semantic = append(semantic, rankedHit{id: candidate.ID, hit: hit})
+		}
+		if score, ok := textScores[candidate.ID]; ok {
+			hit := candidate.Hit
+			hit.TextScore = score
+			hit.Score = score
+			lexical = append(lexical, rankedHit{id: candidate.ID, hit: hit})
+		}
+	}
+	sortHits(semantic)
+	sortHits(lexical)
+
+	var hits []rankedHit
+	switch plan.req.Mode {
+	case models.SearchSemantic:
+		hits = semantic
+	case models.SearchText:
+		hits = lexical
+	case models.SearchHybrid:
+		n := plan.pool
+		fused := make(map[int]*rankedHit)
+		fuse := func(ranking []rankedHit, apply func(fused *rankedHit, ranked rankedHit, rank int)) {
+			for i, ranked := range ranking[:min(n, len(ranking))] {
+				hit, ok := fused[ranked.id]
+				if !ok {
+					hit = &rankedHit{id: ranked.id, hit: ranked.hit}
+					hit.hit.Score = 0
+					fused[ranked.id] = hit
+				}
+				hit.hit.Score += 1.0 / float64(rrfK+i+1)
+				apply(hit, ranked, i+1)
+			}
+		}
+		fuse(semantic, func(fused *rankedHit, ranked rankedHit, rank int) {
+			fused.hit.Similarity = ranked.hit.Similarity
+			fused.hit.SemanticRank = rank
+		})
+		fuse(lexical, func(fused *rankedHit, ranked rankedHit, rank int) {
+			fused.hit.TextScore = ranked.hit.TextScore
+			fused.hit.TextRank = rank
+		})
+		for _, hit := range fused {
+			hits = append(hits, *hit)
+		}
+		sortHits(hits)
+	}
+
+	hits = slices.DeleteFunc(hits, func(hit rankedHit) bool { return hit.hit.Score < plan.req.MinScore })
+	page := &models.SearchPage{Results: []models.FrameSearchResult{}, Total: len(hits)}
+
+	start := plan.req.Offset
+	if cursor := plan.cursor; cursor != nil {
+		start = len(hits)
+		for i, hit := range hits {
+			if hit.hit.Score < cursor.Score || (hit.hit.Score == cursor.Score && hit.id > cursor.ID) {
+				start = i
+				break
+			}
+		}
+	}
+	if start > len(hits) {
+		start = len(hits)
+	}
+	end := min(start+plan.limit, len(hits))
+	for _, hit := range hits[start:end] {
+		page.Results = append(page.Results, hit.hit)
+	}
+
+	returned := plan.seen + len(page.Results)
+	if len(page.Results) == plan.limit && returned < page.Total {
+		last := hits[end-1]
+		page.NextCursor = plan.nextCursor(last.hit.Score, last.id, returned)
+	}
+	return page
+}
@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bdougie/vision/internal/models"
+)
+
+// rankCorpus is a fixed set of candidates with two dimensional embeddings,
+// the query [1 0] ranks them semantically by id
+func rankCorpus() []frameCandidate {
+	descriptions := []string{
+		"a red car on a bridge",
+		"a dog in the park",
+		"a red bicycle by a red door in a red wall",
+		"a cat on the sofa",
+		"a red car and a red truck",
+	}
+	candidates := make([]frameCandidate, len(descriptions))
+	for i, description := range descriptions {
+		angle := float64(i) * 0.3
+		candidates[i] = frameCandidate{
+			ID: i + 1,
+			Hit: models.FrameSearchResult{
+				VideoFingerprint: "video",
+				FrameNumber:      i + 1,
+				FramePath:        fmt.Sprintf("frame_%04d.jpg", i+1),
+				TimestampMs:      int64(i) * 1000,
+				Description:      description,
+			},
+			Embedding: []float32{float32(math.Cos(angle)), float32(math.Sin(angle))},
+		}
+	}
+	return candidates
+}
+
+// rank plans req and ranks candidates with text scores for its query
+func rank(t *testing.T, req models.SearchRequest, candidates []frameCandidate, query []float32) *models.SearchPage {
+	t.Helper()
+	plan, err := planSearch(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rankCandidates(plan, candidates, query, keywordScores(req.Query, candidates))
+}
+
+// frameNumbers lists the frame numbers of the results
+func frameNumbers(results []models.FrameSearchResult) []int {
+	numbers := []int{}
+	for _, result := range results {
+		numbers = append(numbers, result.FrameNumber)
+	}
+	return numbers
+}
+
+func TestRankCandidates(t *testing.T) {
+	query := []float32{1, 0}
+	tests := []struct {
+		name  string
+		req   models.SearchRequest
+		want  []int // frame numbers of the results
+		total int
+	}{
+		{"semantic", models.SearchRequest{Query: "red", Mode: models.SearchSemantic}, []int{1, 2, 3, 4, 5}, 5},
+		{"semantic limit", models.SearchRequest{Query: "red", Limit: 2}, []int{1, 2}, 5},
+		{"semantic offset", models.SearchRequest{Query: "red", Offset: 3}, []int{4, 5}, 5},
+		{"offset past the end", models.SearchRequest{Query: "red", Offset: 9}, []int{}, 5},
+		{"semantic min score", models.SearchRequest{Query: "red", MinScore: math.Cos(0.7)}, []int{1, 2, 3}, 3},
+		// Three reds in ten words, two in seven, one in six
+		{"text", models.SearchRequest{Query: "red", Mode: models.SearchText}, []int{3, 5, 1}, 3},
+		{"text filtered", models.SearchRequest{Query: "red", Mode: models.SearchText, FromMs: 1000, ToMs: 4000}, []int{3}, 1},
+		{"text no match", models.SearchRequest{Query: "giraffe", Mode: models.SearchText}, []int{}, 0},
+		// Frame 1 is first and third, frame 3 third and first: equal fused
+		// scores ordered by id. Frame 5 is fifth and second, frame 2 second only.
+		{"hybrid", models.SearchRequest{Query: "red", Mode: models.SearchHybrid}, []int{1, 3, 5, 2, 4}, 5},
+		{"hybrid min score", models.SearchRequest{Query: "red", Mode: models.SearchHybrid, MinScore: 0.02}, []int{1, 3, 5}, 3},
+	}
+	for _, tt := range tests {
+		page := rank(t, tt.req, rankCorpus(), query)
+		if got := frameNumbers(page.Results); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: results %v, want %v", tt.name, got, tt.want)
+		}
+		if page.Total != tt.total {
+			t.Errorf("%s: total %d, want %d", tt.name, page.Total, tt.total)
+		}
+	}
+}
+
+func TestRankCandidatesFusion(t *testing.T) {
+	page := rank(t, models.SearchRequest{Query: "red", Mode: models.SearchHybrid}, rankCorpus(), []float32{1, 0})
+	want := map[int]struct {
+		semantic, text int
+	}{
+		1: {1, 3},
+		2: {2, 0},
+		3: {3, 1},
+		4: {4, 0},
+		5: {5, 2},
+	}
+	for _, result := range page.Results {
+		ranks := want[result.FrameNumber]
+		if result.SemanticRank != ranks.semantic || result.TextRank != ranks.text {
+			t.Errorf("frame %d: ranks %d and %d, want %d and %d", result.FrameNumber,
+				result.SemanticRank, result.TextRank, ranks.semantic, ranks.text)
+		}
+		score := 0.0
+		for _, rank := range []int{ranks.semantic, ranks.text} {
+			if rank > 0 {
+				score += 1.0 / float64(rrfK+rank)
+			}
+		}
+		if math.Abs(result.Score-score) > 1e-12 {
+			t.Errorf("frame %d: fused score %v, want %v", result.FrameNumber, result.Score, score)
+		}
+		if (ranks.text > 0) != (result.TextScore > 0) || result.Similarity == 0 {
+			t.Errorf("frame %d: similarity %v and text score %v not carried over", result.FrameNumber, result.Similarity, result.TextScore)
+		}
+	}
+}
+
+func TestRankCandidatesMismatchedEmbeddings(t *testing.T) {
+	candidates := rankCorpus()
+	candidates[1].Embedding = []float32{1, 0, 0}
+	candidates[3].Embedding = nil
+	page := rank(t, models.SearchRequest{Query: "red"}, candidates, []float32{1, 0})
+	if got, want := frameNumbers(page.Results), []int{1, 3, 5}; !slices.Equal(got, want) {
+		t.Errorf("results %v, want %v", got, want)
+	}
+}
+
+func TestRankCandidatesCursor(t *testing.T) {
+	// Equal embeddings and descriptions give every frame the same scores, so
+	// only the id orders them. The ids run against the frame numbers.
+	var candidates []frameCandidate
+	for i := range 7 {
+		candidates = append(candidates, frameCandidate{
+			ID:        100 - i,
+			Hit:       models.FrameSearchResult{FrameNumber: i + 1, Description: "a red car"},
+			Embedding: []float32{1, 1},
+		})
+	}
+	candidates = append(candidates, frameCandidate{
+		ID:        1,
+		Hit:       models.FrameSearchResult{FrameNumber: 8, Description: "a red car and a red truck"},
+		Embedding: []float32{1, 0.5},
+	})
+	query := []float32{1, 1}
+
+	all := rank(t, models.SearchRequest{Query: "red", Limit: 100}, candidates, query)
+	if got, want := frameNumbers(all.Results), []int{7, 6, 5, 4, 3, 2, 1, 8}; !slices.Equal(got, want) {
+		t.Fatalf("results %v, want %v", got, want)
+	}
+
+	for _, mode := range []models.SearchMode{models.SearchSemantic, models.SearchText, models.SearchHybrid} {
+		for _, limit := range []int{1, 2, 3, 8} {
+			all := rank(t, models.SearchRequest{Query: "red", Mode: mode, Limit: 100}, candidates, query)
+			var paged []int
+			req := models.SearchRequest{Query: "red", Mode: mode, Limit: limit}
+			for pages := 0; ; pages++ {
+				if pages > len(candidates) {
+					t.Fatalf("%s limit %d: cursor does not advance", mode, limit)
+				}
+				page := rank(t, req, candidates, query)
+				if page.Total != all.Total {
+					t.Errorf("%s limit %d: page total %d, want %d", mode, limit, page.Total, all.Total)
+				}
+				paged = append(paged, frameNumbers(page.Results)...)
+				if page.NextCursor == "" {
+					break
+				}
+				req.Cursor = page.NextCursor
+			}
+			if want := frameNumbers(all.Results); !slices.Equal(paged, want) {
+				t.Errorf("%s limit %d: paged through %v, want %v", mode, limit, paged, want)
+			}
+		}
+	}
+}
+
+func TestRankCandidatesHybridPages(t *testing.T) {
+	// More candidates than the pool, ranked differently by meaning and text:
+	// the semantic ranking runs by id, the text ranking by repeated words
+	var candidates []frameCandidate
+	for i := range hybridCandidates + 50 {
+		angle := float64(i) * 0.005
+		candidates = append(candidates, frameCandidate{
+			ID:        i + 1,
+			Hit:       models.FrameSearchResult{FrameNumber: i + 1, Description: strings.Repeat("red ", 1+(i*7)%13) + "car"},
+			Embedding: []float32{float32(math.Cos(angle)), float32(math.Sin(angle))},
+		})
+	}
+	query := []float32{1, 0}
+	all := rank(t, models.SearchRequest{Query: "red", Mode: models.SearchHybrid, Limit: 1000}, candidates, query)
+	want := frameNumbers(all.Results)
+
+	for _, limit := range []int{1, 7, 50} {
+		var byCursor, byOffset []int
+		req := models.SearchRequest{Query: "red", Mode: models.SearchHybrid, Limit: limit}
+		for {
+			page := rank(t, req, candidates, query)
+			if page.Total != all.Total {
+				t.Fatalf("limit %d: page total %d, want %d", limit, page.Total, all.Total)
+			}
+			byCursor = append(byCursor, frameNumbers(page.Results)...)
+			if page.NextCursor == "" {
+				break
+			}
+			req.Cursor = page.NextCursor
+		}
+		for offset := 0; offset < all.Total; offset += limit {
+			page := rank(t, models.SearchRequest{Query: "red", Mode: models.SearchHybrid, Limit: limit, Offset: offset}, candidates, query)
+			byOffset = append(byOffset, frameNumbers(page.Results)...)
+		}
+		if !slices.Equal(byCursor, want) {
+			t.Errorf("limit %d: paged through %d results by cursor, want the %d of one page", limit, len(byCursor), len(want))
+		}
+		if !slices.Equal(byOffset, want) {
+			t.Errorf("limit %d: paged through %d results by offset, want the %d of one page", limit, len(byOffset), len(want))
+		}
+	}
+}
+
+func TestPlanSearch(t *testing.T) {
+	cursor := searchCursor{Score: 0.5, ID: 7, Seen: 20}.encode()
+	pooled := searchCursor{Score: 0.5, ID: 7, Seen: 20, Pool: 30}.encode()
+	tests := []struct {
+		name    string
+		req     models.SearchRequest
+		wantErr bool
+		mode    models.SearchMode
+		limit   int
+		seen    int
+		pool    int
+	}{
+		{name: "defaults", req: models.SearchRequest{Query: "dog"}, mode: models.SearchSemantic, limit: defaultSearchLimit, pool: hybridCandidates},
+		{name: "hybrid", req: models.SearchRequest{Query: "dog", Mode: models.SearchHybrid, Limit: 5, Offset: 10}, mode: models.SearchHybrid, limit: 5, seen: 10, pool: hybridCandidates},
+		{name: "cursor", req: models.SearchRequest{Query: "dog", Cursor: cursor}, mode: models.SearchSemantic, limit: defaultSearchLimit, seen: 20, pool: hybridCandidates},
+		// The pool of the first page is kept for the whole query
+		{name: "cursor with pool", req: models.SearchRequest{Query: "dog", Mode: models.SearchHybrid, Cursor: pooled}, mode: models.SearchHybrid, limit: defaultSearchLimit, seen: 20, pool: 30},
+		{name: "empty query", req: models.SearchRequest{Query: "  "}, wantErr: true},
+		{name: "unknown mode", req: models.SearchRequest{Query: "dog", Mode: "fuzzy"}, wantErr: true},
+		{name: "negative offset", req: models.SearchRequest{Query: "dog", Offset: -1}, wantErr: true},
+		{name: "cursor and offset", req: models.SearchRequest{Query: "dog", Cursor: cursor, Offset: 1}, wantErr: true},
+		{name: "malformed cursor", req: models.SearchRequest{Query: "dog", Cursor: "!!"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		plan, err := planSearch(tt.req)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidSearch) {
+				t.Errorf("%s: error %v, want ErrInvalidSearch", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if plan.req.Mode != tt.mode || plan.limit != tt.limit || plan.seen != tt.seen || plan.pool != tt.pool {
+			t.Errorf("%s: mode %s, limit %d, seen %d, pool %d, want %s, %d, %d, %d", tt.name,
+				plan.req.Mode, plan.limit, plan.seen, plan.pool, tt.mode, tt.limit, tt.seen, tt.pool)
+		}
+	}
+}
@@ -0,0 +1,418 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey is the advisory lock held while the schema changes, so
+// several processes starting at once apply each migration only once
+const migrationLockKey = 7_361_524_901
+
+// migrationFile matches NNNN_name.up.sql and NNNN_name.down.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+
+	// Checksum is the SHA-256 of Up, recorded when the migration is applied
+	// so later edits to an applied migration are noticed
+	Checksum string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+
+	// Unknown marks a version recorded in the database that this build does not ship
+	Unknown bool
+
+	// Checksum is the recorded checksum of an applied migration, empty for
+	// versions applied before checksums were recorded
+	Checksum string
+
+	// Modified marks an applied migration whose script changed since
+	Modified bool
+}
+
+// Migrations returns the embedded migrations ordered by version
+func Migrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, "migrations")
+}
+
+// loadMigrations reads the migration files in dir of fsys
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file '%s'", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, '%s' and '%s'", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		sum := sha256.Sum256([]byte(migration.Up))
+		migration.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Migrator applies and reverts the embedded migrations on one connection
+type Migrator struct {
+	conn       *pgx.Conn
+	migrations []Migration
+}
+
+// NewMigrator connects to the database and makes sure the schema_migrations
+// table exists
+func NewMigrator(ctx context.Context, config PostgresConfig) (*Migrator, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := pgx.Connect(ctx, config.connString())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	migrator := &Migrator{conn: conn, migrations: migrations}
+
+	// Concurrent CREATE TABLE IF NOT EXISTS can still collide, take the lock
+	unlock, err := migrator.lock(ctx)
+	if err != nil {
+		conn.Close(ctx)
+		return nil, err
+	}
+	defer unlock()
+
+	_, err = conn.Exec(ctx, `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at TIMESTAMPTZ NOT NULL,
+            checksum TEXT
+        );
+        ALTER TABLE schema_migrations ADD COLUMN IF NOT EXISTS checksum TEXT;
+    `)
+	if err != nil {
+		conn.Close(ctx)
+		return nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	return migrator, nil
+}
+
+// Close closes the database connection
+func (m *Migrator) Close(ctx context.Context) error {
+	return m.conn.Close(ctx)
+}
+
+// lock takes the migration advisory lock, waiting for other processes to finish
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	if _, err := m.conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return nil, fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	return func() {
+		// The lock also ends with the session if unlocking fails
+		m.conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	}, nil
+}
+
+// applied returns the applied versions with the time they were applied
+func (m *Migrator) applied(ctx context.Context) (map[int]MigrationStatus, error) {
+	rows, err := m.conn.Query(ctx, "SELECT version, name, applied_at, COALESCE(checksum, '') FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationStatus)
+	for rows.Next() {
+		var status MigrationStatus
+		var appliedAt time.Time
+		if err := rows.Scan(&status.Version, &status.Name, &appliedAt, &status.Checksum); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		status.AppliedAt = &appliedAt
+		applied[status.Version] = status
+	}
+	return applied, rows.Err()
+}
+
+// Status lists every known migration and any applied version this build does
+// not know about, ordered by version
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if done, ok := applied[migration.Version]; ok {
+			status.AppliedAt = done.AppliedAt
+			status.Checksum = done.Checksum
+			status.Modified = modified(migration, done)
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, unknown := range applied {
+		unknown.Unknown = true
+		statuses = append(statuses, unknown)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// modified reports whether an applied migration was recorded with another
+// checksum than its script has now
+func modified(migration Migration, applied MigrationStatus) bool {
+	return applied.Checksum != "" && applied.Checksum != migration.Checksum
+}
+
+// Up applies pending migrations in order, up to and including version target.
+// A target of zero applies all of them.
+func (m *Migrator) Up(ctx context.Context, target int) ([]Migration, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Building on a schema that differs from the shipped scripts would
+	// leave databases of the same version with different schemas
+	for _, migration := range m.migrations {
+		if done, ok := applied[migration.Version]; ok && modified(migration, done) {
+			return nil, fmt.Errorf("migration %d_%s was changed after it was applied, restore it and add a new migration instead",
+				migration.Version, migration.Name)
+		}
+	}
+
+	var done []Migration
+	for _, migration := range m.migrations {
+		if target > 0 && migration.Version > target {
+			break
+		}
+		if status, ok := applied[migration.Version]; ok {
+			// Versions applied before checksums were recorded adopt the current one
+			if status.Checksum == "" {
+				_, err := m.conn.Exec(ctx, "UPDATE schema_migrations SET checksum = $2 WHERE version = $1",
+					migration.Version, migration.Checksum)
+				if err != nil {
+					return done, fmt.Errorf("failed to record checksum of migration %d_%s: %w", migration.Version, migration.Name, err)
+				}
+			}
+			continue
+		}
+		err := m.run(ctx, migration.Up, func(tx pgx.Tx) error {
+			_, err := tx.Exec(ctx,
+				"INSERT INTO schema_migrations (version, name, applied_at, checksum) VALUES ($1, $2, $3, $4)",
+				migration.Version, migration.Name, time.Now(), migration.Checksum)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down reverts the given number of most recently applied migrations
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		err := m.run(ctx, migration.Down, func(tx pgx.Tx) error {
+			_, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("reverting migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// run executes a migration script and its bookkeeping in one transaction
+func (m *Migrator) run(ctx context.Context, script string, record func(pgx.Tx) error) error {
+	tx, err := m.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Migrate brings the schema to the latest version and sizes the embedding
+// column for vectors of the given dimension. It is safe to call from several
+// processes at once.
+func Migrate(ctx context.Context, config PostgresConfig, dimension int) error {
+	return migrate(ctx, config, dimension, false)
+}
+
+// MigrateDiscardingEmbeddings is Migrate for a switch to an embedding model of
+// another dimension: stored embeddings are dropped so they can be rebuilt
+func MigrateDiscardingEmbeddings(ctx context.Context, config PostgresConfig, dimension int) error {
+	return migrate(ctx, config, dimension, true)
+}
+
+func migrate(ctx context.Context, config PostgresConfig, dimension int, discard bool) error {
+	migrator, err := NewMigrator(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close(ctx)
+
+	applied, err := migrator.Up(ctx, 0)
+	if err != nil {
+		return err
+	}
+	for _, migration := range applied {
+		fmt.Printf("Applied migration %d_%s\n", migration.Version, migration.Name)
+	}
+
+	// The embedding dimension depends on the configured model, not on a
+	// fixed migration, so it is reconciled under the same lock afterwards
+	unlock, err := migrator.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return resizeEmbeddings(ctx, migrator.conn, dimension, discard)
+}
+
+// placeholderDimension is the size of the constant vectors stored before real
+// embedding models were used, they carry no meaning and may be discarded
+const placeholderDimension = 4
+
+// maxIndexedDimension is the largest vector pgvector's ivfflat index accepts
+const maxIndexedDimension = 2000
+
+// resizeEmbeddings makes analyses.embedding hold vectors of the given
+// dimension. Stored embeddings of another size are only dropped with discard.
+func resizeEmbeddings(ctx context.Context, conn *pgx.Conn, dimension int, discard bool) error {
+	// pgvector records the dimension as the type modifier of the column
+	var current int
+	err := conn.QueryRow(ctx, `
+        SELECT atttypmod FROM pg_attribute
+        WHERE attrelid = 'analyses'::regclass AND attname = 'embedding'
+    `).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to read embedding dimension: %w", err)
+	}
+
+	if current != dimension {
+		var embedded int
+		err = conn.QueryRow(ctx, "SELECT COUNT(*) FROM analyses WHERE embedding IS NOT NULL").Scan(&embedded)
+		if err != nil {
+			return fmt.Errorf("failed to count embeddings: %w", err)
+		}
+		if embedded > 0 && current != placeholderDimension && !discard {
+			return fmt.Errorf("database holds %d embeddings with %d dimensions but the embedding model produces %d, "+
+				"use the original embedding model or run 'visionanalyzer reindex' to rebuild them", embedded, current, dimension)
+		}
+
+		_, err = conn.Exec(ctx, fmt.Sprintf(`
+            DROP INDEX IF EXISTS idx_embedding_vector;
+            ALTER TABLE analyses ALTER COLUMN embedding TYPE vector(%[1]d) USING NULL::vector(%[1]d);
+        `, dimension))
+		if err != nil {
+			return fmt.Errorf("failed to resize embedding column: %w", err)
+		}
+		fmt.Printf("Resized embedding column from %d to %d dimensions\n", current, dimension)
+	}
+
+	// Larger vectors are searched without an index
+	if dimension <= maxIndexedDimension {
+		_, err = conn.Exec(ctx, `
+            CREATE INDEX IF NOT EXISTS idx_embedding_vector ON analyses USING ivfflat (embedding vector_cosine_ops) WITH (lists = 100)
+        `)
+		if err != nil {
+			return fmt.Errorf("failed to create embedding index: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	checksums := make(map[string]int)
+	for i, migration := range migrations {
+		// Versions run from 1 without gaps, a gap usually means a lost file
+		if migration.Version != i+1 {
+			t.Errorf("migration %d_%s at position %d, want version %d", migration.Version, migration.Name, i, i+1)
+		}
+		sum := sha256.Sum256([]byte(migration.Up))
+		if migration.Checksum != hex.EncodeToString(sum[:]) {
+			t.Errorf("migration %d_%s: checksum %s, want the SHA-256 of its up script", migration.Version, migration.Name, migration.Checksum)
+		}
+		if other, ok := checksums[migration.Checksum]; ok {
+			t.Errorf("migrations %d and %d have the same checksum", other, migration.Version)
+		}
+		checksums[migration.Checksum] = migration.Version
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(data string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(data)} }
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		versions []int
+		wantErr  string
+	}{
+		{
+			// Versions sort as numbers, not as file names
+			name: "ordered by version",
+			files: fstest.MapFS{
+				"m/10_late.up.sql": file("ten"), "m/10_late.down.sql": file("-ten"),
+				"m/9_early.up.sql": file("nine"), "m/9_early.down.sql": file("-nine"),
+				"m/0002_two.up.sql": file("two"), "m/0002_two.down.sql": file("-two"),
+			},
+			versions: []int{2, 9, 10},
+		},
+		{
+			name:    "missing down",
+			files:   fstest.MapFS{"m/0001_initial.up.sql": file("up")},
+			wantErr: "needs both an up and a down file",
+		},
+		{
+			name: "two names",
+			files: fstest.MapFS{
+				"m/0001_initial.up.sql": file("up"), "m/0001_first.down.sql": file("down"),
+			},
+			wantErr: "has two names",
+		},
+		{
+			name:    "unexpected file",
+			files:   fstest.MapFS{"m/README.md": file("notes")},
+			wantErr: "unexpected migration file",
+		},
+	}
+	for _, tt := range tests {
+		migrations, err := loadMigrations(tt.files, "m")
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var versions []int
+		for _, migration := range migrations {
+			versions = append(versions, migration.Version)
+		}
+		if !slices.Equal(versions, tt.versions) {
+			t.Errorf("%s: versions %v, want %v", tt.name, versions, tt.versions)
+		}
+	}
+}
+
+func TestMigrationModified(t *testing.T) {
+	migrations, err := loadMigrations(fstest.MapFS{
+		"m/0001_initial.up.sql":   {Data: []byte("CREATE TABLE videos (id SERIAL);")},
+		"m/0001_initial.down.sql": {Data: []byte("DROP TABLE videos;")},
+	}, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	migration := migrations[0]
+
+	edited := migration
+	edited.Up = "CREATE TABLE videos (id BIGSERIAL);"
+	sum := sha256.Sum256([]byte(edited.Up))
+	edited.Checksum = hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name      string
+		migration Migration
+		recorded  string
+		want      bool
+	}{
+		{"unchanged", migration, migration.Checksum, false},
+		{"edited", edited, migration.Checksum, true},
+		// Versions applied before checksums were recorded cannot be checked
+		{"not recorded", edited, "", false},
+	}
+	for _, tt := range tests {
+		applied := MigrationStatus{Version: 1, Name: "initial", Checksum: tt.recorded}
+		if got := modified(tt.migration, applied); got != tt.want {
+			t.Errorf("%s: modified = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
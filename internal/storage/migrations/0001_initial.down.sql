@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS analyses;
+DROP TABLE IF EXISTS frames;
+DROP TABLE IF EXISTS videos;
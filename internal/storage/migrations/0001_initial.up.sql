@@ -0,0 +1,31 @@
+-- Schema as created by the first releases. IF NOT EXISTS lets databases set
+-- up before migrations existed adopt this version unchanged.
+CREATE EXTENSION IF NOT EXISTS vector;
+
+CREATE TABLE IF NOT EXISTS videos (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    UNIQUE(name)
+);
+
+CREATE TABLE IF NOT EXISTS frames (
+    id SERIAL PRIMARY KEY,
+    video_id INTEGER REFERENCES videos(id) ON DELETE CASCADE,
+    frame_number INTEGER NOT NULL,
+    frame_path VARCHAR(255) NOT NULL,
+    timestamp INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    UNIQUE(video_id, frame_number)
+);
+
+CREATE TABLE IF NOT EXISTS analyses (
+    id SERIAL PRIMARY KEY,
+    frame_id INTEGER REFERENCES frames(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    embedding vector(4),
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_frames_video_id ON frames(video_id);
+CREATE INDEX IF NOT EXISTS idx_analyses_frame_id ON analyses(frame_id);
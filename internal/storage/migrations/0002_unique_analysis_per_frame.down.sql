@@ -0,0 +1 @@
+ALTER TABLE analyses DROP CONSTRAINT IF EXISTS analyses_frame_id_key;
@@ -0,0 +1,8 @@
+-- One analysis per frame, so results can be upserted
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'analyses_frame_id_key') THEN
+        ALTER TABLE analyses ADD CONSTRAINT analyses_frame_id_key UNIQUE (frame_id);
+    END IF;
+END
+$$;
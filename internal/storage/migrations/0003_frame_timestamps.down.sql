@@ -0,0 +1 @@
+ALTER TABLE frames DROP COLUMN IF EXISTS timestamp_ms;
@@ -0,0 +1,2 @@
+-- Older databases only stored whole seconds derived from the frame number
+ALTER TABLE frames ADD COLUMN IF NOT EXISTS timestamp_ms BIGINT NOT NULL DEFAULT 0;
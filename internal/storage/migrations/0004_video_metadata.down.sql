@@ -0,0 +1,12 @@
+ALTER TABLE videos
+    DROP COLUMN IF EXISTS duration_ms,
+    DROP COLUMN IF EXISTS container,
+    DROP COLUMN IF EXISTS video_codec,
+    DROP COLUMN IF EXISTS audio_codec,
+    DROP COLUMN IF EXISTS width,
+    DROP COLUMN IF EXISTS height,
+    DROP COLUMN IF EXISTS frame_rate,
+    DROP COLUMN IF EXISTS rotation,
+    DROP COLUMN IF EXISTS has_audio,
+    DROP COLUMN IF EXISTS size_bytes,
+    DROP COLUMN IF EXISTS probed_at;
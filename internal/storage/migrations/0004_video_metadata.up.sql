@@ -0,0 +1,13 @@
+-- Video metadata filled in by the ffprobe step
+ALTER TABLE videos
+    ADD COLUMN IF NOT EXISTS duration_ms BIGINT,
+    ADD COLUMN IF NOT EXISTS container TEXT,
+    ADD COLUMN IF NOT EXISTS video_codec TEXT,
+    ADD COLUMN IF NOT EXISTS audio_codec TEXT,
+    ADD COLUMN IF NOT EXISTS width INTEGER,
+    ADD COLUMN IF NOT EXISTS height INTEGER,
+    ADD COLUMN IF NOT EXISTS frame_rate DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS rotation INTEGER,
+    ADD COLUMN IF NOT EXISTS has_audio BOOLEAN,
+    ADD COLUMN IF NOT EXISTS size_bytes BIGINT,
+    ADD COLUMN IF NOT EXISTS probed_at TIMESTAMPTZ;
@@ -0,0 +1,4 @@
+-- Fails if several videos share a name
+DROP INDEX IF EXISTS idx_videos_fingerprint;
+ALTER TABLE videos DROP COLUMN IF EXISTS fingerprint;
+ALTER TABLE videos ADD CONSTRAINT videos_name_key UNIQUE (name);
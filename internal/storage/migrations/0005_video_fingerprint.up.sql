@@ -0,0 +1,4 @@
+-- Videos are identified by content fingerprint, names may repeat
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS fingerprint CHAR(64);
+ALTER TABLE videos DROP CONSTRAINT IF EXISTS videos_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_videos_fingerprint ON videos(fingerprint);
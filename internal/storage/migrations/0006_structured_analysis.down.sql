@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_analyses_structured;
+ALTER TABLE analyses DROP COLUMN IF EXISTS structured;
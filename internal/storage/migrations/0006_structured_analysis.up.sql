@@ -0,0 +1,3 @@
+-- Typed frame analyses from structured mode, indexed for containment queries
+ALTER TABLE analyses ADD COLUMN IF NOT EXISTS structured JSONB;
+CREATE INDEX IF NOT EXISTS idx_analyses_structured ON analyses USING GIN (structured jsonb_path_ops);
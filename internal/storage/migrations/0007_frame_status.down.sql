@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_frames_status;
+ALTER TABLE frames
+    DROP COLUMN IF EXISTS status,
+    DROP COLUMN IF EXISTS status_reason,
+    DROP COLUMN IF EXISTS attempts,
+    DROP COLUMN IF EXISTS status_updated_at;
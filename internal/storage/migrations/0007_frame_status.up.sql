@@ -0,0 +1,7 @@
+-- Per-frame outcome so failed frames can be retried later
+ALTER TABLE frames
+    ADD COLUMN IF NOT EXISTS status VARCHAR(16),
+    ADD COLUMN IF NOT EXISTS status_reason TEXT,
+    ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS status_updated_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_frames_status ON frames(video_id, status);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_analyses_content_tsv;
+ALTER TABLE analyses DROP COLUMN IF EXISTS content_tsv;
@@ -0,0 +1,5 @@
+-- Ranked full-text search over analysis descriptions
+ALTER TABLE analyses
+    ADD COLUMN IF NOT EXISTS content_tsv tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', content)) STORED;
+CREATE INDEX IF NOT EXISTS idx_analyses_content_tsv ON analyses USING GIN (content_tsv);
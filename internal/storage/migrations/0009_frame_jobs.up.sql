@@ -0,0 +1,24 @@
+-- Frames waiting for analysis, claimed by worker processes with
+-- FOR UPDATE SKIP LOCKED. The image travels with the job so workers on
+-- other machines do not need the extracted frames on disk.
+CREATE TABLE IF NOT EXISTS frame_jobs (
+    id BIGSERIAL PRIMARY KEY,
+    video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    frame_number INTEGER NOT NULL,
+    frame_path VARCHAR(255) NOT NULL,
+    frame_total INTEGER NOT NULL,
+    timestamp_ms BIGINT NOT NULL DEFAULT 0,
+    image BYTEA,
+    state VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    available_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    worker TEXT,
+    lease_expires_at TIMESTAMPTZ,
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (video_id, frame_number)
+);
+CREATE INDEX IF NOT EXISTS idx_frame_jobs_pending ON frame_jobs (available_at, id) WHERE state = 'pending';
+CREATE INDEX IF NOT EXISTS idx_frame_jobs_running ON frame_jobs (lease_expires_at) WHERE state = 'running';
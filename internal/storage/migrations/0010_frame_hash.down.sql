@@ -0,0 +1,5 @@
+ALTER TABLE frame_jobs
+    DROP COLUMN IF EXISTS phash;
+ALTER TABLE frames
+    DROP COLUMN IF EXISTS phash,
+    DROP COLUMN IF EXISTS duplicate_of;
@@ -0,0 +1,7 @@
+-- Perceptual hash of every frame, frames skipped as near-duplicates link to
+-- the analyzed frame they look like by its frame_path
+ALTER TABLE frames
+    ADD COLUMN IF NOT EXISTS phash VARCHAR(16),
+    ADD COLUMN IF NOT EXISTS duplicate_of VARCHAR(255);
+ALTER TABLE frame_jobs
+    ADD COLUMN IF NOT EXISTS phash VARCHAR(16);
@@ -0,0 +1,2 @@
+ALTER TABLE frame_jobs
+    DROP COLUMN IF EXISTS replaced;
@@ -0,0 +1,4 @@
+-- Why a queued frame was swapped for one nearby by the quality check, it
+-- becomes the reason of the frame's status once analyzed
+ALTER TABLE frame_jobs
+    ADD COLUMN IF NOT EXISTS replaced TEXT;
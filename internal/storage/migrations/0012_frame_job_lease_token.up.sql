@@ -0,0 +1,4 @@
+-- Counts the claims of a queued frame. Outcomes are only taken with the token
+-- of the current claim, names are shared by the goroutines of a worker.
+ALTER TABLE frame_jobs
+    ADD COLUMN IF NOT EXISTS lease_token BIGINT NOT NULL DEFAULT 0;
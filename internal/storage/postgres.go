@@ -7,8 +7,10 @@ import (
 	"time"
 
 	"github.com/bdougie/vision/internal/embeddings"
+	"github.com/bdougie/vision/internal/events"
 	"github.com/bdougie/vision/internal/models"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool" // Import the PostgreSQL driver
 	"github.com/pgvector/pgvector-go"
 )
@@ -22,6 +24,18 @@ type PostgresConfig struct {
 	DBName   string
 }
 
+// connString builds the connection URL for pgx
+func (c PostgresConfig) connString() string {
+	return fmt.Sprintf(
+		"postgres://%s:%s@%s:%s/%s",
+		c.User,
+		c.Password,
+		c.Host,
+		c.Port,
+		c.DBName,
+	)
+}
+
 // AnalyzeResult represents the result of an analysis.
 type AnalyzeResult struct {
 	ID       int
@@ -53,55 +67,76 @@ type PostgresStorage struct {
 	pool            *pgxpool.Pool
 	videoID         int
 	videoName       string
+	embedder         embeddings.Embedder
 	embeddingService *embeddings.Service
+	publisher        events.Publisher
 	wg               sync.WaitGroup
 }
 
-// NewPostgresStorage creates a new PostgreSQL storage connection
-func NewPostgresStorage(ctx context.Context, config PostgresConfig, videoName string) (*PostgresStorage, error) {
-	// Build connection string
-	connString := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s",
-		config.User,
-		config.Password,
-		config.Host,
-		config.Port,
-		config.DBName,
-	)
+// SetPublisher implements EventSource, an embedding_stored event is
+// published for every analysis stored with an embedding
+func (s *PostgresStorage) SetPublisher(publisher events.Publisher) {
+	s.publisher = publisher
+}
+
+// NewPostgresStorage creates a new PostgreSQL storage connection bound to a video.
+// The video is identified by its fingerprint, its name is only for display.
+// Analyses and search queries are embedded with embedder.
+func NewPostgresStorage(ctx context.Context, config PostgresConfig, video models.VideoMetadata, embedder embeddings.Embedder) (*PostgresStorage, error) {
+	storage, err := OpenPostgresStorage(ctx, config, embedder)
+	if err != nil {
+		return nil, err
+	}
 
+	// Get or create video ID
+	videoID, err := storage.getOrCreateVideo(ctx, video)
+	if err != nil {
+		storage.Close()
+		return nil, err
+	}
+	storage.videoID = videoID
+	storage.videoName = video.Name
+
+	return storage, nil
+}
+
+// OpenPostgresStorage connects to PostgreSQL without binding to a video, for
+// reading, searching and managing stored data. embedder may be nil when
+// nothing is embedded.
+func OpenPostgresStorage(ctx context.Context, config PostgresConfig, embedder embeddings.Embedder) (*PostgresStorage, error) {
 	// Connect to PostgreSQL
-	pool, err := pgxpool.New(ctx, connString)
+	pool, err := pgxpool.New(ctx, config.connString())
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
 	// Verify connection
 	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	// Create embedding service with workers
-	embeddingWorkers := 4 // Number of concurrent embedding generators
-	embeddingService := embeddings.NewService(embeddingWorkers)
-	
 	storage := &PostgresStorage{
-		pool:            pool,
-		videoName:       videoName,
-		embeddingService: embeddingService,
+		pool:     pool,
+		embedder: embedder,
 	}
 
-	// Get or create video ID
-	videoID, err := storage.getOrCreateVideo(ctx, videoName)
-	if err != nil {
-		return nil, err
+	// Create embedding service with workers
+	if embedder != nil {
+		embeddingWorkers := 4 // Number of concurrent embedding generators
+		storage.embeddingService = embeddings.NewService(embedder, embeddingWorkers)
 	}
-	storage.videoID = videoID
 
 	return storage, nil
 }
 
-// Close closes the database connection and worker goroutines
+// Close closes the database connection and worker goroutines. It does
+// nothing on a nil storage.
 func (s *PostgresStorage) Close() {
+	if s == nil {
+		return
+	}
+
 	// Close embedding service
 	if s.embeddingService != nil {
 		s.embeddingService.Close()
@@ -116,12 +151,16 @@ func (s *PostgresStorage) Close() {
 }
 
 // getOrCreateVideo gets an existing video entry or creates a new one
-func (s *PostgresStorage) getOrCreateVideo(ctx context.Context, videoName string) (int, error) {
-	// Check if video exists
+func (s *PostgresStorage) getOrCreateVideo(ctx context.Context, video models.VideoMetadata) (int, error) {
+	if video.Fingerprint == "" {
+		return 0, fmt.Errorf("video '%s' has no fingerprint", video.Name)
+	}
+
+	// Check if video exists, keeping the display name current after a rename
 	var id int
 	err := s.pool.QueryRow(ctx,
-		"SELECT id FROM videos WHERE name = $1",
-		videoName).Scan(&id)
+		"UPDATE videos SET name = $2 WHERE fingerprint = $1 RETURNING id",
+		video.Fingerprint, video.Name).Scan(&id)
 
 	if err == nil {
 		// Video exists, return ID
@@ -131,10 +170,25 @@ func (s *PostgresStorage) getOrCreateVideo(ctx context.Context, videoName string
 		return 0, fmt.Errorf("error checking for existing video: %w", err)
 	}
 
+	// Rows created before fingerprints were recorded are adopted by name once
+	err = s.pool.QueryRow(ctx,
+		`UPDATE videos SET fingerprint = $1
+		WHERE id = (SELECT id FROM videos WHERE name = $2 AND fingerprint IS NULL ORDER BY id LIMIT 1)
+		RETURNING id`,
+		video.Fingerprint, video.Name).Scan(&id)
+
+	if err == nil {
+		return id, nil
+	} else if err != pgx.ErrNoRows {
+		return 0, fmt.Errorf("error checking for legacy video: %w", err)
+	}
+
 	// Video doesn't exist, create it
 	err = s.pool.QueryRow(ctx,
-		"INSERT INTO videos (name, created_at) VALUES ($1, $2) RETURNING id",
-		videoName, time.Now()).Scan(&id)
+		`INSERT INTO videos (fingerprint, name, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (fingerprint) DO UPDATE SET name = EXCLUDED.name
+		RETURNING id`,
+		video.Fingerprint, video.Name, time.Now()).Scan(&id)
 
 	if err != nil {
 		return 0, fmt.Errorf("failed to create video entry: %w", err)
@@ -143,84 +197,224 @@ func (s *PostgresStorage) getOrCreateVideo(ctx context.Context, videoName string
 	return id, nil
 }
 
-// AddResult adds a frame analysis result to the database
-func (s *PostgresStorage) AddResult(ctx context.Context, result models.AnalysisResult) error {
-	// Extract frame number from filename
-	frameName := result.Frame
+// SaveVideoMetadata stores the probed metadata on the video row
+func (s *PostgresStorage) SaveVideoMetadata(ctx context.Context, meta models.VideoMetadata) error {
+	_, err := s.pool.Exec(ctx,
+		`UPDATE videos SET
+		duration_ms = $2, container = $3, video_codec = $4, audio_codec = $5,
+		width = $6, height = $7, frame_rate = $8, rotation = $9,
+		has_audio = $10, size_bytes = $11, probed_at = $12
+		WHERE id = $1`,
+		s.videoID, meta.DurationMs, meta.Container, meta.VideoCodec, meta.AudioCodec,
+		meta.Width, meta.Height, meta.FrameRate, meta.Rotation,
+		meta.HasAudio, meta.SizeBytes, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to store video metadata: %w", err)
+	}
+	return nil
+}
+
+// frameNumber extracts the frame number from a frame file name
+func frameNumber(frameName string) (int, error) {
 	frameNum := 0
 	if _, err := fmt.Sscanf(frameName, "frame_%04d.jpg", &frameNum); err != nil {
-		return fmt.Errorf("invalid frame filename format: %s", frameName)
+		return 0, fmt.Errorf("invalid frame filename format: %s", frameName)
 	}
-	
-	// Calculate timestamp from frame number (15 seconds per frame)
-	timestamp := frameNum * 15
-	
-	// Check if this frame already exists with embeddings
-	var frameID int
-	var hasEmbedding bool
-	err := s.pool.QueryRow(ctx, `
-		SELECT f.id, 
-		EXISTS(SELECT 1 FROM analyses a WHERE a.frame_id = f.id AND a.embedding IS NOT NULL) as has_embedding
-		FROM frames f
-		WHERE f.video_id = $1 AND f.frame_number = $2
-	`, s.videoID, frameNum).Scan(&frameID, &hasEmbedding)
-	
-	if err == nil {
-		// Frame exists, check if it has embeddings
-		if hasEmbedding {
-			// Frame already has embeddings, skip processing
-			fmt.Printf("Frame %d already has embeddings, skipping\n", frameNum)
-			return nil
+	return frameNum, nil
+}
+
+// SetFrameStatus records the state of a frame, creating the frame row if needed
+func (s *PostgresStorage) SetFrameStatus(ctx context.Context, status models.FrameStatus) error {
+	return s.setFrameStatus(ctx, s.pool, status)
+}
+
+// setFrameStatus records the state of a frame with db
+func (s *PostgresStorage) setFrameStatus(ctx context.Context, db pgExecutor, status models.FrameStatus) error {
+	frameNum, err := frameNumber(status.Frame)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = db.Exec(ctx,
+		`INSERT INTO frames
+		(video_id, frame_number, frame_path, timestamp, timestamp_ms, status, status_reason, attempts,
+		phash, duplicate_of, status_updated_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), NULLIF($10, ''), $11, $11)
+		ON CONFLICT (video_id, frame_number) DO UPDATE
+		SET frame_path = EXCLUDED.frame_path, timestamp = EXCLUDED.timestamp, timestamp_ms = EXCLUDED.timestamp_ms,
+		status = $6, status_reason = $7, attempts = $8,
+		phash = COALESCE(EXCLUDED.phash, frames.phash), duplicate_of = EXCLUDED.duplicate_of, status_updated_at = $11`,
+		s.videoID, frameNum, status.Frame, int(status.TimestampMs/1000), status.TimestampMs,
+		string(status.Status), status.Reason, status.Attempts, status.Hash, status.DuplicateOf, now)
+	if err != nil {
+		return fmt.Errorf("failed to store frame status: %w", err)
+	}
+	return nil
+}
+
+// FrameStatuses returns the recorded state of every frame of the video
+func (s *PostgresStorage) FrameStatuses(ctx context.Context) (map[string]models.FrameStatus, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT frame_path, timestamp_ms, status, COALESCE(status_reason, ''), COALESCE(attempts, 0),
+		COALESCE(phash, ''), COALESCE(duplicate_of, '')
+		FROM frames
+		WHERE video_id = $1 AND status IS NOT NULL`,
+		s.videoID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query frame statuses: %w", err)
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]models.FrameStatus)
+	for rows.Next() {
+		var status models.FrameStatus
+		var state string
+		if err := rows.Scan(&status.Frame, &status.TimestampMs, &state, &status.Reason, &status.Attempts,
+			&status.Hash, &status.DuplicateOf); err != nil {
+			return nil, fmt.Errorf("failed to scan frame status: %w", err)
 		}
-		
-		// Frame exists but doesn't have embeddings, we'll add them
-		fmt.Printf("Frame %d exists but has no embeddings, adding them\n", frameNum)
-	} else if err != pgx.ErrNoRows {
-		// Unexpected error
-		return fmt.Errorf("error checking for existing frame: %w", err)
-	} else {
-		// Frame doesn't exist, insert it
-		err = s.pool.QueryRow(ctx,
-			`INSERT INTO frames 
-			(video_id, frame_number, frame_path, timestamp, created_at) 
-			VALUES ($1, $2, $3, $4, $5) 
-			RETURNING id`,
-			s.videoID, frameNum, frameName, timestamp, time.Now()).Scan(&frameID)
-		
-		if err != nil {
-			return fmt.Errorf("failed to store frame information: %w", err)
+		status.Status = models.FrameState(state)
+		statuses[status.Frame] = status
+	}
+	return statuses, rows.Err()
+}
+
+// AnalyzedFrames returns the frames of the video that already have an analysis
+func (s *PostgresStorage) AnalyzedFrames(ctx context.Context) (map[string]bool, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT f.frame_path
+		FROM frames f
+		JOIN analyses a ON a.frame_id = f.id
+		WHERE f.video_id = $1`,
+		s.videoID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query analyzed frames: %w", err)
+	}
+	defer rows.Close()
+
+	analyzed := make(map[string]bool)
+	for rows.Next() {
+		var framePath string
+		if err := rows.Scan(&framePath); err != nil {
+			return nil, fmt.Errorf("failed to scan analyzed frame: %w", err)
 		}
+		analyzed[framePath] = true
+	}
+	return analyzed, rows.Err()
+}
+
+// ResetFrames removes the frames of the video with their analyses and
+// statuses, and the frames still queued for workers
+func (s *PostgresStorage) ResetFrames(ctx context.Context) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM frame_jobs WHERE video_id = $1`, s.videoID); err != nil {
+		return fmt.Errorf("failed to remove queued frames: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM frames WHERE video_id = $1`, s.videoID); err != nil {
+		return fmt.Errorf("failed to remove frames: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// AddResult adds a frame analysis result to the database
+func (s *PostgresStorage) AddResult(ctx context.Context, result models.AnalysisResult) error {
+	embedding := s.embed(ctx, result.Content)
+	if err := s.storeResult(ctx, s.pool, result, embedding); err != nil {
+		return err
+	}
+	s.embeddingStored(result, embedding)
+	return nil
+}
+
+// pgExecutor runs statements on the pool or within a transaction
+type pgExecutor interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// embed returns the embedding of an analysis, nil when it could not be
+// generated or storage was opened without an embedder
+func (s *PostgresStorage) embed(ctx context.Context, content string) *pgvector.Vector {
+	// Without an embedding the analysis is kept but not found by similarity
+	// search, storage opened without an embedder stores none at all
+	if s.embeddingService == nil {
+		return nil
 	}
-	
 	// Request embedding generation asynchronously using the embedding service
-	embeddingResultChan := s.embeddingService.GetEmbedding(result.Content)
-	embeddingResult := <-embeddingResultChan
-	
-	var embedding []float32
+	embeddingResult := <-s.embeddingService.GetEmbedding(ctx, content)
 	if embeddingResult.Error != nil {
-		// Log error but continue without embedding
 		fmt.Printf("Warning: Failed to generate embedding: %v\n", embeddingResult.Error)
-		embedding = []float32{0.1, 0.2, 0.3, 0.4} // Default fallback
-	} else {
-		embedding = embeddingResult.Embedding
+		return nil
+	}
+	vector := pgvector.NewVector(embeddingResult.Embedding)
+	return &vector
+}
+
+// storeResult writes a frame and its analysis with db
+func (s *PostgresStorage) storeResult(ctx context.Context, db pgExecutor, result models.AnalysisResult, embedding *pgvector.Vector) error {
+	// Extract frame number from filename
+	frameName := result.Frame
+	frameNum, err := frameNumber(frameName)
+	if err != nil {
+		return err
+	}
+	
+	// Whole seconds are kept for the legacy timestamp column
+	timestamp := int(result.TimestampMs / 1000)
+	
+	// Create the frame or refresh its timestamp. Whether a frame needs
+	// analyzing at all is decided by the processor, see AnalyzedFrames.
+	var frameID int
+	err = db.QueryRow(ctx,
+		`INSERT INTO frames 
+		(video_id, frame_number, frame_path, timestamp, timestamp_ms, created_at) 
+		VALUES ($1, $2, $3, $4, $5, $6) 
+		ON CONFLICT (video_id, frame_number) DO UPDATE
+		SET frame_path = EXCLUDED.frame_path, timestamp = EXCLUDED.timestamp, timestamp_ms = EXCLUDED.timestamp_ms
+		RETURNING id`,
+		s.videoID, frameNum, frameName, timestamp, result.TimestampMs, time.Now()).Scan(&frameID)
+	
+	if err != nil {
+		return fmt.Errorf("failed to store frame information: %w", err)
 	}
 	
 	// Store the analysis result with embedding
-	_, err = s.pool.Exec(ctx,
+	_, err = db.Exec(ctx,
 		`INSERT INTO analyses 
-		(frame_id, content, embedding, created_at) 
-		VALUES ($1, $2, $3, $4)
+		(frame_id, content, structured, embedding, created_at) 
+		VALUES ($1, $2, $3, $4, $5)
 		ON CONFLICT (frame_id) DO UPDATE
-		SET content = $2, embedding = $3, created_at = $4`,
-		frameID, result.Content, pgvector.NewVector(embedding), time.Now())
+		SET content = $2, structured = $3, embedding = $4, created_at = $5`,
+		frameID, result.Content, result.Structured, embedding, time.Now())
 	
 	if err != nil {
 		return fmt.Errorf("failed to store analysis: %w", err)
 	}
-	
 	return nil
 }
 
+// embeddingStored publishes an embedding_stored event for a stored analysis
+// with an embedding
+func (s *PostgresStorage) embeddingStored(result models.AnalysisResult, embedding *pgvector.Vector) {
+	if embedding == nil || s.publisher == nil {
+		return
+	}
+	frameNum, _ := frameNumber(result.Frame)
+	s.publisher.Publish(events.Event{
+		Type:        events.EmbeddingStored,
+		Video:       s.videoName,
+		Frame:       result.Frame,
+		FrameNumber: frameNum,
+		TimestampMs: result.TimestampMs,
+	})
+}
+
 // BatchAddResults adds multiple analysis results in parallel
 func (s *PostgresStorage) BatchAddResults(ctx context.Context, results []models.AnalysisResult) error {
 	// Create channels for parallel processing
@@ -266,250 +460,160 @@ func (s *PostgresStorage) Flush() error {
 	return nil
 }
 
-// generateEmbedding creates a vector embedding for the content
-func (s *PostgresStorage) generateEmbedding(ctx context.Context, content string) ([]float32, error) {
-	// This is a placeholder - in a real application, you would:
-	// 1. Call an embedding API like OpenAI
-	// 2. Process the content to create embeddings
-	
-	// Simulate a computation-heavy task
-	time.Sleep(50 * time.Millisecond)
-	
-	// For now, we'll return a simple dummy embedding
-	return []float32{0.1, 0.2, 0.3, 0.4}, nil
-}
-
-// SearchSimilarFrames finds frames with similar content
-func (s *PostgresStorage) SearchSimilarFrames(ctx context.Context, query string, limit int) ([]models.FrameSearchResult, error) {
-	// Generate embedding for query using the embedding service
-	embeddingResultChan := s.embeddingService.GetEmbedding(query)
-	embeddingResult := <-embeddingResultChan
-	
-	if embeddingResult.Error != nil {
-		return nil, fmt.Errorf("failed to generate query embedding: %w", embeddingResult.Error)
-	}
-	
-	queryEmbedding := embeddingResult.Embedding
+// videoMatch selects a videos row by fingerprint ($1), or by name ($2) for
+// rows stored before fingerprints were recorded
+const videoMatch = "(fingerprint = $1 OR (fingerprint IS NULL AND $1 = '' AND name = $2))"
 
-	// Search for similar frames
+// Videos implements Catalog
+func (s *PostgresStorage) Videos(ctx context.Context) ([]models.VideoSummary, error) {
 	rows, err := s.pool.Query(ctx,
-		`SELECT f.frame_number, f.frame_path, a.content, 
-		1 - (a.embedding <=> $1) AS similarity
-		FROM analyses a
-		JOIN frames f ON a.frame_id = f.id
-		JOIN videos v ON f.video_id = v.id
-		WHERE v.id = $2
-		ORDER BY a.embedding <=> $1
-		LIMIT $3`,
-		pgvector.NewVector(queryEmbedding), s.videoID, limit)
-
+		`SELECT COALESCE(v.fingerprint, ''), v.name, COALESCE(v.duration_ms, 0),
+		COALESCE(v.container, ''), COALESCE(v.video_codec, ''), COALESCE(v.audio_codec, ''),
+		COALESCE(v.width, 0), COALESCE(v.height, 0), COALESCE(v.frame_rate, 0),
+		COALESCE(v.rotation, 0), COALESCE(v.has_audio, false), COALESCE(v.size_bytes, 0),
+		v.created_at, COUNT(f.id), COUNT(a.id),
+		COUNT(f.id) FILTER (WHERE f.status IN ('failed', 'skipped'))
+		FROM videos v
+		LEFT JOIN frames f ON f.video_id = v.id
+		LEFT JOIN analyses a ON a.frame_id = f.id
+		GROUP BY v.id
+		ORDER BY v.created_at`)
 	if err != nil {
-		return nil, fmt.Errorf("failed to search similar frames: %w", err)
+		return nil, fmt.Errorf("failed to list videos: %w", err)
 	}
 	defer rows.Close()
 
-	// Process results
-	var results []models.FrameSearchResult
+	var videos []models.VideoSummary
 	for rows.Next() {
-		var result models.FrameSearchResult
-		if err := rows.Scan(&result.FrameNumber, &result.FramePath,
-			&result.Description, &result.Similarity); err != nil {
-			return nil, fmt.Errorf("failed to scan search results: %w", err)
+		var summary models.VideoSummary
+		video := &summary.Video
+		if err := rows.Scan(&video.Fingerprint, &video.Name, &video.DurationMs,
+			&video.Container, &video.VideoCodec, &video.AudioCodec,
+			&video.Width, &video.Height, &video.FrameRate,
+			&video.Rotation, &video.HasAudio, &video.SizeBytes,
+			&summary.CreatedAt, &summary.Frames, &summary.Analyzed, &summary.Failed); err != nil {
+			return nil, fmt.Errorf("failed to scan video: %w", err)
 		}
-		results = append(results, result)
+		videos = append(videos, summary)
 	}
-
-	return results, rows.Err()
+	return videos, rows.Err()
 }
 
-// TextSearchFrames finds frames containing specific text without using embeddings
-func (s *PostgresStorage) TextSearchFrames(ctx context.Context, query string, limit int) ([]models.FrameSearchResult, error) {
-	// Check if the video exists in the database
-	var count int
-	err := s.pool.QueryRow(ctx, 
-		"SELECT COUNT(*) FROM frames WHERE video_id = $1", 
-		s.videoID).Scan(&count)
-	
+// FindVideo implements Catalog
+func (s *PostgresStorage) FindVideo(ctx context.Context, ref string) (*models.VideoMetadata, error) {
+	videos, err := s.Videos(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check for video frames: %w", err)
-	}
-	
-	if count == 0 {
-		return nil, fmt.Errorf("no frames found for video '%s'. Run:\n\nexport DB_ENABLED=true\n./visionanalyzer --video %s\n\nto process and embed this video first", 
-			s.videoName, s.videoName)
+		return nil, err
 	}
-	
-	// Simple text search using ILIKE
+	return matchVideo(videos, ref)
+}
+
+// Frames implements Catalog
+func (s *PostgresStorage) Frames(ctx context.Context, video models.VideoMetadata) ([]models.FrameRecord, error) {
 	rows, err := s.pool.Query(ctx,
-		`SELECT f.frame_number, f.frame_path, a.content, 
-		0.5 AS similarity
-		FROM analyses a
-		JOIN frames f ON a.frame_id = f.id
-		JOIN videos v ON f.video_id = v.id
-		WHERE v.id = $1 AND a.content ILIKE $2
-		ORDER BY f.frame_number
-		LIMIT $3`,
-		s.videoID, "%"+query+"%", limit)
-	
+		`SELECT f.frame_path, f.frame_number, f.timestamp_ms,
+		COALESCE(f.status, ''), COALESCE(f.status_reason, ''), COALESCE(f.attempts, 0),
+		COALESCE(f.phash, ''), COALESCE(f.duplicate_of, ''),
+		COALESCE(a.content, da.content, ''), COALESCE(a.structured, da.structured)
+		FROM frames f
+		LEFT JOIN analyses a ON a.frame_id = f.id
+		LEFT JOIN frames d ON d.video_id = f.video_id AND d.frame_path = f.duplicate_of
+		LEFT JOIN analyses da ON da.frame_id = d.id
+		WHERE f.video_id = (SELECT id FROM videos WHERE `+videoMatch+`)
+		ORDER BY f.frame_number`,
+		video.Fingerprint, video.Name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to search frames: %w", err)
+		return nil, fmt.Errorf("failed to query frames: %w", err)
 	}
 	defer rows.Close()
-	
-	// Process results
-	var results []models.FrameSearchResult
+
+	var frames []models.FrameRecord
 	for rows.Next() {
-		var result models.FrameSearchResult
-		if err := rows.Scan(&result.FrameNumber, &result.FramePath,
-			&result.Description, &result.Similarity); err != nil {
-			return nil, fmt.Errorf("failed to scan search results: %w", err)
+		var frame models.FrameRecord
+		var state string
+		if err := rows.Scan(&frame.Frame, &frame.FrameNumber, &frame.TimestampMs,
+			&state, &frame.Reason, &frame.Attempts, &frame.Hash, &frame.DuplicateOf,
+			&frame.Content, &frame.Structured); err != nil {
+			return nil, fmt.Errorf("failed to scan frame: %w", err)
 		}
-		results = append(results, result)
+		frame.Status = models.FrameState(state)
+		frames = append(frames, frame)
 	}
-	
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error reading search results: %w", err)
+	return frames, rows.Err()
+}
+
+// DeleteVideo implements Catalog, frames and analyses are removed by cascade
+func (s *PostgresStorage) DeleteVideo(ctx context.Context, video models.VideoMetadata) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM videos WHERE "+videoMatch, video.Fingerprint, video.Name)
+	if err != nil {
+		return fmt.Errorf("failed to delete video '%s': %w", video.Name, err)
 	}
-	
-	if len(results) == 0 {
-		// If no results found with ILIKE, inform the user
-		return nil, fmt.Errorf("no frames found containing '%s' for video '%s'", query, s.videoName)
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: '%s'", ErrVideoNotFound, video.Name)
 	}
-	
-	return results, nil
+	return nil
 }
 
-// InitSchema creates the database schema if it doesn't exist
-func InitSchema(ctx context.Context, config PostgresConfig) error {
-	// Build connection string
-	connString := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s",
-		config.User,
-		config.Password,
-		config.Host,
-		config.Port,
-		config.DBName,
-	)
+// reindexBatchSize is how many analyses are embedded per request by Reindex
+const reindexBatchSize = 32
 
-	// Connect to PostgreSQL
-	conn, err := pgx.Connect(ctx, connString)
-	if err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
+// Reindex embeds the stored analyses again with the current embedder, for
+// one video or for all of them when video is nil, and returns how many were
+// updated
+func (s *PostgresStorage) Reindex(ctx context.Context, video *models.VideoMetadata) (int, error) {
+	if s.embedder == nil {
+		return 0, fmt.Errorf("no embedder configured")
 	}
-	defer conn.Close(ctx)
 
-	// Check if vector extension exists
-	var exists bool
-	err = conn.QueryRow(ctx,
-		"SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'vector')").Scan(&exists)
-	if err != nil {
-		return fmt.Errorf("failed to check for vector extension: %w", err)
+	query := `SELECT a.id, a.content FROM analyses a ORDER BY a.id`
+	var args []any
+	if video != nil {
+		query = `SELECT a.id, a.content
+		FROM analyses a
+		JOIN frames f ON a.frame_id = f.id
+		WHERE f.video_id = (SELECT id FROM videos WHERE ` + videoMatch + `)
+		ORDER BY a.id`
+		args = []any{video.Fingerprint, video.Name}
 	}
 
-	// Create vector extension if it doesn't exist
-	if !exists {
-		_, err = conn.Exec(ctx, "CREATE EXTENSION IF NOT EXISTS vector")
-		if err != nil {
-			return fmt.Errorf("failed to create vector extension: %w", err)
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query analyses: %w", err)
+	}
+	var ids []int
+	var contents []string
+	for rows.Next() {
+		var id int
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan analysis: %w", err)
 		}
+		ids = append(ids, id)
+		contents = append(contents, content)
 	}
-
-	// Create tables
-	_, err = conn.Exec(ctx, `
-        CREATE TABLE IF NOT EXISTS videos (
-            id SERIAL PRIMARY KEY,
-            name VARCHAR(255) NOT NULL,
-            created_at TIMESTAMPTZ NOT NULL,
-            UNIQUE(name)
-        );
-        
-        CREATE TABLE IF NOT EXISTS frames (
-            id SERIAL PRIMARY KEY,
-            video_id INTEGER REFERENCES videos(id) ON DELETE CASCADE,
-            frame_number INTEGER NOT NULL,
-            frame_path VARCHAR(255) NOT NULL,
-            timestamp INTEGER NOT NULL,
-            created_at TIMESTAMPTZ NOT NULL,
-            UNIQUE(video_id, frame_number)
-        );
-        
-        CREATE TABLE IF NOT EXISTS analyses (
-            id SERIAL PRIMARY KEY,
-            frame_id INTEGER REFERENCES frames(id) ON DELETE CASCADE,
-            content TEXT NOT NULL,
-            embedding vector(4),
-            created_at TIMESTAMPTZ NOT NULL
-        );
-    `)
-
-	if err != nil {
-		return fmt.Errorf("failed to create database schema: %w", err)
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read analyses: %w", err)
 	}
 
-	// Create indexes
-	_, err = conn.Exec(ctx, `
-        CREATE INDEX IF NOT EXISTS idx_frames_video_id ON frames(video_id);
-        CREATE INDEX IF NOT EXISTS idx_analyses_frame_id ON analyses(frame_id);
-        CREATE INDEX IF NOT EXISTS idx_embedding_vector ON analyses USING ivfflat (embedding vector_l2_ops) WITH (lists = 100);
-    `)
-
-	if err != nil {
-		return fmt.Errorf("failed to create database indexes: %w", err)
-	}
+	updated := 0
+	for start := 0; start < len(ids); start += reindexBatchSize {
+		end := min(start+reindexBatchSize, len(ids))
+		vectors, err := s.embedder.Embed(ctx, contents[start:end])
+		if err != nil {
+			return updated, fmt.Errorf("failed to embed analyses: %w", err)
+		}
 
-	// Add unique constraint for analyses.frame_id
-	if err := UpdateSchema(ctx, config); err != nil {
-		return fmt.Errorf("failed to update schema: %w", err)
+		batch := &pgx.Batch{}
+		for i, vector := range vectors {
+			batch.Queue("UPDATE analyses SET embedding = $2 WHERE id = $1", ids[start+i], pgvector.NewVector(vector))
+		}
+		if err := s.pool.SendBatch(ctx, batch).Close(); err != nil {
+			return updated, fmt.Errorf("failed to store embeddings: %w", err)
+		}
+		updated += end - start
+		fmt.Printf("Reindexed %d/%d analyses\n", updated, len(ids))
 	}
-
-	return nil
+	return updated, nil
 }
-
-// UpdateSchema adds UNIQUE constraint on frame_id if needed
-func UpdateSchema(ctx context.Context, config PostgresConfig) error {
-    // Build connection string
-    connString := fmt.Sprintf(
-        "postgres://%s:%s@%s:%s/%s",
-        config.User,
-        config.Password,
-        config.Host,
-        config.Port,
-        config.DBName,
-    )
-
-    // Connect to PostgreSQL
-    conn, err := pgx.Connect(ctx, connString)
-    if err != nil {
-        return fmt.Errorf("failed to connect to database: %w", err)
-    }
-    defer conn.Close(ctx)
-    
-    // Check if unique constraint exists
-    var constraintExists bool
-    err = conn.QueryRow(ctx, `
-        SELECT EXISTS (
-            SELECT 1 
-            FROM pg_constraint 
-            WHERE conname = 'analyses_frame_id_key'
-        )
-    `).Scan(&constraintExists)
-    
-    if err != nil {
-        return fmt.Errorf("failed to check constraint existence: %w", err)
-    }
-    
-    // Add unique constraint if it doesn't exist
-    if !constraintExists {
-        _, err = conn.Exec(ctx, `
-            ALTER TABLE analyses 
-            ADD CONSTRAINT analyses_frame_id_key 
-            UNIQUE (frame_id)
-        `)
-        
-        if err != nil {
-            return fmt.Errorf("failed to add unique constraint: %w", err)
-        }
-    }
-    
-    return nil
-}
\ No newline at end of file
@@ -0,0 +1,335 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bdougie/vision/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrLeaseLost is returned when a worker no longer holds the frame it is working on
+var ErrLeaseLost = errors.New("frame job lease lost")
+
+// FrameQueue shares the frames of videos between worker processes. Frames
+// are claimed for a lease which workers extend while they work; frames of a
+// worker that stops extending its lease are claimed again by another one.
+// Heartbeat, Complete, Retry, Release and Fail only act for the claim holding
+// the frame, identified by the job's LeaseToken, and return ErrLeaseLost to
+// any other.
+type FrameQueue interface {
+	// Enqueue adds frames of the bound video, reading their images from
+	// frameDir, and returns how many were queued. Frames already queued are
+	// queued again unless a worker is on them.
+	Enqueue(ctx context.Context, frameDir string, work []models.WorkItem, maxAttempts int) (int, error)
+
+	// Claim takes the oldest available frame for worker, nil when there is none
+	Claim(ctx context.Context, worker string, lease time.Duration) (*models.FrameJob, error)
+
+	// Heartbeat extends the lease of a claimed frame, ErrLeaseLost means it
+	// was claimed again in the meantime
+	Heartbeat(ctx context.Context, job models.FrameJob, lease time.Duration) error
+
+	// Complete stores the analysis of a frame and removes it from the queue,
+	// nothing is stored once the lease is lost
+	Complete(ctx context.Context, job models.FrameJob, result models.AnalysisResult, status models.FrameStatus) error
+
+	// Retry makes a failed frame available again after delay
+	Retry(ctx context.Context, job models.FrameJob, reason string, delay time.Duration) error
+
+	// Release gives a frame back after delay without counting the attempt
+	Release(ctx context.Context, job models.FrameJob, delay time.Duration) error
+
+	// Fail records the final failure of a frame and removes it from the queue
+	Fail(ctx context.Context, job models.FrameJob, status models.FrameStatus) error
+
+	// QueueSummary counts the queued frames of every video with any
+	QueueSummary(ctx context.Context) ([]models.QueueSummary, error)
+}
+
+// Frame job states
+const (
+	frameJobPending = "pending"
+	frameJobRunning = "running"
+	frameJobDone    = "done"
+	frameJobFailed  = "failed"
+)
+
+// enqueueBatchSize is how many frames are sent to the database at once by Enqueue
+const enqueueBatchSize = 32
+
+// Enqueue implements FrameQueue
+func (s *PostgresStorage) Enqueue(ctx context.Context, frameDir string, work []models.WorkItem, maxAttempts int) (int, error) {
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+
+	queued := 0
+	for start := 0; start < len(work); start += enqueueBatchSize {
+		batch := &pgx.Batch{}
+		for _, item := range work[start:min(start+enqueueBatchSize, len(work))] {
+			number, err := frameNumber(item.FramePath)
+			if err != nil {
+				return queued, err
+			}
+			image, err := os.ReadFile(filepath.Join(frameDir, item.FramePath))
+			if err != nil {
+				return queued, fmt.Errorf("failed to read frame: %w", err)
+			}
+			batch.Queue(`INSERT INTO frame_jobs
+				(video_id, frame_number, frame_path, frame_total, timestamp_ms, phash, replaced, image, max_attempts)
+				VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8, $9)
+				ON CONFLICT (video_id, frame_number) DO UPDATE
+				SET frame_path = EXCLUDED.frame_path, frame_total = EXCLUDED.frame_total,
+				timestamp_ms = EXCLUDED.timestamp_ms, phash = EXCLUDED.phash, replaced = EXCLUDED.replaced,
+				image = EXCLUDED.image, max_attempts = EXCLUDED.max_attempts,
+				state = '`+frameJobPending+`', attempts = 0, available_at = NOW(), worker = NULL,
+				lease_expires_at = NULL, last_error = NULL, updated_at = NOW()
+				WHERE frame_jobs.state <> '`+frameJobRunning+`'`,
+				s.videoID, number, item.FramePath, item.Total, item.TimestampMs, item.Hash, item.Replaced, image, maxAttempts)
+		}
+
+		results := s.pool.SendBatch(ctx, batch)
+		for i := 0; i < batch.Len(); i++ {
+			tag, err := results.Exec()
+			if err != nil {
+				results.Close()
+				return queued, fmt.Errorf("failed to queue frame: %w", err)
+			}
+			queued += int(tag.RowsAffected())
+		}
+		if err := results.Close(); err != nil {
+			return queued, fmt.Errorf("failed to queue frames: %w", err)
+		}
+	}
+	return queued, nil
+}
+
+// Claim implements FrameQueue. Frames whose lease expired after their last
+// allowed attempt are marked failed first, the others are claimed again.
+func (s *PostgresStorage) Claim(ctx context.Context, worker string, lease time.Duration) (*models.FrameJob, error) {
+	_, err := s.pool.Exec(ctx, `WITH expired AS (
+			UPDATE frame_jobs
+			SET state = '`+frameJobFailed+`', image = NULL, worker = NULL, lease_expires_at = NULL,
+			last_error = 'worker stopped responding', updated_at = NOW()
+			WHERE state = '`+frameJobRunning+`' AND lease_expires_at < NOW() AND attempts >= max_attempts
+			RETURNING video_id, frame_number, frame_path, timestamp_ms, last_error
+		)
+		INSERT INTO frames
+		(video_id, frame_number, frame_path, timestamp, timestamp_ms, status, status_reason, status_updated_at, created_at)
+		SELECT video_id, frame_number, frame_path, timestamp_ms / 1000, timestamp_ms, $1, last_error, NOW(), NOW()
+		FROM expired
+		ON CONFLICT (video_id, frame_number) DO UPDATE
+		SET status = EXCLUDED.status, status_reason = EXCLUDED.status_reason, status_updated_at = EXCLUDED.status_updated_at`,
+		string(models.FrameFailed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire frame jobs: %w", err)
+	}
+
+	job := &models.FrameJob{Worker: worker}
+	err = s.pool.QueryRow(ctx, `UPDATE frame_jobs j
+		SET state = '`+frameJobRunning+`', worker = $1, attempts = j.attempts + 1,
+		lease_token = j.lease_token + 1, lease_expires_at = NOW() + $2 * INTERVAL '1 millisecond', updated_at = NOW()
+		FROM videos v
+		WHERE v.id = j.video_id AND j.id = (
+			SELECT id FROM frame_jobs
+			WHERE (state = '`+frameJobPending+`' AND available_at <= NOW())
+			OR (state = '`+frameJobRunning+`' AND lease_expires_at < NOW())
+			ORDER BY id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING j.id, v.name, j.frame_path, j.frame_number, j.frame_total, j.timestamp_ms,
+		COALESCE(j.phash, ''), COALESCE(j.replaced, ''), j.image, j.attempts, j.max_attempts, j.lease_token`,
+		worker, lease.Milliseconds()).Scan(&job.ID, &job.VideoName, &job.Work.FramePath, &job.Work.FrameNum,
+		&job.Work.Total, &job.Work.TimestampMs, &job.Work.Hash, &job.Work.Replaced, &job.Image, &job.Attempts, &job.MaxAttempts, &job.LeaseToken)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim frame job: %w", err)
+	}
+	return job, nil
+}
+
+// Heartbeat implements FrameQueue
+func (s *PostgresStorage) Heartbeat(ctx context.Context, job models.FrameJob, lease time.Duration) error {
+	tag, err := s.pool.Exec(ctx, `UPDATE frame_jobs
+		SET lease_expires_at = NOW() + $3 * INTERVAL '1 millisecond', updated_at = NOW()
+		WHERE id = $1 AND lease_token = $2 AND state = '`+frameJobRunning+`'`,
+		job.ID, job.LeaseToken, lease.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("failed to extend frame job lease: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Complete implements FrameQueue. The job row is locked for the lease token
+// while the analysis and status are written, so a worker that lost the frame
+// cannot overwrite the outcome of the claim that holds it.
+func (s *PostgresStorage) Complete(ctx context.Context, job models.FrameJob, result models.AnalysisResult, status models.FrameStatus) error {
+	video, err := s.jobVideo(ctx, job)
+	if err != nil {
+		return err
+	}
+	// Embedding calls are slow, they are made before the row is locked
+	embedding := video.embed(ctx, result.Content)
+	err = s.withLease(ctx, job, func(tx pgx.Tx) error {
+		if err := video.storeResult(ctx, tx, result, embedding); err != nil {
+			return err
+		}
+		if err := video.setFrameStatus(ctx, tx, status); err != nil {
+			return err
+		}
+		return finishJob(ctx, tx, job, frameJobDone, "")
+	})
+	if err != nil {
+		return err
+	}
+	video.embeddingStored(result, embedding)
+	return nil
+}
+
+// Retry implements FrameQueue
+func (s *PostgresStorage) Retry(ctx context.Context, job models.FrameJob, reason string, delay time.Duration) error {
+	tag, err := s.pool.Exec(ctx, `UPDATE frame_jobs
+		SET state = '`+frameJobPending+`', worker = NULL, lease_expires_at = NULL, last_error = $3,
+		available_at = NOW() + $4 * INTERVAL '1 millisecond', updated_at = NOW()
+		WHERE id = $1 AND lease_token = $2 AND state = '`+frameJobRunning+`'`,
+		job.ID, job.LeaseToken, reason, delay.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("failed to requeue frame job: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Release implements FrameQueue
+func (s *PostgresStorage) Release(ctx context.Context, job models.FrameJob, delay time.Duration) error {
+	tag, err := s.pool.Exec(ctx, `UPDATE frame_jobs
+		SET state = '`+frameJobPending+`', worker = NULL, lease_expires_at = NULL,
+		attempts = GREATEST(attempts - 1, 0),
+		available_at = NOW() + $3 * INTERVAL '1 millisecond', updated_at = NOW()
+		WHERE id = $1 AND lease_token = $2 AND state = '`+frameJobRunning+`'`,
+		job.ID, job.LeaseToken, delay.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("failed to release frame job: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Fail implements FrameQueue. The failure is only recorded while the lease
+// is held, another worker may still analyze the frame.
+func (s *PostgresStorage) Fail(ctx context.Context, job models.FrameJob, status models.FrameStatus) error {
+	video, err := s.jobVideo(ctx, job)
+	if err != nil {
+		return err
+	}
+	return s.withLease(ctx, job, func(tx pgx.Tx) error {
+		if err := video.setFrameStatus(ctx, tx, status); err != nil {
+			return err
+		}
+		return finishJob(ctx, tx, job, frameJobFailed, status.Reason)
+	})
+}
+
+// withLease runs write in a transaction holding the job row locked, or
+// returns ErrLeaseLost without running it when the claim of job no longer
+// holds the frame
+func (s *PostgresStorage) withLease(ctx context.Context, job models.FrameJob, write func(tx pgx.Tx) error) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx, `SELECT id FROM frame_jobs
+		WHERE id = $1 AND lease_token = $2 AND state = '`+frameJobRunning+`'
+		FOR UPDATE`,
+		job.ID, job.LeaseToken).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrLeaseLost
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock frame job: %w", err)
+	}
+	if err := write(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// finishJob takes a frame off the queue within the transaction holding its
+// row, dropping its image
+func finishJob(ctx context.Context, tx pgx.Tx, job models.FrameJob, state, reason string) error {
+	_, err := tx.Exec(ctx, `UPDATE frame_jobs
+		SET state = $2, image = NULL, worker = NULL, lease_expires_at = NULL,
+		last_error = NULLIF($3, ''), updated_at = NOW()
+		WHERE id = $1`,
+		job.ID, state, reason)
+	if err != nil {
+		return fmt.Errorf("failed to finish frame job: %w", err)
+	}
+	return nil
+}
+
+// jobVideo returns storage bound to the video of a frame job, sharing this
+// connection pool and embedding service
+func (s *PostgresStorage) jobVideo(ctx context.Context, job models.FrameJob) (*PostgresStorage, error) {
+	video := &PostgresStorage{
+		pool:             s.pool,
+		videoName:        job.VideoName,
+		embedder:         s.embedder,
+		embeddingService: s.embeddingService,
+		publisher:        s.publisher,
+	}
+	err := s.pool.QueryRow(ctx, "SELECT video_id FROM frame_jobs WHERE id = $1", job.ID).Scan(&video.videoID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("frame job %d no longer exists", job.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up frame job: %w", err)
+	}
+	return video, nil
+}
+
+// QueueSummary implements FrameQueue
+func (s *PostgresStorage) QueueSummary(ctx context.Context) ([]models.QueueSummary, error) {
+	rows, err := s.pool.Query(ctx, `SELECT COALESCE(v.fingerprint, ''), v.name,
+		COUNT(*) FILTER (WHERE j.state = '`+frameJobPending+`'),
+		COUNT(*) FILTER (WHERE j.state = '`+frameJobRunning+`'),
+		COUNT(*) FILTER (WHERE j.state = '`+frameJobDone+`'),
+		COUNT(*) FILTER (WHERE j.state = '`+frameJobFailed+`')
+		FROM frame_jobs j
+		JOIN videos v ON v.id = j.video_id
+		GROUP BY v.id
+		ORDER BY MIN(j.created_at)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query frame queue: %w", err)
+	}
+	defer rows.Close()
+
+	var summaries []models.QueueSummary
+	for rows.Next() {
+		var summary models.QueueSummary
+		if err := rows.Scan(&summary.Video.Fingerprint, &summary.Video.Name,
+			&summary.Pending, &summary.Running, &summary.Done, &summary.Failed); err != nil {
+			return nil, fmt.Errorf("failed to scan frame queue: %w", err)
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, rows.Err()
+}
@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bdougie/vision/internal/models"
+	"github.com/pgvector/pgvector-go"
+)
+
+// defaultSearchLimit is used when a search request has no limit
+const defaultSearchLimit = 10
+
+// ErrInvalidSearch is returned for search requests that cannot be run as given
+var ErrInvalidSearch = errors.New("invalid search request")
+
+// Searcher finds frames across all stored videos
+type Searcher interface {
+	Search(ctx context.Context, req models.SearchRequest) (*models.SearchPage, error)
+}
+
+// queryBuilder collects SQL conditions with numbered arguments
+type queryBuilder struct {
+	where []string
+	args  []any
+}
+
+// arg adds a query argument and returns its placeholder
+func (b *queryBuilder) arg(value any) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+func (b *queryBuilder) and(condition string) {
+	b.where = append(b.where, condition)
+}
+
+// filter adds the conditions narrowing down which frames are searched
+func (b *queryBuilder) filter(req models.SearchRequest) error {
+	if len(req.Videos) > 0 {
+		b.and("v.fingerprint = ANY(" + b.arg(req.Videos) + ")")
+	}
+	if req.VideoName != "" {
+		b.and("v.name ILIKE " + b.arg("%"+escapeLike(req.VideoName)+"%"))
+	}
+	if len(req.Tags) > 0 || len(req.Objects) > 0 {
+		// Structured fields are stored lower case, containment uses the GIN index
+		contains := map[string]any{}
+		if len(req.Tags) > 0 {
+			tags := make([]string, len(req.Tags))
+			for i, tag := range req.Tags {
+				tags[i] = strings.ToLower(strings.TrimSpace(tag))
+			}
+			contains["tags"] = tags
+		}
+		if len(req.Objects) > 0 {
+			objects := make([]map[string]string, len(req.Objects))
+			for i, object := range req.Objects {
+				objects[i] = map[string]string{"name": strings.ToLower(strings.TrimSpace(object))}
+			}
+			contains["objects"] = objects
+		}
+		doc, err := json.Marshal(contains)
+		if err != nil {
+			return err
+		}
+		b.and("a.structured @> " + b.arg(string(doc)) + "::jsonb")
+	}
+	if req.FromMs > 0 {
+		b.and("f.timestamp_ms >= " + b.arg(req.FromMs))
+	}
+	if req.ToMs > 0 {
+		b.and("f.timestamp_ms < " + b.arg(req.ToMs))
+	}
+	if req.StoredAfter != nil {
+		b.and("v.created_at >= " + b.arg(*req.StoredAfter))
+	}
+	if req.StoredBefore != nil {
+		b.and("v.created_at < " + b.arg(*req.StoredBefore))
+	}
+	return nil
+}
+
+// escapeLike makes text match literally inside a LIKE pattern
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
+
+// textSearchConfig is the text search configuration of the content_tsv column
+const textSearchConfig = "english"
+
+// rrfK dampens the weight of top positions in reciprocal rank fusion
+const rrfK = 60
+
+// hybridCandidates is the number of frames taken from each ranking before
+// fusing them in hybrid mode. It stays the same on every page of a query,
+// otherwise ranks and fused scores would shift between pages.
+const hybridCandidates = 200
+
+// searchFrom joins analyses to their frames and videos
+const searchFrom = `FROM analyses a
+		JOIN frames f ON a.frame_id = f.id
+		JOIN videos v ON f.video_id = v.id`
+
+// searchColumns are selected for every hit, followed by the score columns
+const searchColumns = `a.id, COALESCE(v.fingerprint, '') AS fingerprint, v.name AS video_name,
+		f.frame_number, f.frame_path, f.timestamp_ms, a.content, COALESCE(v.duration_ms, 0) AS duration_ms`
+
+// searchCursor is the position after the last result of a page
+type searchCursor struct {
+	Score float64 `json:"s"`
+	ID    int     `json:"i"`
+
+	// Seen counts the results returned before
+	Seen int `json:"n"`
+
+	// Pool is the hybrid candidate pool size of the query
+	Pool int `json:"p,omitempty"`
+}
+
+func (c searchCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (*searchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidSearch)
+	}
+	var c searchCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidSearch)
+	}
+	return &c, nil
+}
+
+// searchPlan is a validated search request with its paging position
+type searchPlan struct {
+	req    models.SearchRequest
+	limit  int
+	cursor *searchCursor
+
+	// seen counts the results returned by earlier pages
+	seen int
+
+	// pool is how many frames are taken from each ranking in hybrid mode
+	pool int
+}
+
+// planSearch checks a search request and fills in its defaults
+func planSearch(req models.SearchRequest) (*searchPlan, error) {
+	if strings.TrimSpace(req.Query) == "" {
+		return nil, fmt.Errorf("%w: empty query", ErrInvalidSearch)
+	}
+	plan := &searchPlan{req: req, limit: req.Limit}
+	if plan.limit <= 0 {
+		plan.limit = defaultSearchLimit
+	}
+	switch plan.req.Mode {
+	case "":
+		plan.req.Mode = models.SearchSemantic
+	case models.SearchSemantic, models.SearchText, models.SearchHybrid:
+	default:
+		return nil, fmt.Errorf("%w: unknown mode '%s'", ErrInvalidSearch, req.Mode)
+	}
+	if req.Offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset", ErrInvalidSearch)
+	}
+	plan.seen = req.Offset
+	plan.pool = hybridCandidates
+	if req.Cursor != "" {
+		if req.Offset > 0 {
+			return nil, fmt.Errorf("%w: a cursor cannot be combined with an offset", ErrInvalidSearch)
+		}
+		var err error
+		if plan.cursor, err = decodeCursor(req.Cursor); err != nil {
+			return nil, err
+		}
+		plan.seen = plan.cursor.Seen
+		if plan.cursor.Pool > 0 {
+			plan.pool = plan.cursor.Pool
+		}
+	}
+	return plan, nil
+}
+
+// nextCursor is the cursor of the page after one ending at score and id
+func (p *searchPlan) nextCursor(score float64, id, returned int) string {
+	return searchCursor{Score: score, ID: id, Seen: returned, Pool: p.pool}.encode()
+}
+
+// Search implements Searcher. Semantic searches rank frames by cosine
+// similarity of their embedding to the query, text searches by full-text
+// rank of the description, and hybrid searches fuse both rankings with
+// reciprocal rank fusion. Results are ordered by score, then analysis id,
+// which keeps cursors stable.
+func (s *PostgresStorage) Search(ctx context.Context, req models.SearchRequest) (*models.SearchPage, error) {
+	plan, err := planSearch(req)
+	if err != nil {
+		return nil, err
+	}
+	req, limit, cursor, seen := plan.req, plan.limit, plan.cursor, plan.seen
+
+	b := &queryBuilder{}
+	if err := b.filter(req); err != nil {
+		return nil, err
+	}
+	where := func(extra string) string {
+		return "WHERE " + strings.Join(append(append([]string{}, b.where...), extra), " AND ")
+	}
+
+	var vector, tsquery string
+	switch req.Mode {
+	case models.SearchSemantic, models.SearchHybrid:
+		if s.embeddingService == nil {
+			return nil, fmt.Errorf("%w: %s search needs an embedder", ErrInvalidSearch, req.Mode)
+		}
+		embeddingResult := <-s.embeddingService.GetEmbedding(ctx, req.Query)
+		if embeddingResult.Error != nil {
+			return nil, fmt.Errorf("failed to generate query embedding: %w", embeddingResult.Error)
+		}
+		vector = b.arg(pgvector.NewVector(embeddingResult.Embedding))
+	}
+	switch req.Mode {
+	case models.SearchText, models.SearchHybrid:
+		tsquery = fmt.Sprintf("websearch_to_tsquery('%s', %s)", textSearchConfig, b.arg(req.Query))
+	}
+
+	// hits yields id, the searchColumns and score, similarity, text_score,
+	// semantic_rank and text_rank for every matching frame
+	var hits string
+	switch req.Mode {
+	case models.SearchSemantic:
+		hits = `SELECT ` + searchColumns + `,
+		1 - (a.embedding <=> ` + vector + `) AS score, 1 - (a.embedding <=> ` + vector + `) AS similarity,
+		0::float8 AS text_score, 0::bigint AS semantic_rank, 0::bigint AS text_rank
+		` + searchFrom + `
+		` + where("a.embedding IS NOT NULL")
+	case models.SearchText:
+		hits = `SELECT ` + searchColumns + `,
+		ts_rank_cd(a.content_tsv, ` + tsquery + `)::float8 AS score, 0::float8 AS similarity,
+		ts_rank_cd(a.content_tsv, ` + tsquery + `)::float8 AS text_score, 0::bigint AS semantic_rank, 0::bigint AS text_rank
+		` + searchFrom + `
+		` + where("a.content_tsv @@ "+tsquery)
+	case models.SearchHybrid:
+		candidates := b.arg(plan.pool)
+		k := b.arg(rrfK)
+		hits = `WITH semantic AS (
+			SELECT a.id, 1 - (a.embedding <=> ` + vector + `) AS similarity,
+			ROW_NUMBER() OVER (ORDER BY a.embedding <=> ` + vector + `, a.id) AS rank
+			` + searchFrom + `
+			` + where("a.embedding IS NOT NULL") + `
+			ORDER BY a.embedding <=> ` + vector + `, a.id
+			LIMIT ` + candidates + `
+		), lexical AS (
+			SELECT a.id, ts_rank_cd(a.content_tsv, ` + tsquery + `)::float8 AS text_score,
+			ROW_NUMBER() OVER (ORDER BY ts_rank_cd(a.content_tsv, ` + tsquery + `) DESC, a.id) AS rank
+			` + searchFrom + `
+			` + where("a.content_tsv @@ "+tsquery) + `
+			ORDER BY text_score DESC, a.id
+			LIMIT ` + candidates + `
+		), fused AS (
+			SELECT COALESCE(s.id, l.id) AS id,
+			(COALESCE(1.0 / (` + k + ` + s.rank), 0) + COALESCE(1.0 / (` + k + ` + l.rank), 0))::float8 AS score,
+			COALESCE(s.similarity, 0) AS similarity, COALESCE(l.text_score, 0) AS text_score,
+			COALESCE(s.rank, 0) AS semantic_rank, COALESCE(l.rank, 0) AS text_rank
+			FROM semantic s
+			FULL OUTER JOIN lexical l ON s.id = l.id
+		)
+		SELECT ` + searchColumns + `,
+		r.score, r.similarity, r.text_score, r.semantic_rank, r.text_rank
+		FROM fused r
+		JOIN analyses a ON a.id = r.id
+		JOIN frames f ON a.frame_id = f.id
+		JOIN videos v ON f.video_id = v.id`
+	}
+
+	// Every page counts the full result set before skipping to its position
+	filtered := `WITH hits AS (` + hits + `
+	), filtered AS (
+		SELECT *, COUNT(*) OVER () AS total FROM hits WHERE score >= ` + b.arg(req.MinScore) + `
+	)
+	`
+	filteredArgs := len(b.args)
+	page := "TRUE"
+	if cursor != nil {
+		score, id := b.arg(cursor.Score), b.arg(cursor.ID)
+		page = "(score < " + score + " OR (score = " + score + " AND id > " + id + "))"
+	}
+	query := filtered + `SELECT fingerprint, video_name, frame_number, frame_path, timestamp_ms, content,
+		duration_ms, score, similarity, text_score, semantic_rank, text_rank, id, total
+		FROM filtered
+		WHERE ` + page + `
+		ORDER BY score DESC, id
+		LIMIT ` + b.arg(limit) + ` OFFSET ` + b.arg(req.Offset)
+
+	rows, err := s.pool.Query(ctx, query, b.args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search frames: %w", err)
+	}
+	defer rows.Close()
+
+	result := &models.SearchPage{Results: []models.FrameSearchResult{}}
+	var lastID int
+	for rows.Next() {
+		var hit models.FrameSearchResult
+		if err := rows.Scan(&hit.VideoFingerprint, &hit.VideoName, &hit.FrameNumber,
+			&hit.FramePath, &hit.TimestampMs, &hit.Description, &hit.VideoDurationMs,
+			&hit.Score, &hit.Similarity, &hit.TextScore,
+			&hit.SemanticRank, &hit.TextRank, &lastID, &result.Total); err != nil {
+			return nil, fmt.Errorf("failed to scan search results: %w", err)
+		}
+		result.Results = append(result.Results, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read search results: %w", err)
+	}
+
+	// A page past the end still reports how many results there are
+	if len(result.Results) == 0 && (seen > 0 || cursor != nil) {
+		err := s.pool.QueryRow(ctx, filtered+"SELECT COUNT(*) FROM filtered", b.args[:filteredArgs]...).Scan(&result.Total)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count search results: %w", err)
+		}
+	}
+
+	returned := seen + len(result.Results)
+	if len(result.Results) == limit && returned < result.Total {
+		last := result.Results[len(result.Results)-1]
+		result.NextCursor = plan.nextCursor(last.Score, lastID, returned)
+	}
+	return result, nil
+}
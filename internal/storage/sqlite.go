@@ -0,0 +1,707 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bdougie/vision/internal/embeddings"
+	"github.com/bdougie/vision/internal/events"
+	"github.com/bdougie/vision/internal/models"
+	_ "modernc.org/sqlite" // Registers the pure Go sqlite database/sql driver, FTS5 included
+)
+
+//go:embed sqlite_migrations/*.sql
+var sqliteMigrationFiles embed.FS
+
+// SQLiteStorage keeps videos, frames, analyses and embeddings in a single
+// SQLite database file. Full-text search uses an FTS5 index of the
+// descriptions; semantic search compares the query with every stored
+// embedding, which is fast enough for the collections of a single machine.
+type SQLiteStorage struct {
+	db               *sql.DB
+	videoID          int64
+	videoName        string
+	embedder         embeddings.Embedder
+	embeddingService *embeddings.Service
+	publisher        events.Publisher
+}
+
+// SetPublisher implements EventSource, an embedding_stored event is
+// published for every analysis stored with an embedding
+func (s *SQLiteStorage) SetPublisher(publisher events.Publisher) {
+	s.publisher = publisher
+}
+
+// NewSQLiteStorage opens the database at path bound to a video, creating both
+// if needed. Analyses and search queries are embedded with embedder.
+func NewSQLiteStorage(ctx context.Context, path string, video models.VideoMetadata, embedder embeddings.Embedder) (*SQLiteStorage, error) {
+	storage, err := OpenSQLiteStorage(ctx, path, embedder)
+	if err != nil {
+		return nil, err
+	}
+
+	if embedder != nil {
+		if err := storage.checkDimension(ctx); err != nil {
+			storage.Close()
+			return nil, err
+		}
+	}
+
+	if video.Fingerprint == "" {
+		storage.Close()
+		return nil, fmt.Errorf("video '%s' has no fingerprint", video.Name)
+	}
+	err = storage.db.QueryRowContext(ctx,
+		`INSERT INTO videos (fingerprint, name, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (fingerprint) DO UPDATE SET name = excluded.name
+		RETURNING id`,
+		video.Fingerprint, video.Name, time.Now()).Scan(&storage.videoID)
+	if err != nil {
+		storage.Close()
+		return nil, fmt.Errorf("failed to create video entry: %w", err)
+	}
+	storage.videoName = video.Name
+
+	return storage, nil
+}
+
+// OpenSQLiteStorage opens the database at path without binding to a video,
+// for reading, searching and managing stored data. The schema is created or
+// upgraded on open. embedder may be nil when nothing is embedded.
+func OpenSQLiteStorage(ctx context.Context, path string, embedder embeddings.Embedder) (*SQLiteStorage, error) {
+	// Times are written as "2006-01-02 15:04:05.999999999-07:00", which
+	// sorts and compares as text
+	db, err := sql.Open("sqlite", "file:"+path+
+		"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database '%s': %w", path, err)
+	}
+
+	// SQLite has a single writer, one connection avoids busy errors between
+	// the processor's workers
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	storage := &SQLiteStorage{
+		db:       db,
+		embedder: embedder,
+	}
+	if embedder != nil {
+		embeddingWorkers := 4 // Number of concurrent embedding generators
+		storage.embeddingService = embeddings.NewService(embedder, embeddingWorkers)
+	}
+	return storage, nil
+}
+
+// migrateSQLite applies the embedded SQLite migrations newer than the
+// user_version of the database, each in its own transaction
+func migrateSQLite(ctx context.Context, db *sql.DB) error {
+	entries, err := sqliteMigrationFiles.ReadDir("sqlite_migrations")
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var current int
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if current > len(entries) {
+		return fmt.Errorf("database schema version %d is newer than this build (%d)", current, len(entries))
+	}
+
+	for version := current + 1; version <= len(entries); version++ {
+		name := entries[version-1].Name()
+		script, err := sqliteMigrationFiles.ReadFile(path.Join("sqlite_migrations", name))
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %s: %w", name, err)
+		}
+		// PRAGMA does not take parameters
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %s: %w", name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Close closes the database and the embedding workers. It does nothing on a
+// nil storage.
+func (s *SQLiteStorage) Close() {
+	if s == nil {
+		return
+	}
+	if s.embeddingService != nil {
+		s.embeddingService.Close()
+	}
+	if s.db != nil {
+		s.db.Close()
+	}
+}
+
+// encodeEmbedding packs a vector as little-endian float32 values
+func encodeEmbedding(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for i, value := range vector {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(value))
+	}
+	return data
+}
+
+// decodeEmbedding unpacks a vector written by encodeEmbedding
+func decodeEmbedding(data []byte) []float32 {
+	if len(data) == 0 {
+		return nil
+	}
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return vector
+}
+
+// storedDimension returns the length of the stored embeddings, zero when
+// there are none
+func (s *SQLiteStorage) storedDimension(ctx context.Context) (int, error) {
+	var size int
+	err := s.db.QueryRowContext(ctx,
+		"SELECT length(embedding) FROM analyses WHERE embedding IS NOT NULL LIMIT 1").Scan(&size)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to read embedding dimension: %w", err)
+	}
+	return size / 4, nil
+}
+
+// checkDimension refuses to mix embeddings of different models
+func (s *SQLiteStorage) checkDimension(ctx context.Context) error {
+	stored, err := s.storedDimension(ctx)
+	if err != nil {
+		return err
+	}
+	if stored != 0 && stored != s.embedder.Dimension() {
+		return fmt.Errorf("database holds embeddings with %d dimensions but the embedding model produces %d, "+
+			"use the original embedding model or run 'visionanalyzer reindex' to rebuild them", stored, s.embedder.Dimension())
+	}
+	return nil
+}
+
+// structuredJSON renders a structured analysis for its TEXT column
+func structuredJSON(analysis *models.FrameAnalysis) (any, error) {
+	if analysis == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(analysis)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal structured analysis: %w", err)
+	}
+	return string(data), nil
+}
+
+// parseStructured reads a structured analysis column, nil when it is empty
+func parseStructured(column sql.NullString) (*models.FrameAnalysis, error) {
+	if !column.Valid || column.String == "" {
+		return nil, nil
+	}
+	var analysis models.FrameAnalysis
+	if err := json.Unmarshal([]byte(column.String), &analysis); err != nil {
+		return nil, fmt.Errorf("failed to parse structured analysis: %w", err)
+	}
+	return &analysis, nil
+}
+
+// SaveVideoMetadata stores the probed metadata on the video row
+func (s *SQLiteStorage) SaveVideoMetadata(ctx context.Context, meta models.VideoMetadata) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE videos SET
+		duration_ms = ?, container = ?, video_codec = ?, audio_codec = ?,
+		width = ?, height = ?, frame_rate = ?, rotation = ?,
+		has_audio = ?, size_bytes = ?, probed_at = ?
+		WHERE id = ?`,
+		meta.DurationMs, meta.Container, meta.VideoCodec, meta.AudioCodec,
+		meta.Width, meta.Height, meta.FrameRate, meta.Rotation,
+		meta.HasAudio, meta.SizeBytes, time.Now(), s.videoID)
+	if err != nil {
+		return fmt.Errorf("failed to store video metadata: %w", err)
+	}
+	return nil
+}
+
+// SetFrameStatus records the state of a frame, creating the frame row if needed
+func (s *SQLiteStorage) SetFrameStatus(ctx context.Context, status models.FrameStatus) error {
+	frameNum, err := frameNumber(status.Frame)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO frames
+		(video_id, frame_number, frame_path, timestamp_ms, status, status_reason, attempts,
+		phash, duplicate_of, status_updated_at, created_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, NULLIF(?8, ''), NULLIF(?9, ''), ?10, ?10)
+		ON CONFLICT (video_id, frame_number) DO UPDATE
+		SET frame_path = excluded.frame_path, timestamp_ms = excluded.timestamp_ms,
+		status = ?5, status_reason = ?6, attempts = ?7,
+		phash = COALESCE(excluded.phash, frames.phash), duplicate_of = excluded.duplicate_of, status_updated_at = ?10`,
+		s.videoID, frameNum, status.Frame, status.TimestampMs,
+		string(status.Status), status.Reason, status.Attempts, status.Hash, status.DuplicateOf, now)
+	if err != nil {
+		return fmt.Errorf("failed to store frame status: %w", err)
+	}
+	return nil
+}
+
+// FrameStatuses returns the recorded state of every frame of the video
+func (s *SQLiteStorage) FrameStatuses(ctx context.Context) (map[string]models.FrameStatus, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT frame_path, timestamp_ms, status, COALESCE(status_reason, ''), COALESCE(attempts, 0),
+		COALESCE(phash, ''), COALESCE(duplicate_of, '')
+		FROM frames
+		WHERE video_id = ? AND status IS NOT NULL`,
+		s.videoID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query frame statuses: %w", err)
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]models.FrameStatus)
+	for rows.Next() {
+		var status models.FrameStatus
+		var state string
+		if err := rows.Scan(&status.Frame, &status.TimestampMs, &state, &status.Reason, &status.Attempts,
+			&status.Hash, &status.DuplicateOf); err != nil {
+			return nil, fmt.Errorf("failed to scan frame status: %w", err)
+		}
+		status.Status = models.FrameState(state)
+		statuses[status.Frame] = status
+	}
+	return statuses, rows.Err()
+}
+
+// AnalyzedFrames returns the frames of the video that already have an analysis
+func (s *SQLiteStorage) AnalyzedFrames(ctx context.Context) (map[string]bool, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT f.frame_path
+		FROM frames f
+		JOIN analyses a ON a.frame_id = f.id
+		WHERE f.video_id = ?`,
+		s.videoID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query analyzed frames: %w", err)
+	}
+	defer rows.Close()
+
+	analyzed := make(map[string]bool)
+	for rows.Next() {
+		var framePath string
+		if err := rows.Scan(&framePath); err != nil {
+			return nil, fmt.Errorf("failed to scan analyzed frame: %w", err)
+		}
+		analyzed[framePath] = true
+	}
+	return analyzed, rows.Err()
+}
+
+// ResetFrames removes the frames of the video with their analyses and statuses
+func (s *SQLiteStorage) ResetFrames(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM frames WHERE video_id = ?`, s.videoID); err != nil {
+		return fmt.Errorf("failed to remove frames: %w", err)
+	}
+	return nil
+}
+
+// AddResult stores a frame analysis with the embedding of its description
+func (s *SQLiteStorage) AddResult(ctx context.Context, result models.AnalysisResult) error {
+	frameNum, err := frameNumber(result.Frame)
+	if err != nil {
+		return err
+	}
+	structured, err := structuredJSON(result.Structured)
+	if err != nil {
+		return err
+	}
+
+	// The embedding is generated before the write so the database is not
+	// held while the model works
+	var embedding []byte
+	if s.embeddingService != nil {
+		embeddingResult := <-s.embeddingService.GetEmbedding(ctx, result.Content)
+		if embeddingResult.Error != nil {
+			fmt.Printf("Warning: Failed to generate embedding: %v\n", embeddingResult.Error)
+		} else {
+			embedding = encodeEmbedding(embeddingResult.Embedding)
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to store analysis: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var frameID int64
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO frames (video_id, frame_number, frame_path, timestamp_ms, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (video_id, frame_number) DO UPDATE
+		SET frame_path = excluded.frame_path, timestamp_ms = excluded.timestamp_ms
+		RETURNING id`,
+		s.videoID, frameNum, result.Frame, result.TimestampMs, now).Scan(&frameID)
+	if err != nil {
+		return fmt.Errorf("failed to store frame information: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO analyses (frame_id, content, structured, embedding, created_at)
+		VALUES (?1, ?2, ?3, ?4, ?5)
+		ON CONFLICT (frame_id) DO UPDATE
+		SET content = ?2, structured = ?3, embedding = ?4, created_at = ?5`,
+		frameID, result.Content, structured, embedding, now)
+	if err != nil {
+		return fmt.Errorf("failed to store analysis: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to store analysis: %w", err)
+	}
+
+	if embedding != nil && s.publisher != nil {
+		s.publisher.Publish(events.Event{
+			Type:        events.EmbeddingStored,
+			Video:       s.videoName,
+			Frame:       result.Frame,
+			FrameNumber: frameNum,
+			TimestampMs: result.TimestampMs,
+		})
+	}
+	return nil
+}
+
+// Flush implements the Storage interface, results are written as they arrive
+func (s *SQLiteStorage) Flush() error {
+	return nil
+}
+
+// Videos implements Catalog
+func (s *SQLiteStorage) Videos(ctx context.Context) ([]models.VideoSummary, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT v.fingerprint, v.name, COALESCE(v.duration_ms, 0),
+		COALESCE(v.container, ''), COALESCE(v.video_codec, ''), COALESCE(v.audio_codec, ''),
+		COALESCE(v.width, 0), COALESCE(v.height, 0), COALESCE(v.frame_rate, 0),
+		COALESCE(v.rotation, 0), COALESCE(v.has_audio, 0), COALESCE(v.size_bytes, 0),
+		v.created_at, COUNT(f.id), COUNT(a.id),
+		COUNT(f.id) FILTER (WHERE f.status IN ('failed', 'skipped'))
+		FROM videos v
+		LEFT JOIN frames f ON f.video_id = v.id
+		LEFT JOIN analyses a ON a.frame_id = f.id
+		GROUP BY v.id
+		ORDER BY v.created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list videos: %w", err)
+	}
+	defer rows.Close()
+
+	var videos []models.VideoSummary
+	for rows.Next() {
+		var summary models.VideoSummary
+		video := &summary.Video
+		if err := rows.Scan(&video.Fingerprint, &video.Name, &video.DurationMs,
+			&video.Container, &video.VideoCodec, &video.AudioCodec,
+			&video.Width, &video.Height, &video.FrameRate,
+			&video.Rotation, &video.HasAudio, &video.SizeBytes,
+			&summary.CreatedAt, &summary.Frames, &summary.Analyzed, &summary.Failed); err != nil {
+			return nil, fmt.Errorf("failed to scan video: %w", err)
+		}
+		videos = append(videos, summary)
+	}
+	return videos, rows.Err()
+}
+
+// FindVideo implements Catalog
+func (s *SQLiteStorage) FindVideo(ctx context.Context, ref string) (*models.VideoMetadata, error) {
+	videos, err := s.Videos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return matchVideo(videos, ref)
+}
+
+// Frames implements Catalog
+func (s *SQLiteStorage) Frames(ctx context.Context, video models.VideoMetadata) ([]models.FrameRecord, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT f.frame_path, f.frame_number, f.timestamp_ms,
+		COALESCE(f.status, ''), COALESCE(f.status_reason, ''), COALESCE(f.attempts, 0),
+		COALESCE(f.phash, ''), COALESCE(f.duplicate_of, ''),
+		COALESCE(a.content, da.content, ''), COALESCE(a.structured, da.structured)
+		FROM frames f
+		JOIN videos v ON f.video_id = v.id
+		LEFT JOIN analyses a ON a.frame_id = f.id
+		LEFT JOIN frames d ON d.video_id = f.video_id AND d.frame_path = f.duplicate_of
+		LEFT JOIN analyses da ON da.frame_id = d.id
+		WHERE v.fingerprint = ?
+		ORDER BY f.frame_number`,
+		video.Fingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query frames: %w", err)
+	}
+	defer rows.Close()
+
+	var frames []models.FrameRecord
+	for rows.Next() {
+		var frame models.FrameRecord
+		var state string
+		var structured sql.NullString
+		if err := rows.Scan(&frame.Frame, &frame.FrameNumber, &frame.TimestampMs,
+			&state, &frame.Reason, &frame.Attempts, &frame.Hash, &frame.DuplicateOf,
+			&frame.Content, &structured); err != nil {
+			return nil, fmt.Errorf("failed to scan frame: %w", err)
+		}
+		frame.Status = models.FrameState(state)
+		if frame.Structured, err = parseStructured(structured); err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, rows.Err()
+}
+
+// DeleteVideo implements Catalog, frames and analyses are removed by cascade
+func (s *SQLiteStorage) DeleteVideo(ctx context.Context, video models.VideoMetadata) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM videos WHERE fingerprint = ?", video.Fingerprint)
+	if err != nil {
+		return fmt.Errorf("failed to delete video '%s': %w", video.Name, err)
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return fmt.Errorf("%w: '%s'", ErrVideoNotFound, video.Name)
+	}
+	return nil
+}
+
+// Reindex embeds the stored analyses again with the current embedder, for
+// one video or for all of them when video is nil, and returns how many were
+// updated. Only a full reindex may switch to a model of another dimension.
+func (s *SQLiteStorage) Reindex(ctx context.Context, video *models.VideoMetadata) (int, error) {
+	if s.embedder == nil {
+		return 0, fmt.Errorf("no embedder configured")
+	}
+
+	query := `SELECT a.id, a.content FROM analyses a ORDER BY a.id`
+	var args []any
+	if video != nil {
+		if err := s.checkDimension(ctx); err != nil {
+			return 0, err
+		}
+		query = `SELECT a.id, a.content
+		FROM analyses a
+		JOIN frames f ON a.frame_id = f.id
+		JOIN videos v ON f.video_id = v.id
+		WHERE v.fingerprint = ?
+		ORDER BY a.id`
+		args = []any{video.Fingerprint}
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query analyses: %w", err)
+	}
+	var ids []int64
+	var contents []string
+	for rows.Next() {
+		var id int64
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan analysis: %w", err)
+		}
+		ids = append(ids, id)
+		contents = append(contents, content)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read analyses: %w", err)
+	}
+
+	updated := 0
+	for start := 0; start < len(ids); start += reindexBatchSize {
+		end := min(start+reindexBatchSize, len(ids))
+		vectors, err := s.embedder.Embed(ctx, contents[start:end])
+		if err != nil {
+			return updated, fmt.Errorf("failed to embed analyses: %w", err)
+		}
+
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return updated, fmt.Errorf("failed to store embeddings: %w", err)
+		}
+		for i, vector := range vectors {
+			if _, err := tx.ExecContext(ctx, "UPDATE analyses SET embedding = ? WHERE id = ?", encodeEmbedding(vector), ids[start+i]); err != nil {
+				tx.Rollback()
+				return updated, fmt.Errorf("failed to store embeddings: %w", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return updated, fmt.Errorf("failed to store embeddings: %w", err)
+		}
+		updated += end - start
+		fmt.Printf("Reindexed %d/%d analyses\n", updated, len(ids))
+	}
+	return updated, nil
+}
+
+// Search implements Searcher with the same modes, filters, scores and paging
+// as PostgresStorage.Search. Text scores are the negated FTS5 bm25 rank, so
+// higher is better as with the other backends.
+func (s *SQLiteStorage) Search(ctx context.Context, req models.SearchRequest) (*models.SearchPage, error) {
+	plan, err := planSearch(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var query []float32
+	if plan.req.Mode != models.SearchText {
+		if s.embeddingService == nil {
+			return nil, fmt.Errorf("%w: %s search needs an embedder", ErrInvalidSearch, plan.req.Mode)
+		}
+		embeddingResult := <-s.embeddingService.GetEmbedding(ctx, plan.req.Query)
+		if embeddingResult.Error != nil {
+			return nil, fmt.Errorf("failed to generate query embedding: %w", embeddingResult.Error)
+		}
+		query = embeddingResult.Embedding
+		if err := s.checkDimension(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var textScores map[int]float64
+	if plan.req.Mode != models.SearchSemantic {
+		if textScores, err = s.textScores(ctx, plan.req.Query); err != nil {
+			return nil, err
+		}
+	}
+
+	candidates, err := s.searchCandidates(ctx, query != nil)
+	if err != nil {
+		return nil, err
+	}
+	return rankCandidates(plan, candidates, query, textScores), nil
+}
+
+// searchCandidates loads every stored analysis with its frame and video,
+// with embeddings only when they are needed
+func (s *SQLiteStorage) searchCandidates(ctx context.Context, withEmbeddings bool) ([]frameCandidate, error) {
+	embedding := "NULL"
+	if withEmbeddings {
+		embedding = "a.embedding"
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT a.id, v.fingerprint, v.name, f.frame_number, f.frame_path, f.timestamp_ms,
+		a.content, COALESCE(v.duration_ms, 0), a.structured, `+embedding+`, v.created_at
+		FROM analyses a
+		JOIN frames f ON a.frame_id = f.id
+		JOIN videos v ON f.video_id = v.id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search frames: %w", err)
+	}
+	defer rows.Close()
+
+	var candidates []frameCandidate
+	for rows.Next() {
+		var c frameCandidate
+		var structured sql.NullString
+		var vector []byte
+		if err := rows.Scan(&c.ID, &c.Hit.VideoFingerprint, &c.Hit.VideoName, &c.Hit.FrameNumber,
+			&c.Hit.FramePath, &c.Hit.TimestampMs, &c.Hit.Description, &c.Hit.VideoDurationMs,
+			&structured, &vector, &c.StoredAt); err != nil {
+			return nil, fmt.Errorf("failed to scan search results: %w", err)
+		}
+		if c.Structured, err = parseStructured(structured); err != nil {
+			return nil, err
+		}
+		c.Embedding = decodeEmbedding(vector)
+		candidates = append(candidates, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read search results: %w", err)
+	}
+	return candidates, nil
+}
+
+// textScores returns the full-text score of every analysis matching query
+func (s *SQLiteStorage) textScores(ctx context.Context, query string) (map[int]float64, error) {
+	scores := make(map[int]float64)
+	match := ftsQuery(query)
+	if match == "" {
+		return scores, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT rowid, -bm25(analyses_fts) FROM analyses_fts WHERE analyses_fts MATCH ?", match)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search descriptions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var score float64
+		if err := rows.Scan(&id, &score); err != nil {
+			return nil, fmt.Errorf("failed to scan text scores: %w", err)
+		}
+		scores[id] = score
+	}
+	return scores, rows.Err()
+}
+
+// ftsQuery translates a web search style query, see parseWebQuery, into an
+// FTS5 query. Every term is quoted so FTS5 syntax in the input is matched as
+// text. A query without anything to match returns an empty string.
+func ftsQuery(query string) string {
+	parsed := parseWebQuery(query)
+	if len(parsed.groups) == 0 {
+		return ""
+	}
+
+	quote := func(term string) string {
+		return `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	conditions := make([]string, len(parsed.groups))
+	for i, group := range parsed.groups {
+		terms := make([]string, len(group))
+		for j, term := range group {
+			terms[j] = quote(term)
+		}
+		conditions[i] = "(" + strings.Join(terms, " OR ") + ")"
+	}
+	match := strings.Join(conditions, " AND ")
+	for _, term := range parsed.excluded {
+		match = "(" + match + ") NOT " + quote(term)
+	}
+	return match
+}
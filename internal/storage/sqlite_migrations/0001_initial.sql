@@ -0,0 +1,63 @@
+-- Schema of the SQLite backend. Embeddings are little-endian float32 BLOBs
+-- compared by brute force, descriptions are indexed for full-text search by
+-- the analyses_fts table kept in sync by triggers.
+CREATE TABLE videos (
+    id INTEGER PRIMARY KEY,
+    fingerprint TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    duration_ms INTEGER,
+    container TEXT,
+    video_codec TEXT,
+    audio_codec TEXT,
+    width INTEGER,
+    height INTEGER,
+    frame_rate REAL,
+    rotation INTEGER,
+    has_audio BOOLEAN,
+    size_bytes INTEGER,
+    probed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE frames (
+    id INTEGER PRIMARY KEY,
+    video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+    frame_number INTEGER NOT NULL,
+    frame_path TEXT NOT NULL,
+    timestamp_ms INTEGER NOT NULL DEFAULT 0,
+    status TEXT,
+    status_reason TEXT,
+    attempts INTEGER,
+    status_updated_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE(video_id, frame_number)
+);
+
+CREATE TABLE analyses (
+    id INTEGER PRIMARY KEY,
+    frame_id INTEGER NOT NULL UNIQUE REFERENCES frames(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    structured TEXT,
+    embedding BLOB,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE VIRTUAL TABLE analyses_fts USING fts5(
+    content,
+    content = 'analyses',
+    content_rowid = 'id',
+    tokenize = 'porter unicode61'
+);
+
+CREATE TRIGGER analyses_fts_insert AFTER INSERT ON analyses BEGIN
+    INSERT INTO analyses_fts(rowid, content) VALUES (new.id, new.content);
+END;
+
+CREATE TRIGGER analyses_fts_delete AFTER DELETE ON analyses BEGIN
+    INSERT INTO analyses_fts(analyses_fts, rowid, content) VALUES ('delete', old.id, old.content);
+END;
+
+CREATE TRIGGER analyses_fts_update AFTER UPDATE OF content ON analyses BEGIN
+    INSERT INTO analyses_fts(analyses_fts, rowid, content) VALUES ('delete', old.id, old.content);
+    INSERT INTO analyses_fts(rowid, content) VALUES (new.id, new.content);
+END;
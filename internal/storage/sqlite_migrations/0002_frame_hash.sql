@@ -0,0 +1,4 @@
+-- Perceptual hash of every frame, frames skipped as near-duplicates link to
+-- the analyzed frame they look like by its frame_path
+ALTER TABLE frames ADD COLUMN phash TEXT;
+ALTER TABLE frames ADD COLUMN duplicate_of TEXT;
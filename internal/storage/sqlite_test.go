@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bdougie/vision/internal/models"
+)
+
+// TestSQLiteStorage needs no build tags or cgo, the driver is pure Go with FTS5
+func TestSQLiteStorage(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "vision.db")
+	before := time.Now().Add(-time.Second)
+
+	video := models.VideoMetadata{Fingerprint: "abc123", Name: "street.mp4", DurationMs: 10000}
+	store, err := NewSQLiteStorage(ctx, path, video, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.SaveVideoMetadata(ctx, video); err != nil {
+		t.Fatal(err)
+	}
+	for i, content := range []string{"A red car parked outside.", "Two dogs run across the street.", "An empty street at night."} {
+		result := models.AnalysisResult{Frame: fmt.Sprintf("frame_%04d.jpg", i+1), TimestampMs: int64(i) * 1000, Content: content}
+		if err := store.AddResult(ctx, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	videos, err := store.Videos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videos) != 1 || videos[0].Analyzed != 3 || videos[0].CreatedAt.Before(before) || videos[0].CreatedAt.After(time.Now()) {
+		t.Fatalf("videos %+v, want one with 3 analyses stored just now", videos)
+	}
+
+	tests := []struct {
+		name string
+		req  models.SearchRequest
+		want []string
+	}{
+		// FTS5 with the porter stemmer, dogs matches dog
+		{"text", models.SearchRequest{Query: "dog", Mode: models.SearchText}, []string{"frame_0002.jpg"}},
+		{"text or", models.SearchRequest{Query: "car or night", Mode: models.SearchText}, []string{"frame_0001.jpg", "frame_0003.jpg"}},
+		{"stored after", models.SearchRequest{Query: "street", Mode: models.SearchText, StoredAfter: &before}, []string{"frame_0002.jpg", "frame_0003.jpg"}},
+		{"stored before", models.SearchRequest{Query: "street", Mode: models.SearchText, StoredBefore: &before}, nil},
+	}
+	for _, tt := range tests {
+		page, err := store.Search(ctx, tt.req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got []string
+		for _, result := range page.Results {
+			got = append(got, result.FramePath)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: results %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSQLiteSetFrameStatus(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewSQLiteStorage(ctx, filepath.Join(t.TempDir(), "vision.db"), models.VideoMetadata{Fingerprint: "abc123", Name: "street.mp4"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// A replaced frame keeps its file name but moves to another timestamp
+	if err := store.AddResult(ctx, models.AnalysisResult{Frame: "frame_0002.jpg", TimestampMs: 1000, Content: "A red car."}); err != nil {
+		t.Fatal(err)
+	}
+	status := models.FrameStatus{Frame: "frame_0002.jpg", TimestampMs: 1500, Status: models.FrameRejected, Reason: "black frame"}
+	if err := store.SetFrameStatus(ctx, status); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := store.FrameStatuses(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := statuses["frame_0002.jpg"]; len(statuses) != 1 || got.TimestampMs != 1500 || got.Status != models.FrameRejected {
+		t.Errorf("statuses %+v, want frame_0002.jpg rejected at 1500ms", statuses)
+	}
+}
@@ -1,82 +1,399 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/bdougie/vision/internal/embeddings"
+	"github.com/bdougie/vision/internal/events"
 	"github.com/bdougie/vision/internal/models"
 )
 
 const batchSize = 10 // Number of results to batch write
 
+// File names used by FileStorage inside the video directory
+const (
+    resultsFileName    = "analysis_results.json"
+    resultsLogFileName = "analysis_results.jsonl"
+    statusFileName     = "frame_status.json"
+    statusLogFileName  = "frame_status.jsonl"
+    videoFileName      = "video.json"
+)
+
 // Storage defines the interface for storing analysis results
 type Storage interface {
 	// AddResult adds a single analysis result
 	AddResult(ctx context.Context, result models.AnalysisResult) error
 
+	// SaveVideoMetadata records the probed metadata of the video being analyzed
+	SaveVideoMetadata(ctx context.Context, meta models.VideoMetadata) error
+
+	// SetFrameStatus records the final state of a frame
+	SetFrameStatus(ctx context.Context, status models.FrameStatus) error
+
+	// FrameStatuses returns the recorded state of every frame, keyed by frame file
+	FrameStatuses(ctx context.Context) (map[string]models.FrameStatus, error)
+
+	// AnalyzedFrames returns the frame files that already have a stored analysis
+	AnalyzedFrames(ctx context.Context) (map[string]bool, error)
+
+	// ResetFrames removes the analyses and statuses of every frame of the
+	// video, whose frames were extracted again under the same file names
+	ResetFrames(ctx context.Context) error
+
 	// Flush ensures all pending results are saved
 	Flush() error
 }
 
-// FileStorage implements Storage interface for file-based storage
+// EventSource is implemented by storage that reports progress events of its
+// own, such as stored embeddings
+type EventSource interface {
+	SetPublisher(publisher events.Publisher)
+}
+
+// FileStorage implements Storage interface for file-based storage. Results and
+// frame statuses are appended to JSONL logs as they arrive so a crash keeps
+// everything analyzed so far; Flush compacts the logs and writes the JSON
+// snapshots other tools read.
 type FileStorage struct {
     outputDir string
-    videoName string
-    results   []models.AnalysisResult
+    videoKey  string
+    embedder  embeddings.Embedder
+    publisher events.Publisher
+    added     int
     mu        sync.Mutex  // Add mutex for thread safety
 }
 
-// NewFileStorage creates a new file-based storage
-func NewFileStorage(outputDir, videoName string) *FileStorage {
+// storedResult is an analysis result as written to the results files, with
+// the embedding of its content for search
+type storedResult struct {
+    models.AnalysisResult
+    Embedding []float32 `json:"embedding,omitempty"`
+}
+
+// storedVideo is the video metadata as written to video.json, with when the
+// video was first stored
+type storedVideo struct {
+    models.VideoMetadata
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// readVideo reads a video.json. Files written before created_at was
+// recorded fall back to their modification time.
+func readVideo(path string) (*storedVideo, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    var video storedVideo
+    if err := json.Unmarshal(data, &video); err != nil {
+        return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+    }
+    if video.CreatedAt.IsZero() {
+        if info, err := os.Stat(path); err == nil {
+            video.CreatedAt = info.ModTime()
+        }
+    }
+    return &video, nil
+}
+
+// NewFileStorage creates a new file-based storage. Results are written to the
+// directory under outputDir named after the video key, see models.VideoMetadata.Key.
+// Their content is embedded with embedder, which may be nil to store results
+// that are only found by text search.
+func NewFileStorage(outputDir, videoKey string, embedder embeddings.Embedder) *FileStorage {
     return &FileStorage{
         outputDir: outputDir,
-        videoName: videoName,
-        results:   []models.AnalysisResult{},
+        videoKey:  videoKey,
+        embedder:  embedder,
     }
 }
 
-// AddResult adds a single analysis result
+// SetPublisher implements EventSource, an embedding_stored event is
+// published for every result stored with an embedding
+func (s *FileStorage) SetPublisher(publisher events.Publisher) {
+    s.publisher = publisher
+}
+
+// dir returns the video directory
+func (s *FileStorage) dir() string {
+    return filepath.Join(s.outputDir, s.videoKey)
+}
+
+// AddResult appends a single analysis result with its embedding to the results log
 func (s *FileStorage) AddResult(ctx context.Context, result models.AnalysisResult) error {
+    stored := storedResult{AnalysisResult: result}
+    if s.embedder != nil {
+        // Without an embedding the result is kept but only found by text search
+        vectors, err := s.embedder.Embed(ctx, []string{result.Content})
+        if err != nil {
+            fmt.Printf("Warning: Failed to generate embedding: %v\n", err)
+        } else {
+            stored.Embedding = vectors[0]
+        }
+    }
+
     s.mu.Lock()
     defer s.mu.Unlock()
-    s.results = append(s.results, result)
+
+    if err := s.appendLog(resultsLogFileName, stored); err != nil {
+        return err
+    }
+    s.added++
+
+    if stored.Embedding != nil && s.publisher != nil {
+        frameNum, _ := frameNumber(result.Frame)
+        s.publisher.Publish(events.Event{
+            Type:        events.EmbeddingStored,
+            Frame:       result.Frame,
+            FrameNumber: frameNum,
+            TimestampMs: result.TimestampMs,
+        })
+    }
     return nil
 }
 
-// Flush writes all results to a JSON file
-func (s *FileStorage) Flush() error {
+// SaveVideoMetadata writes the video metadata to video.json next to the
+// frames. The time the video was first stored is kept when it is rewritten.
+func (s *FileStorage) SaveVideoMetadata(ctx context.Context, meta models.VideoMetadata) error {
+    frameDirPath := s.dir()
+    if err := os.MkdirAll(frameDirPath, 0755); err != nil {
+        return fmt.Errorf("failed to create output directory: %w", err)
+    }
+
+    path := filepath.Join(frameDirPath, videoFileName)
+    video := storedVideo{VideoMetadata: meta, CreatedAt: time.Now().UTC()}
+    if previous, err := readVideo(path); err == nil {
+        video.CreatedAt = previous.CreatedAt
+    }
+
+    data, err := json.MarshalIndent(video, "", "  ")
+    if err != nil {
+        return fmt.Errorf("failed to marshal video metadata: %w", err)
+    }
+
+    if err := os.WriteFile(path, data, 0644); err != nil {
+        return fmt.Errorf("failed to write video metadata: %w", err)
+    }
+    return nil
+}
+
+// SetFrameStatus appends the state of a frame to the status log
+func (s *FileStorage) SetFrameStatus(ctx context.Context, status models.FrameStatus) error {
     s.mu.Lock()
     defer s.mu.Unlock()
-    
-    if len(s.results) == 0 {
+    return s.appendLog(statusLogFileName, status)
+}
+
+// FrameStatuses returns the latest status of every frame
+func (s *FileStorage) FrameStatuses(ctx context.Context) (map[string]models.FrameStatus, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.loadStatuses()
+}
+
+// loadStatuses returns the latest status of every frame
+func (s *FileStorage) loadStatuses() (map[string]models.FrameStatus, error) {
+    statuses := make(map[string]models.FrameStatus)
+    err := s.load(statusFileName, statusLogFileName, func(decode func(any) error) error {
+        var status models.FrameStatus
+        if err := decode(&status); err != nil {
+            return err
+        }
+        // A status without a hash keeps the one recorded before
+        if status.Hash == "" {
+            status.Hash = statuses[status.Frame].Hash
+        }
+        statuses[status.Frame] = status
         return nil
+    })
+    if err != nil {
+        return nil, fmt.Errorf("failed to load frame statuses: %w", err)
     }
-    
-    // Create output directory if it doesn't exist
-    frameDirPath := filepath.Join(s.outputDir, s.videoName)
-    if err := os.MkdirAll(frameDirPath, 0755); err != nil {
+    return statuses, nil
+}
+
+// AnalyzedFrames returns the frames with a saved result, including ones from interrupted runs
+func (s *FileStorage) AnalyzedFrames(ctx context.Context) (map[string]bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    results, err := s.loadResults()
+    if err != nil {
+        return nil, err
+    }
+    analyzed := make(map[string]bool, len(results))
+    for frame := range results {
+        analyzed[frame] = true
+    }
+    return analyzed, nil
+}
+
+// ResetFrames removes the results and statuses, snapshots and logs alike
+func (s *FileStorage) ResetFrames(ctx context.Context) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, name := range []string{resultsFileName, resultsLogFileName, statusFileName, statusLogFileName} {
+        if err := os.Remove(filepath.Join(s.dir(), name)); err != nil && !os.IsNotExist(err) {
+            return fmt.Errorf("failed to remove %s: %w", name, err)
+        }
+    }
+    s.added = 0
+    return nil
+}
+
+// loadResults returns the latest result of every frame
+func (s *FileStorage) loadResults() (map[string]storedResult, error) {
+    results := make(map[string]storedResult)
+    err := s.load(resultsFileName, resultsLogFileName, func(decode func(any) error) error {
+        var result storedResult
+        if err := decode(&result); err != nil {
+            return err
+        }
+        results[result.Frame] = result
+        return nil
+    })
+    if err != nil {
+        return nil, fmt.Errorf("failed to load results: %w", err)
+    }
+    return results, nil
+}
+
+// appendLog writes record as one JSON line to a log file and syncs it to disk
+func (s *FileStorage) appendLog(name string, record any) error {
+    if err := os.MkdirAll(s.dir(), 0755); err != nil {
         return fmt.Errorf("failed to create output directory: %w", err)
     }
-    
-    // Create the results file
-    filePath := filepath.Join(frameDirPath, "analysis_results.json")
-    
-    // Convert results to JSON
-    data, err := json.MarshalIndent(s.results, "", "  ")
+
+    line, err := json.Marshal(record)
     if err != nil {
-        return fmt.Errorf("failed to marshal results: %w", err)
+        return fmt.Errorf("failed to marshal %s record: %w", name, err)
+    }
+
+    file, err := os.OpenFile(filepath.Join(s.dir(), name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+    if err != nil {
+        return fmt.Errorf("failed to open %s: %w", name, err)
+    }
+    defer file.Close()
+
+    if _, err := file.Write(append(line, '\n')); err != nil {
+        return fmt.Errorf("failed to append to %s: %w", name, err)
     }
-    
-    // Write to file
-    if err := os.WriteFile(filePath, data, 0644); err != nil {
-        return fmt.Errorf("failed to write results file: %w", err)
+    return file.Sync()
+}
+
+// load feeds every record of the JSON snapshot and then of the JSONL log to
+// visit, so later log entries override the snapshot. A torn last line left by
+// a crash is ignored.
+func (s *FileStorage) load(snapshotName, logName string, visit func(decode func(any) error) error) error {
+    data, err := os.ReadFile(filepath.Join(s.dir(), snapshotName))
+    if err == nil {
+        var records []json.RawMessage
+        if err := json.Unmarshal(data, &records); err != nil {
+            return fmt.Errorf("failed to parse %s: %w", snapshotName, err)
+        }
+        for _, record := range records {
+            if err := visit(func(v any) error { return json.Unmarshal(record, v) }); err != nil {
+                return fmt.Errorf("failed to parse %s: %w", snapshotName, err)
+            }
+        }
+    } else if !os.IsNotExist(err) {
+        return err
+    }
+
+    data, err = os.ReadFile(filepath.Join(s.dir(), logName))
+    if os.IsNotExist(err) {
+        return nil
+    } else if err != nil {
+        return err
+    }
+
+    lines := bytes.Split(data, []byte("\n"))
+    for i, line := range lines {
+        if len(bytes.TrimSpace(line)) == 0 {
+            continue
+        }
+        if err := visit(func(v any) error { return json.Unmarshal(line, v) }); err != nil {
+            if i == len(lines)-1 {
+                break
+            }
+            return fmt.Errorf("failed to parse %s line %d: %w", logName, i+1, err)
+        }
+    }
+    return nil
+}
+
+// Flush compacts the logs into analysis_results.json and frame_status.json
+func (s *FileStorage) Flush() error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    results, err := s.loadResults()
+    if err != nil {
+        return err
+    }
+    resultList := make([]storedResult, 0, len(results))
+    for _, result := range results {
+        resultList = append(resultList, result)
+    }
+    sort.Slice(resultList, func(i, j int) bool { return resultList[i].Frame < resultList[j].Frame })
+
+    statuses, err := s.loadStatuses()
+    if err != nil {
+        return err
+    }
+    statusList := make([]models.FrameStatus, 0, len(statuses))
+    for _, status := range statuses {
+        statusList = append(statusList, status)
+    }
+    sort.Slice(statusList, func(i, j int) bool { return statusList[i].Frame < statusList[j].Frame })
+
+    if len(resultList) > 0 {
+        if err := s.compact(resultsFileName, resultsLogFileName, resultList); err != nil {
+            return err
+        }
+        fmt.Printf("Saved %d analysis results (%d new) to %s\n", len(resultList), s.added, filepath.Join(s.dir(), resultsFileName))
+    }
+    if len(statusList) > 0 {
+        if err := s.compact(statusFileName, statusLogFileName, statusList); err != nil {
+            return err
+        }
+    }
+
+    s.added = 0
+    return nil
+}
+
+// compact writes records to the snapshot file and then removes the log they
+// were read from. The snapshot is replaced atomically before the log is
+// removed, so a crash never loses records.
+func (s *FileStorage) compact(snapshotName, logName string, records any) error {
+    data, err := json.MarshalIndent(records, "", "  ")
+    if err != nil {
+        return fmt.Errorf("failed to marshal %s: %w", snapshotName, err)
+    }
+
+    path := filepath.Join(s.dir(), snapshotName)
+    tmpPath := path + ".tmp"
+    if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+        return fmt.Errorf("failed to write %s: %w", snapshotName, err)
+    }
+    if err := os.Rename(tmpPath, path); err != nil {
+        return fmt.Errorf("failed to replace %s: %w", snapshotName, err)
+    }
+
+    // Everything in the log is now in the snapshot
+    if err := os.Remove(filepath.Join(s.dir(), logName)); err != nil && !os.IsNotExist(err) {
+        return fmt.Errorf("failed to remove %s: %w", logName, err)
     }
-    
-    fmt.Printf("Saved %d analysis results to %s\n", len(s.results), filePath)
-    s.results = nil // Clear after saving
     return nil
 }